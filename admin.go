@@ -14,14 +14,14 @@ func (cfg *apiConfig) deleteUsersHandler() http.HandlerFunc {
 		}
 
 		// Count users before delete
-		numUsers, err := cfg.db.CountUsers(r.Context())
+		numUsers, err := cfg.users.CountUsers(r.Context())
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
 		// Delete users, log number deleted
-		err = cfg.db.DeleteUsers(r.Context())
+		err = cfg.users.DeleteUsers(r.Context())
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Scopes that limit what app access tokens (oauth.go) and personal access tokens (personal_access_tokens.go) can do
+const (
+	SCOPE_CHIRPS_WRITE = "chirps:write"
+)
+
+// Shown on the consent screen
+var tokenScopes = map[string]string{
+	SCOPE_CHIRPS_WRITE: "Post and delete chirps as you",
+}
+
+var errInsufficientScope = errors.New("token is missing the required scope")
+
+// Returns the user the request's bearer token belongs to
+// Chirpy's own JWTs have full access. App access tokens and personal access tokens are only accepted
+// if they were granted the required scope; endpoints they must never use (ex: changing the password) pass ""
+func (cfg *apiConfig) authenticateBearer(r *http.Request, requiredScope string) (uuid.UUID, error) {
+	userID, err := cfg.bearerTokenUserID(r, requiredScope)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	setRequestUserID(r.Context(), userID)
+	return userID, nil
+}
+
+func (cfg *apiConfig) bearerTokenUserID(r *http.Request, requiredScope string) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(token, auth.OAUTH_ACCESS_TOKEN_PREFIX):
+		accessToken, err := cfg.db.GetOAuthAccessToken(r.Context(), auth.HashToken(token))
+		if err == sql.ErrNoRows {
+			return uuid.Nil, fmt.Errorf("app access token not found, may be revoked")
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		if accessToken.ExpiresAt.Before(time.Now()) {
+			return uuid.Nil, fmt.Errorf("app access token expired at %v", accessToken.ExpiresAt)
+		}
+
+		if requiredScope == "" || !slices.Contains(accessToken.Scopes, requiredScope) {
+			return uuid.Nil, fmt.Errorf("%w %q, app %v has %v", errInsufficientScope, requiredScope, accessToken.AppID, accessToken.Scopes)
+		}
+
+		return accessToken.UserID, nil
+	case strings.HasPrefix(token, auth.PERSONAL_ACCESS_TOKEN_PREFIX):
+		personalToken, err := cfg.db.GetPersonalAccessTokenByHash(r.Context(), auth.HashToken(token))
+		if err == sql.ErrNoRows {
+			return uuid.Nil, fmt.Errorf("personal access token not found, may be revoked")
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		if personalToken.ExpiresAt.Valid && personalToken.ExpiresAt.Time.Before(time.Now()) {
+			return uuid.Nil, fmt.Errorf("personal access token %v expired at %v", personalToken.ID, personalToken.ExpiresAt.Time)
+		}
+
+		if requiredScope == "" || !slices.Contains(personalToken.Scopes, requiredScope) {
+			return uuid.Nil, fmt.Errorf("%w %q, personal access token %v has %v", errInsufficientScope, requiredScope, personalToken.ID, personalToken.Scopes)
+		}
+
+		err = cfg.db.SetPersonalAccessTokenLastUsed(r.Context(), database.SetPersonalAccessTokenLastUsedParams{
+			ID:         personalToken.ID,
+			LastUsedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		})
+		if err != nil {
+			return uuid.Nil, err
+		}
+
+		return personalToken.UserID, nil
+	default:
+		return auth.ValidateToken(token, cfg.jwtSecret)
+	}
+}
+
+// 403 if the token is valid but lacks the scope, 401 otherwise
+func sendBearerAuthErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientScope) {
+		sendErrorJSONResponse(w, "Token does not allow this action", http.StatusForbidden, err)
+		return
+	}
+
+	sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/LamontBanks/Chirpy/internal/auth"
 	"github.com/LamontBanks/Chirpy/internal/database"
 	"github.com/google/uuid"
 )
@@ -35,24 +33,17 @@ func (cfg *apiConfig) postChirpHandler() http.HandlerFunc {
 		}{}
 
 		// Validate Authorization Token
-		// 1. Token exists
-		token, err := auth.GetBearerToken(r.Header)
+		// 1. Token exists, is valid (not expired, etc.), and allows posting chirps
+		userIDFromToken, err := cfg.authenticateBearer(r, SCOPE_CHIRPS_WRITE)
 		if err != nil {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			sendBearerAuthErrorResponse(w, err)
 			return
 		}
 
-		// 2. Token is valid (not expired, etc.)
-		userIDFromToken, err := auth.ValidateToken(token, cfg.jwtSecret)
-		if err != nil {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
-			return
-		}
-
-		// 3. Token is associated with a registered user
-		_, err = cfg.db.GetUser(r.Context(), userIDFromToken)
+		// 2. Token is associated with a registered user, whose plan sets the limits
+		entitlements, err := cfg.entitlementsFor(r.Context(), userIDFromToken)
 		if err == sql.ErrNoRows {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusBadRequest, fmt.Errorf("invalid user %v", userIDFromToken))
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("invalid user %v", userIDFromToken))
 			return
 		}
 		if err != nil {
@@ -60,28 +51,30 @@ func (cfg *apiConfig) postChirpHandler() http.HandlerFunc {
 			return
 		}
 
+		if wait := cfg.chirpRateLimitWait(userIDFromToken, entitlements); wait > 0 {
+			sendTooManyRequestsResponse(w, "Too many chirps, try again later", wait, fmt.Errorf("chirp posting rate limited for user %v", userIDFromToken))
+			return
+		}
+
 		// Decode request, validate body
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&req)
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
 
 		// Validate submitted text
 		chirpText := req.Body
 		if len(chirpText) == 0 {
-			sendErrorJSONResponse(w, "Chirp cannot be empty", http.StatusBadRequest, nil)
+			sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_REQUIRED, Message: "Chirp cannot be empty"})
 			return
 		}
-		if len(chirpText) > 140 {
-			sendErrorJSONResponse(w, "Chirp is too long", http.StatusBadRequest, nil)
+		if len(chirpText) > entitlements.MaxChirpLength {
+			sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_TOO_LONG, Message: fmt.Sprintf("Chirp is too long, your plan allows %v characters", entitlements.MaxChirpLength)})
 			return
 		}
 		chirpText = censoredBannedWords(chirpText)
 
 		// Create chirp in database
-		savedChirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
+		savedChirp, err := cfg.chirps.CreateChirp(r.Context(), database.CreateChirpParams{
 			ID:        uuid.New(),
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
@@ -93,14 +86,17 @@ func (cfg *apiConfig) postChirpHandler() http.HandlerFunc {
 			return
 		}
 
-		// Response
-		SendJSONResponse(w, http.StatusCreated, Chirp{
+		chirp := Chirp{
 			ID:        savedChirp.ID,
 			CreatedAt: savedChirp.CreatedAt,
 			UpdatedAt: savedChirp.UpdatedAt,
 			UserID:    savedChirp.UserID,
 			Body:      savedChirp.Body,
-		})
+		}
+		cfg.publishWebhookEvent(r.Context(), WEBHOOK_EVENT_CHIRP_CREATED, chirp)
+
+		// Response
+		SendJSONResponse(w, http.StatusCreated, chirp)
 	}
 }
 
@@ -114,11 +110,11 @@ func (cfg *apiConfig) getChirps() http.HandlerFunc {
 		if author_id != "" { // By author_id
 			author_uuid, err := uuid.Parse(author_id)
 			if err != nil {
-				sendErrorJSONResponse(w, "author not found", http.StatusNotFound, err)
+				sendErrorJSONResponse(w, "author_id must be a user ID", http.StatusBadRequest, err)
 				return
 			}
 
-			c, err := cfg.db.GetChirpsByUserID(r.Context(), author_uuid)
+			c, err := cfg.chirps.GetChirpsByUserID(r.Context(), author_uuid)
 			if err != nil {
 				sendErrorJSONResponse(w, fmt.Sprintf("Failed to get chirps for author %v", author_uuid), http.StatusInternalServerError, err)
 				return
@@ -126,7 +122,7 @@ func (cfg *apiConfig) getChirps() http.HandlerFunc {
 
 			chirps = c
 		} else { // All chirps
-			c, err := cfg.db.GetChirps(r.Context())
+			c, err := cfg.chirps.GetChirps(r.Context())
 			if err != nil {
 				sendErrorJSONResponse(w, "Failed to get all chirps", http.StatusInternalServerError, err)
 				return
@@ -168,7 +164,7 @@ func (cfg *apiConfig) getChirpByID() http.HandlerFunc {
 			return
 		}
 
-		foundChirp, err := cfg.db.GetChirpByID(r.Context(), id)
+		foundChirp, err := cfg.chirps.GetChirpByID(r.Context(), id)
 		if err != nil {
 			sendErrorJSONResponse(w, "Chirp not found", http.StatusNotFound, err)
 			return
@@ -193,7 +189,7 @@ func (cfg *apiConfig) deleteChirpHandler() http.HandlerFunc {
 			return
 		}
 
-		chirp, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+		chirp, err := cfg.chirps.GetChirpByID(r.Context(), chirpID)
 		if err == sql.ErrNoRows {
 			sendErrorJSONResponse(w, "Chirp not found", http.StatusNotFound, err)
 			return
@@ -204,32 +200,126 @@ func (cfg *apiConfig) deleteChirpHandler() http.HandlerFunc {
 		}
 
 		// Get userID from auth token
-		token, err := auth.GetBearerToken(r.Header)
+		userIDFromToken, err := cfg.authenticateBearer(r, SCOPE_CHIRPS_WRITE)
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		// Verify the chirp was made by the user
+		if userIDFromToken != chirp.UserID {
+			sendResponse(w, http.StatusForbidden, fmt.Sprintf("user %v tried deleting unowned chirp %v", userIDFromToken, chirp))
+			return
+		}
+
+		// Delete Chirp
+		deletedChirp, err := cfg.chirps.DeleteChirpByID(r.Context(), chirpID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		cfg.publishWebhookEvent(r.Context(), WEBHOOK_EVENT_CHIRP_DELETED, Chirp{
+			ID:        deletedChirp.ID,
+			CreatedAt: deletedChirp.CreatedAt,
+			UpdatedAt: deletedChirp.UpdatedAt,
+			UserID:    deletedChirp.UserID,
+			Body:      deletedChirp.Body,
+		})
+
+		// Response
+		sendResponse(w, http.StatusNoContent, fmt.Sprintf("user %v deleted chirp %v", userIDFromToken, deletedChirp.ID))
+	}
+}
+
+// Replaces the body of one of the user's chirps, if their plan allows editing
+func (cfg *apiConfig) editChirpHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Body string `json:"body"`
+		}{}
+
+		// Get the chirpID, check if it exists
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 		if err != nil {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			sendErrorJSONResponse(w, "Chirp not found", http.StatusNotFound, err)
+			return
+		}
+
+		chirp, err := cfg.chirps.GetChirpByID(r.Context(), chirpID)
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Chirp not found", http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
-		userIDFromToken, err := auth.ValidateToken(token, cfg.jwtSecret)
+		// Get userID from auth token
+		userIDFromToken, err := cfg.authenticateBearer(r, SCOPE_CHIRPS_WRITE)
 		if err != nil {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			sendBearerAuthErrorResponse(w, err)
 			return
 		}
 
 		// Verify the chirp was made by the user
 		if userIDFromToken != chirp.UserID {
-			sendResponse(w, http.StatusForbidden, fmt.Sprintf("user %v tried deleting unowned chirp %v", userIDFromToken, chirp))
+			sendResponse(w, http.StatusForbidden, fmt.Sprintf("user %v tried editing unowned chirp %v", userIDFromToken, chirp.ID))
 			return
 		}
 
-		// Delete Chirp
-		deletedChirp, err := cfg.db.DeleteChirpByID(r.Context(), chirpID)
+		// Check the user's plan
+		entitlements, err := cfg.entitlementsFor(r.Context(), userIDFromToken)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		if !entitlements.CanEditChirps {
+			sendErrorJSONResponse(w, "Editing chirps requires Chirpy Red", http.StatusForbidden, nil)
+			return
+		}
+
+		if wait := cfg.chirpRateLimitWait(userIDFromToken, entitlements); wait > 0 {
+			sendTooManyRequestsResponse(w, "Too many chirps, try again later", wait, fmt.Errorf("chirp editing rate limited for user %v", userIDFromToken))
+			return
+		}
+
+		// Decode request, validate body
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		chirpText := req.Body
+		if len(chirpText) == 0 {
+			sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_REQUIRED, Message: "Chirp cannot be empty"})
+			return
+		}
+		if len(chirpText) > entitlements.MaxChirpLength {
+			sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_TOO_LONG, Message: fmt.Sprintf("Chirp is too long, your plan allows %v characters", entitlements.MaxChirpLength)})
+			return
+		}
+		chirpText = censoredBannedWords(chirpText)
+
+		// Update chirp in database
+		updatedChirp, err := cfg.chirps.UpdateChirp(r.Context(), database.UpdateChirpParams{
+			ID:        chirpID,
+			Body:      chirpText,
+			UpdatedAt: time.Now(),
+		})
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
 		// Response
-		sendResponse(w, http.StatusNoContent, fmt.Sprintf("user %v deleted chirp %v", userIDFromToken, deletedChirp.ID))
+		SendJSONResponse(w, http.StatusOK, Chirp{
+			ID:        updatedChirp.ID,
+			CreatedAt: updatedChirp.CreatedAt,
+			UpdatedAt: updatedChirp.UpdatedAt,
+			UserID:    updatedChirp.UserID,
+			Body:      updatedChirp.Body,
+		})
 	}
 }
@@ -13,8 +13,7 @@ import (
 )
 
 func TestPostChirp(t *testing.T) {
-	setup()
-	defer tearDown()
+	t.Parallel()
 
 	cases := []struct {
 		email         string
@@ -40,7 +39,7 @@ func TestPostChirp(t *testing.T) {
 		},
 	}
 
-	cfg := initApiConfig()
+	cfg := newMemoryApiConfig()
 	for _, c := range cases {
 		// Create new user
 		_, _, err := createTestUser(cfg, c.email, c.password)
@@ -102,10 +101,9 @@ func TestPostChirp(t *testing.T) {
 }
 
 func TestGetChirps(t *testing.T) {
-	setup()
-	defer tearDown()
+	t.Parallel()
 
-	cfg := initApiConfig()
+	cfg := newMemoryApiConfig()
 
 	// Create multiple users
 	users, passwords, err := createTestUsers(cfg, 3)
@@ -162,8 +160,7 @@ func TestGetChirps(t *testing.T) {
 }
 
 func TestDeleteChirp(t *testing.T) {
-	setup()
-	defer tearDown()
+	t.Parallel()
 
 	cases := []struct {
 		name                    string
@@ -227,7 +224,7 @@ func TestDeleteChirp(t *testing.T) {
 		},
 	}
 
-	cfg := initApiConfig()
+	cfg := newMemoryApiConfig()
 
 	for _, c := range cases {
 		err := deleteAllUsersAndPosts(cfg)
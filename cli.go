@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+)
+
+const CLI_USAGE = `usage: chirpy [flags] [command]
+
+commands:
+  serve                         start the server (the default)
+  config print                  show the configuration, secrets redacted
+  migrate up                    apply every pending migration
+  migrate down                  roll back the newest migration
+  migrate status                list migrations and whether they're applied
+  user create <email>           create a user, the password is read from stdin
+  user promote <email> <role>   set a user's role: user, moderator or admin
+  user delete <email>           delete a user and everything they own
+  token revoke-all              revoke every refresh token, app token and personal access token
+  bootstrap-admin <email>       promote the first admin
+
+flags (--help lists them all) override the environment, ex: chirpy --db-url postgres://... migrate up
+`
+
+// Returned for an unknown command or the wrong arguments, the caller prints CLI_USAGE
+var errUsage = errors.New("usage")
+
+// Runs a one-off command instead of starting the server
+// Passwords are read from stdin, results are written to stdout
+func runCommand(ctx context.Context, cfg *apiConfig, args []string, stdin io.Reader, stdout io.Writer) error {
+	switch {
+	case len(args) == 2 && args[0] == "bootstrap-admin":
+		err := cfg.bootstrapAdmin(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Promoted %v to %v\n", args[1], RoleAdmin)
+		return nil
+
+	case len(args) == 2 && args[0] == "migrate" && slices.Contains([]string{"up", "down", "status"}, args[1]):
+		return cfg.migrateCommand(ctx, args[1], stdout)
+
+	case len(args) == 3 && args[0] == "user" && args[1] == "create":
+		return cfg.createUserCommand(ctx, args[2], stdin, stdout)
+
+	case len(args) == 4 && args[0] == "user" && args[1] == "promote":
+		return cfg.promoteUserCommand(ctx, args[2], Role(args[3]), stdout)
+
+	case len(args) == 3 && args[0] == "user" && args[1] == "delete":
+		return cfg.deleteUserCommand(ctx, args[2], stdout)
+
+	case len(args) == 2 && args[0] == "token" && args[1] == "revoke-all":
+		return cfg.revokeAllTokensCommand(ctx, stdout)
+	}
+
+	return errUsage
+}
+
+// `migrate up|down|status`, runs the migrations embedded from sql/schema
+func (cfg *apiConfig) migrateCommand(ctx context.Context, direction string, stdout io.Writer) error {
+	provider, err := cfg.migrationProvider()
+	if err != nil {
+		return err
+	}
+
+	switch direction {
+	case "up":
+		// On failure, the migrations before the failing one are still applied and reported
+		results, err := provider.Up(ctx)
+		for _, result := range results {
+			fmt.Fprintln(stdout, result)
+		}
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(stdout, "No pending migrations")
+		}
+		return err
+
+	case "down":
+		result, err := provider.Down(ctx)
+		if result != nil {
+			fmt.Fprintln(stdout, result)
+		}
+		return err
+
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "-"
+			if !status.AppliedAt.IsZero() {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(stdout, "%-8v %-25v %v\n", status.State, appliedAt, status.Source.Path)
+		}
+		return nil
+	}
+
+	return errUsage
+}
+
+// `user create <email>`, the password is the first line of stdin so it doesn't end up in shell history
+// Ex: `printf '%s\n' "$PASSWORD" | chirpy user create admin@example.com`
+func (cfg *apiConfig) createUserCommand(ctx context.Context, email string, stdin io.Reader, stdout io.Writer) error {
+	scanner := bufio.NewScanner(stdin)
+	scanner.Scan()
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+
+	password := strings.TrimRight(scanner.Text(), "\r")
+	if password == "" {
+		return fmt.Errorf("password required on stdin")
+	}
+
+	user, err := cfg.createUser(ctx, email, password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Created user %v (%v)\n", user.Email, user.ID)
+	return nil
+}
+
+// `user promote <email> <role>`, unlike bootstrap-admin this works whether or not there's already an admin
+func (cfg *apiConfig) promoteUserCommand(ctx context.Context, email string, role Role, stdout io.Writer) error {
+	if !role.IsValid() {
+		return fmt.Errorf("invalid role %v, must be one of: %v, %v, %v", role, RoleUser, RoleModerator, RoleAdmin)
+	}
+
+	user, err := cfg.users.GetUserByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user with email %v", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.users.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:        user.ID,
+		Role:      string(role),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Set %v's role to %v\n", email, role)
+	return nil
+}
+
+// `user delete <email>`, their chirps, tokens, etc. are deleted with them (ON DELETE CASCADE)
+func (cfg *apiConfig) deleteUserCommand(ctx context.Context, email string, stdout io.Writer) error {
+	numDeleted, err := cfg.users.DeleteUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return fmt.Errorf("no user with email %v", email)
+	}
+
+	fmt.Fprintf(stdout, "Deleted user %v\n", email)
+	return nil
+}
+
+// `token revoke-all`, logs everyone out, ex: after a leak
+// Access JWTs can't be revoked and stay valid until they expire, unless JWT_SECRET is also rotated
+func (cfg *apiConfig) revokeAllTokensCommand(ctx context.Context, stdout io.Writer) error {
+	numRefreshTokens, err := cfg.refreshTokens.RevokeAllRefreshTokens(ctx, sql.NullTime{Time: time.Now(), Valid: true})
+	if err != nil {
+		return err
+	}
+
+	numOAuthTokens, err := cfg.db.DeleteAllOAuthTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	numPersonalAccessTokens, err := cfg.db.DeleteAllPersonalAccessTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Revoked %v refresh tokens, %v app tokens and %v personal access tokens\n", numRefreshTokens, numOAuthTokens, numPersonalAccessTokens)
+	return nil
+}
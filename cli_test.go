@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandUsage(t *testing.T) {
+	cases := [][]string{
+		{"launch"},
+		{"migrate"},
+		{"migrate", "sideways"},
+		{"user", "create"},
+		{"user", "promote", "admin@example.com"},
+		{"token", "revoke"},
+		{"serve", "now"},
+	}
+
+	for _, args := range cases {
+		// Usage errors are caught before the config is used
+		err := runCommand(context.Background(), &apiConfig{}, args, strings.NewReader(""), &bytes.Buffer{})
+		assertEquals(errors.Is(err, errUsage), true, strings.Join(args, " "), t)
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	// Listing migrations doesn't connect
+	db, err := sql.Open("postgres", "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	provider, err := (&apiConfig{sqlDB: db}).migrationProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := provider.ListSources()
+	latest, err := latestMigrationVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEquals(len(sources) > 0, true, "Embedded migrations", t)
+	assertEquals(sources[len(sources)-1].Version, latest, "Newest embedded migration", t)
+}
+
+func TestUserCommands(t *testing.T) {
+	t.Parallel()
+	cfg := newTestApiConfig(t)
+	ctx := context.Background()
+	email := "cli_user@gmail.com"
+
+	// Create
+	err := runCommand(ctx, cfg, []string{"user", "create", email}, strings.NewReader("abc001-password\n"), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEquals(user.Role, string(RoleUser), "Created user's role", t)
+
+	// Password policy applies, like POST /api/users
+	err = runCommand(ctx, cfg, []string{"user", "create", "cli_user_2@gmail.com"}, strings.NewReader("short\n"), &bytes.Buffer{})
+	assertEquals(err != nil, true, "Password too short", t)
+
+	// Promote
+	err = runCommand(ctx, cfg, []string{"user", "promote", email, string(RoleModerator)}, nil, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, err := cfg.users.GetUserRole(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEquals(role, string(RoleModerator), "Promoted user's role", t)
+
+	err = runCommand(ctx, cfg, []string{"user", "promote", email, "superuser"}, nil, &bytes.Buffer{})
+	assertEquals(err != nil, true, "Unknown role", t)
+
+	// Delete
+	err = runCommand(ctx, cfg, []string{"user", "delete", email}, nil, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.users.GetUserByEmail(ctx, email)
+	assertEquals(err, sql.ErrNoRows, "Deleted user", t)
+
+	err = runCommand(ctx, cfg, []string{"user", "delete", email}, nil, &bytes.Buffer{})
+	assertEquals(err != nil, true, "Deleting a missing user", t)
+}
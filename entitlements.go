@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// Users without Chirpy Red
+const PLAN_FREE = "free"
+
+// What a plan allows, handlers check these rather than the plan itself
+type Entitlements struct {
+	MaxChirpLength int
+	CanEditChirps  bool
+	ChirpRateLimit ratelimit.Limit // Posts and edits, per user
+}
+
+// Every plan's limits, the only place they're defined
+var planEntitlements = map[string]Entitlements{
+	PLAN_FREE: {
+		MaxChirpLength: 140,
+		CanEditChirps:  false,
+		ChirpRateLimit: ratelimit.Limit{PerMinute: 10, Burst: 10},
+	},
+	PLAN_CHIRPY_RED: {
+		MaxChirpLength: 500,
+		CanEditChirps:  true,
+		ChirpRateLimit: ratelimit.Limit{PerMinute: 60, Burst: 30},
+	},
+}
+
+// Returns what the user's plan allows, sql.ErrNoRows if the user doesn't exist
+func (cfg *apiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) (Entitlements, error) {
+	user, err := cfg.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return Entitlements{}, err
+	}
+
+	if user.IsChirpyRed {
+		return planEntitlements[PLAN_CHIRPY_RED], nil
+	}
+	return planEntitlements[PLAN_FREE], nil
+}
+
+// Returns 0 if the user can post or edit a chirp now, otherwise how long until they can
+func (cfg *apiConfig) chirpRateLimitWait(userID uuid.UUID, entitlements Entitlements) time.Duration {
+	_, wait := cfg.chirpRateLimiter.Allow("chirps:"+userID.String(), entitlements.ChirpRateLimit, time.Now())
+	return wait
+}
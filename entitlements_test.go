@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChirpyRedEntitlements(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+
+	users, passwords, err := createTestUsers(cfg, 2)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	otherUser, err := loginUser(cfg, users[1].Email, passwords[1])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	free := planEntitlements[PLAN_FREE]
+	red := planEntitlements[PLAN_CHIRPY_RED]
+
+	// Free plan
+	chirp, err := postChirp(cfg, loggedInUser.Token, "Hello")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	w := sendChirpRequest(cfg.postChirpHandler(), "POST", "", loggedInUser.Token, strings.Repeat("a", free.MaxChirpLength+1))
+	assertEquals(w.Result().StatusCode, http.StatusUnprocessableEntity, "free: long chirp", t)
+
+	w = sendChirpRequest(cfg.editChirpHandler(), "PUT", chirp.ID.String(), loggedInUser.Token, "Edited")
+	assertEquals(w.Result().StatusCode, http.StatusForbidden, "free: edit", t)
+
+	// Chirpy Red
+	w = sendPolkaEvent(cfg, "user.upgraded", users[0].ID)
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "upgrade", t)
+
+	longChirp := strings.Repeat("a", red.MaxChirpLength)
+	w = sendChirpRequest(cfg.postChirpHandler(), "POST", "", loggedInUser.Token, longChirp)
+	assertEquals(w.Result().StatusCode, http.StatusCreated, "red: long chirp", t)
+
+	w = sendChirpRequest(cfg.postChirpHandler(), "POST", "", loggedInUser.Token, longChirp+"a")
+	assertEquals(w.Result().StatusCode, http.StatusUnprocessableEntity, "red: too long chirp", t)
+
+	w = sendChirpRequest(cfg.editChirpHandler(), "PUT", chirp.ID.String(), loggedInUser.Token, "Edited")
+	assertEquals(w.Result().StatusCode, http.StatusOK, "red: edit", t)
+
+	editedChirp := Chirp{}
+	json.NewDecoder(w.Result().Body).Decode(&editedChirp)
+	assertEquals(editedChirp.Body, "Edited", "red: edited body", t)
+
+	w = sendChirpRequest(cfg.editChirpHandler(), "PUT", chirp.ID.String(), otherUser.Token, "Not mine")
+	assertEquals(w.Result().StatusCode, http.StatusForbidden, "edit another user's chirp", t)
+
+	// Rate limits, the other user is still on the free plan
+	for i := range free.ChirpRateLimit.Burst {
+		_, err = postChirp(cfg, otherUser.Token, fmt.Sprintf("Chirp %v", i))
+		if err != nil {
+			t.Error(formatTestError(fmt.Sprintf("free: chirp %v within limit", i), err, nil))
+		}
+	}
+
+	w = sendChirpRequest(cfg.postChirpHandler(), "POST", "", otherUser.Token, "One too many")
+	assertEquals(w.Result().StatusCode, http.StatusTooManyRequests, "free: over rate limit", t)
+	if w.Result().Header.Get("Retry-After") == "" {
+		t.Error(formatTestError("free: over rate limit", w.Result().Header, "Retry-After header"))
+	}
+
+	// Red users have a higher limit
+	_, err = postChirp(cfg, loggedInUser.Token, "Still posting")
+	if err != nil {
+		t.Error(formatTestError("red: within higher limit", err, nil))
+	}
+}
+
+// Chirpy Red should never allow less than the free plan
+func TestPlanEntitlementsOrdered(t *testing.T) {
+	free := planEntitlements[PLAN_FREE]
+	red := planEntitlements[PLAN_CHIRPY_RED]
+
+	assertEquals(red.MaxChirpLength > free.MaxChirpLength, true, "chirp length", t)
+	assertEquals(red.CanEditChirps, true, "editing", t)
+	assertEquals(red.ChirpRateLimit.PerMinute >= free.ChirpRateLimit.PerMinute, true, "rate", t)
+	assertEquals(red.ChirpRateLimit.Burst >= free.ChirpRateLimit.Burst, true, "burst", t)
+}
+
+// Posts a chirp, or edits the chirp if `chirpID` is set
+func sendChirpRequest(handler http.HandlerFunc, method, chirpID, bearerToken, body string) *httptest.ResponseRecorder {
+	requestBody, _ := json.Marshal(map[string]string{"body": body})
+	request := httptest.NewRequest(method, "/api/chirps/"+chirpID, strings.NewReader(string(requestBody)))
+	request.SetPathValue("chirpID", chirpID)
+	request.Header.Add("Authorization", "Bearer "+bearerToken)
+	w := httptest.NewRecorder()
+	handler(w, request)
+	return w
+}
@@ -1,9 +1,109 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Each readiness check must finish within this
+const READINESS_CHECK_TIMEOUT = 2 * time.Second
+
+const (
+	CHECK_OK   = "ok"
+	CHECK_FAIL = "fail"
+)
+
+var errShuttingDown = errors.New("shutting down")
+
+type HealthCheck struct {
+	Status     string  `json:"status"`
+	Detail     string  `json:"detail,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// Liveness: the process is up and serving requests
+// Dependencies aren't checked, restarting Chirpy wouldn't fix them
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	SendJSONResponse(w, http.StatusOK, HealthResponse{Status: CHECK_OK})
+}
+
+// Readiness: Chirpy can handle traffic, 503 with the failing checks otherwise
+// Fails as soon as shutdown starts (the context is canceled), so load balancers stop sending requests
+func (cfg *apiConfig) readyzHandler(shutdownCtx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]HealthCheck{
+			"shutdown": runHealthCheck(r.Context(), "shutdown", errShuttingDown.Error(), func(ctx context.Context) error {
+				if shutdownCtx.Err() != nil {
+					return errShuttingDown
+				}
+				return nil
+			}),
+			"database":   runHealthCheck(r.Context(), "database", "unreachable", cfg.sqlDB.PingContext),
+			"migrations": runHealthCheck(r.Context(), "migrations", "not at the expected version", cfg.checkMigrations),
+		}
+
+		response := HealthResponse{Status: CHECK_OK, Checks: checks}
+		statusCode := http.StatusOK
+		for _, check := range checks {
+			if check.Status != CHECK_OK {
+				response.Status = CHECK_FAIL
+				statusCode = http.StatusServiceUnavailable
+			}
+		}
+
+		SendJSONResponse(w, statusCode, response)
+	}
+}
+
+// Fails if the database is behind (or ahead of) the migrations built into this binary
+func (cfg *apiConfig) checkMigrations(ctx context.Context) error {
+	expected, err := latestMigrationVersion()
+	if err != nil {
+		return err
+	}
+
+	applied, err := cfg.appliedMigrationVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if applied != expected {
+		return fmt.Errorf("database is at version %v, expected %v", applied, expected)
+	}
+	return nil
+}
+
+// Failures are reported with a fixed detail, readyz is unauthenticated and errors can include hosts, ports and database users
+// The error itself is logged
+func runHealthCheck(ctx context.Context, name, failureDetail string, check func(context.Context) error) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, READINESS_CHECK_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := HealthCheck{
+		Status:     CHECK_OK,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = CHECK_FAIL
+		result.Detail = failureDetail
+		requestLogger(ctx).Warn("Readiness check failed", "check", name, "error", err)
+	}
+
+	return result
+}
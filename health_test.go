@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +37,75 @@ func TestHealthHandler(t *testing.T) {
 	}
 
 }
+
+func TestLivezHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	livezHandler(w, httptest.NewRequest("GET", "/api/livez", nil))
+
+	response := HealthResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&response)
+
+	assertEquals(w.Result().StatusCode, http.StatusOK, "livez", t)
+	assertEquals(response.Status, CHECK_OK, "livez", t)
+}
+
+func TestReadyzHandler(t *testing.T) {
+	// Nothing listens on port 1, so the database checks fail quickly
+	db, err := sql.Open("postgres", "postgres://chirpy@127.0.0.1:1/chirpy?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{sqlDB: db}
+
+	shutdownCtx, startShutdown := context.WithCancel(context.Background())
+	defer startShutdown()
+
+	cases := []struct {
+		name             string
+		shuttingDown     bool
+		expectedShutdown string
+	}{
+		{name: "Database down", expectedShutdown: CHECK_OK},
+		{name: "Database down, shutting down", shuttingDown: true, expectedShutdown: CHECK_FAIL},
+	}
+
+	for _, c := range cases {
+		if c.shuttingDown {
+			startShutdown()
+		}
+
+		w := httptest.NewRecorder()
+		cfg.readyzHandler(shutdownCtx)(w, httptest.NewRequest("GET", "/api/readyz", nil))
+
+		response := HealthResponse{}
+		json.NewDecoder(w.Result().Body).Decode(&response)
+
+		assertEquals(w.Result().StatusCode, http.StatusServiceUnavailable, c.name, t)
+		assertEquals(response.Status, CHECK_FAIL, c.name, t)
+		assertEquals(response.Checks["database"].Status, CHECK_FAIL, c.name+", database", t)
+		assertEquals(response.Checks["migrations"].Status, CHECK_FAIL, c.name+", migrations", t)
+		assertEquals(response.Checks["shutdown"].Status, c.expectedShutdown, c.name+", shutdown", t)
+
+		// Connection errors name the host, port and user, so they're only logged
+		assertEquals(response.Checks["database"].Detail, "unreachable", c.name+", database detail", t)
+		if strings.Contains(w.Body.String(), "127.0.0.1") || strings.Contains(w.Body.String(), "chirpy") {
+			t.Error(formatTestError(c.name, w.Body.String(), "connection details hidden"))
+		}
+	}
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	entries, err := os.ReadDir(MIGRATIONS_DIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newest, _, _ := strings.Cut(entries[len(entries)-1].Name(), "_")
+	expected, _ := strconv.ParseInt(newest, 10, 64)
+
+	actual, err := latestMigrationVersion()
+	if err != nil {
+		t.Error(err)
+	}
+	assertEquals(actual, expected, "Newest migration in "+MIGRATIONS_DIR, t)
+}
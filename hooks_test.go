@@ -2,23 +2,39 @@ package main
 
 import (
 	"log"
-	"os"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/ratelimit"
+	"github.com/LamontBanks/Chirpy/internal/repository"
+	"github.com/LamontBanks/Chirpy/internal/throttle"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func setup() {
-	cfg := initApiConfig()
-	err := deleteAllUsersAndPosts(cfg)
+// Users, chirps and refresh tokens in memory, for handler tests that don't need Postgres
+// Each config has its own storage, so these tests can run with t.Parallel()
+func newMemoryApiConfig() *apiConfig {
+	memory := repository.NewMemory()
+
+	passwordHasher, err := auth.NewPasswordHasher(auth.ALGORITHM_ARGON2ID, auth.DefaultArgon2idParams, bcrypt.DefaultCost)
 	if err != nil {
 		log.Fatal(err)
-		os.Exit(1)
 	}
-}
 
-func tearDown() {
-	cfg := initApiConfig()
-	err := deleteAllUsersAndPosts(cfg)
-	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+	return &apiConfig{
+		users:         memory,
+		chirps:        memory,
+		refreshTokens: memory,
+		webhookOutbox: memory,
+
+		platform:          "dev",
+		jwtSecret:         "test-jwt-secret",
+		totpEncryptionKey: []byte("test-totp-encryption-key-32bytes"),
+		passwordPolicy:    auth.DefaultPasswordPolicy(),
+		passwordHasher:    passwordHasher,
+
+		accountThrottle:  throttle.New(accountLoginPolicy),
+		ipThrottle:       throttle.New(ipLoginPolicy),
+		chirpRateLimiter: ratelimit.New(),
+		ipRateLimiter:    ratelimit.New(),
 	}
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const WEBHOOK_SOURCE_POLKA = "polka"
+
+// Inbound webhook statuses, see sql/schema/014_inbound_webhooks.sql
+const (
+	WEBHOOK_RECEIVED   = "received"   // Logged, not processed yet
+	WEBHOOK_REJECTED   = "rejected"   // Bad body, never processed (bad signatures aren't logged)
+	WEBHOOK_IGNORED    = "ignored"    // Event Chirpy doesn't handle
+	WEBHOOK_DUPLICATE  = "duplicate"  // Another delivery of the event was processed
+	WEBHOOK_PROCESSING = "processing" // Claimed by a delivery until WEBHOOK_PROCESSING_LEASE expires, see ClaimInboundWebhook
+	WEBHOOK_PROCESSED  = "processed"
+	WEBHOOK_FAILED     = "failed" // Can be replayed by an admin
+)
+
+var inboundWebhookStatuses = []string{WEBHOOK_RECEIVED, WEBHOOK_REJECTED, WEBHOOK_IGNORED, WEBHOOK_DUPLICATE, WEBHOOK_PROCESSING, WEBHOOK_PROCESSED, WEBHOOK_FAILED}
+
+// Most deliveries listed at once
+const MAX_LISTED_INBOUND_WEBHOOKS = 100
+
+// How long a delivery can be processing before it's failed, so the event can be retried or replayed, see ClaimInboundWebhook
+const WEBHOOK_PROCESSING_LEASE = 5 * time.Minute
+
+// Processing is canceled this long before the lease expires, so it's never still running when the event is claimed again
+const WEBHOOK_PROCESSING_TIMEOUT = WEBHOOK_PROCESSING_LEASE - time.Minute
+
+var errWebhookLeaseExpired = errors.New("processing lease expired")
+
+// How long deliveries are kept, and how often older ones are deleted
+const (
+	INBOUND_WEBHOOK_RETENTION        = 30 * 24 * time.Hour
+	INBOUND_WEBHOOK_CLEANUP_INTERVAL = time.Hour
+)
+
+// Not saved with logged deliveries
+var sensitiveWebhookHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+type InboundWebhook struct {
+	ID         uuid.UUID       `json:"id"`
+	ReceivedAt time.Time       `json:"received_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Source     string          `json:"source"`
+	Headers    json.RawMessage `json:"headers"`
+	Body       string          `json:"body"`
+	EventID    string          `json:"event_id"`
+	Event      string          `json:"event"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error"`
+	Attempts   int32           `json:"attempts"`
+}
+
+// Saves the delivery as received, before anything else is done with it
+func (cfg *apiConfig) logInboundWebhook(ctx context.Context, source string, header http.Header, body []byte) (database.InboundWebhook, error) {
+	header = header.Clone()
+	for _, name := range sensitiveWebhookHeaders {
+		header.Del(name)
+	}
+
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return database.InboundWebhook{}, err
+	}
+
+	now := time.Now()
+	return cfg.db.CreateInboundWebhook(ctx, database.CreateInboundWebhookParams{
+		ID:         uuid.New(),
+		ReceivedAt: now,
+		UpdatedAt:  now,
+		Source:     source,
+		Headers:    headers,
+		Body:       body,
+	})
+}
+
+// Records the outcome of the delivery, passes through the status code for the sender and returns a message to log
+func (cfg *apiConfig) finishInboundWebhook(ctx context.Context, deliveryID uuid.UUID, status string, statusCode int, reason error) (int, string) {
+	inboundWebhooksTotal.WithLabelValues(status).Inc()
+
+	msg := ""
+	errorMsg := sql.NullString{}
+	if reason != nil {
+		msg = fmt.Sprintf("Webhook %v %v: %v", deliveryID, status, reason)
+		errorMsg = sql.NullString{String: reason.Error(), Valid: true}
+	}
+
+	err := cfg.db.SetInboundWebhookStatus(ctx, database.SetInboundWebhookStatusParams{
+		ID:        deliveryID,
+		Status:    status,
+		Error:     errorMsg,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		msg = fmt.Sprintf("%v (error setting webhook %v status to %v: %v)", msg, deliveryID, status, err)
+	}
+
+	return statusCode, msg
+}
+
+// Lists logged deliveries with the `status` query parameter, "failed" by default, newest first
+func (cfg *apiConfig) getInboundWebhooksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = WEBHOOK_FAILED
+		}
+
+		if !slices.Contains(inboundWebhookStatuses, status) {
+			sendErrorJSONResponse(w, fmt.Sprintf("Unknown status %q", status), http.StatusBadRequest, nil)
+			return
+		}
+
+		savedDeliveries, err := cfg.db.GetInboundWebhooksByStatus(r.Context(), database.GetInboundWebhooksByStatusParams{
+			Status: status,
+			Limit:  MAX_LISTED_INBOUND_WEBHOOKS,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		deliveries := []InboundWebhook{}
+		for _, savedDelivery := range savedDeliveries {
+			deliveries = append(deliveries, toInboundWebhook(savedDelivery))
+		}
+
+		SendJSONResponse(w, http.StatusOK, deliveries)
+	}
+}
+
+// Processes a failed delivery again, as if it had just been received
+// The signature isn't checked again, it was valid when the delivery was received
+func (cfg *apiConfig) replayInboundWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveryID, err := uuid.Parse(r.PathValue("webhookID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "Webhook not found", http.StatusNotFound, err)
+			return
+		}
+
+		// Deliveries stuck processing can be replayed once their lease expires
+		err = cfg.db.ExpireInboundWebhookLeases(r.Context(), time.Now())
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		delivery, err := cfg.db.GetInboundWebhook(r.Context(), deliveryID)
+		if errors.Is(err, sql.ErrNoRows) {
+			sendErrorJSONResponse(w, "Webhook not found", http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		if delivery.Status != WEBHOOK_FAILED {
+			sendErrorJSONResponse(w, fmt.Sprintf("Only failed webhooks can be replayed, this one is %v", delivery.Status), http.StatusConflict, nil)
+			return
+		}
+
+		switch delivery.Source {
+		case WEBHOOK_SOURCE_POLKA:
+			_, msg := cfg.processPolkaWebhook(r.Context(), delivery.ID, delivery.Body)
+			if msg != "" {
+				requestLogger(r.Context()).Info("Replayed webhook", "webhook_id", delivery.ID, "result", msg)
+			}
+		default:
+			sendErrorJSONResponse(w, fmt.Sprintf("Can't replay %v webhooks", delivery.Source), http.StatusBadRequest, nil)
+			return
+		}
+
+		// Response, with the outcome of the replay
+		delivery, err = cfg.db.GetInboundWebhook(r.Context(), deliveryID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		SendJSONResponse(w, http.StatusOK, toInboundWebhook(delivery))
+	}
+}
+
+// Deletes deliveries older than INBOUND_WEBHOOK_RETENTION, so the log doesn't grow forever
+func (cfg *apiConfig) deleteOldInboundWebhooks(ctx context.Context, now time.Time) error {
+	deleted, err := cfg.db.DeleteInboundWebhooksBefore(ctx, now.Add(-INBOUND_WEBHOOK_RETENTION))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		slog.Info("Deleted old inbound webhooks", "deliveries", deleted)
+	}
+
+	return nil
+}
+
+// Runs deleteOldInboundWebhooks every interval until the context is canceled
+// A run in progress isn't canceled with it, it finishes first
+func (cfg *apiConfig) runInboundWebhookCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.deleteOldInboundWebhooks(context.WithoutCancel(ctx), time.Now())
+		if err != nil {
+			slog.Error("Error deleting old inbound webhooks", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func toInboundWebhook(savedDelivery database.InboundWebhook) InboundWebhook {
+	return InboundWebhook{
+		ID:         savedDelivery.ID,
+		ReceivedAt: savedDelivery.ReceivedAt,
+		UpdatedAt:  savedDelivery.UpdatedAt,
+		Source:     savedDelivery.Source,
+		Headers:    savedDelivery.Headers,
+		Body:       string(savedDelivery.Body),
+		EventID:    savedDelivery.EventID.String,
+		Event:      savedDelivery.Event.String,
+		Status:     savedDelivery.Status,
+		Error:      savedDelivery.Error.String,
+		Attempts:   savedDelivery.Attempts,
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+func TestInboundWebhookLog(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+
+	users, _, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	userID := users[0].ID
+
+	// Deliveries with a bad signature aren't logged
+	rejectedEventID := uuid.NewString()
+	w := sendPolkaWebhook(cfg, fmt.Sprintf(`{"id": %q, "event": "user.upgraded", "data": {"user_id": %q}}`, rejectedEventID, userID), "t=1,v1=abcd")
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "bad signature", t)
+	assertEquals(countInboundWebhooks(cfg, WEBHOOK_REJECTED, func(d InboundWebhook) bool { return strings.Contains(d.Body, rejectedEventID) }, t), 0, "bad signature logged", t)
+
+	// Renewal before the user subscribed fails, and is listed without credentials
+	failedEventID := uuid.NewString()
+	body := fmt.Sprintf(`{"id": %q, "event": "subscription.renewed", "data": {"user_id": %q}}`, failedEventID, userID)
+	signature := webhook.Sign([]byte(body), time.Now(), "secret")
+	request := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+	request.Header.Set("Authorization", "ApiKey leaked-key")
+	request.Header.Set(POLKA_SIGNATURE_HEADER, signature)
+	w = httptest.NewRecorder()
+	cfg.polkaWebhookHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusNotFound, "renewal without subscription", t)
+
+	failed := findInboundWebhook(cfg, WEBHOOK_FAILED, func(d InboundWebhook) bool { return d.EventID == failedEventID }, t)
+	assertEquals(failed.Body, body, "raw body logged", t)
+	assertEquals(strings.Contains(string(failed.Headers), "leaked-key"), false, "credentials not logged", t)
+	assertEquals(strings.Contains(string(failed.Headers), signature), true, "signature logged", t)
+	if failed.Error == "" {
+		t.Error(formatTestError("failed webhook error", failed.Error, "subscription not found"))
+	}
+
+	// Replaying works once the problem is fixed
+	w = sendPolkaEvent(cfg, "user.upgraded", userID)
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "upgrade", t)
+
+	w = sendReplayRequest(cfg, failed.ID.String())
+	assertEquals(w.Result().StatusCode, http.StatusOK, "replay", t)
+
+	replayed := InboundWebhook{}
+	json.NewDecoder(w.Result().Body).Decode(&replayed)
+	assertEquals(replayed.Status, WEBHOOK_PROCESSED, "replayed status", t)
+	assertEquals(replayed.Attempts, int32(2), "replayed attempts", t)
+
+	// Processed events aren't replayed again, or processed again when Polka retries
+	w = sendReplayRequest(cfg, failed.ID.String())
+	assertEquals(w.Result().StatusCode, http.StatusConflict, "replay processed", t)
+
+	w = sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), "secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "retry of replayed event", t)
+	findInboundWebhook(cfg, WEBHOOK_DUPLICATE, func(d InboundWebhook) bool { return d.EventID == failedEventID }, t)
+
+	w = sendReplayRequest(cfg, uuid.NewString())
+	assertEquals(w.Result().StatusCode, http.StatusNotFound, "replay unknown", t)
+}
+
+// Deliveries that stopped while processing (ex: the server crashed) don't hold on to their event once the lease expires
+func TestInboundWebhookProcessingLease(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+	user, _ := newTestUser(t, cfg)
+
+	// Logged and claimed, then never finished
+	stuckDelivery := func(eventID string, processingUntil time.Time) (uuid.UUID, string) {
+		body := fmt.Sprintf(`{"id": %q, "event": "user.upgraded", "data": {"user_id": %q}}`, eventID, user.ID)
+		delivery, err := cfg.logInboundWebhook(context.Background(), WEBHOOK_SOURCE_POLKA, http.Header{}, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cfg.db.ClaimInboundWebhook(context.Background(), database.ClaimInboundWebhookParams{
+			EventID:         sql.NullString{String: eventID, Valid: true},
+			Event:           sql.NullString{String: "user.upgraded", Valid: true},
+			ProcessingUntil: sql.NullTime{Time: processingUntil, Valid: true},
+			UpdatedAt:       time.Now(),
+			ID:              delivery.ID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return delivery.ID, body
+	}
+
+	// Still leased, so Polka's retry is a duplicate
+	leasedID, leasedBody := stuckDelivery(uuid.NewString(), time.Now().Add(time.Minute))
+	w := sendPolkaWebhook(cfg, leasedBody, webhook.Sign([]byte(leasedBody), time.Now(), "secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "retry while leased", t)
+	w = sendReplayRequest(cfg, leasedID.String())
+	assertEquals(w.Result().StatusCode, http.StatusConflict, "replay while leased", t)
+
+	// Expired, so Polka's retry processes the event
+	_, expiredBody := stuckDelivery(uuid.NewString(), time.Now().Add(-time.Minute))
+	w = sendPolkaWebhook(cfg, expiredBody, webhook.Sign([]byte(expiredBody), time.Now(), "secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "retry after lease expired", t)
+	assertEquals(isChirpyRed(cfg, user.ID, t), true, "retry after lease expired, Chirpy Red", t)
+
+	// Expired, so an admin can replay it
+	expiredID, _ := stuckDelivery(uuid.NewString(), time.Now().Add(-time.Minute))
+	w = sendReplayRequest(cfg, expiredID.String())
+	assertEquals(w.Result().StatusCode, http.StatusOK, "replay after lease expired", t)
+
+	replayed := InboundWebhook{}
+	json.NewDecoder(w.Result().Body).Decode(&replayed)
+	assertEquals(replayed.Status, WEBHOOK_PROCESSED, "replayed status", t)
+
+	// Lease expired while processing, so the event isn't applied in case it's been claimed again
+	downgradeEventID := uuid.NewString()
+	req := PolkaWebhookRequest{ID: downgradeEventID, Event: "user.downgraded"}
+	req.Data.UserID = user.ID
+	expiredID, _ = stuckDelivery(downgradeEventID, time.Now().Add(-time.Second))
+
+	_, err := cfg.handlePolkaEvent(context.Background(), expiredID, req, time.Now())
+	assertEquals(errors.Is(err, errWebhookLeaseExpired), true, "processing after lease expired", t)
+	assertEquals(isChirpyRed(cfg, user.ID, t), true, "processing after lease expired, Chirpy Red", t)
+}
+
+// Logged deliveries older than the retention period are deleted, unless they're still processing
+func TestDeleteOldInboundWebhooks(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	logDelivery := func(status string) uuid.UUID {
+		delivery, err := cfg.logInboundWebhook(context.Background(), WEBHOOK_SOURCE_POLKA, http.Header{}, []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cfg.db.SetInboundWebhookStatus(context.Background(), database.SetInboundWebhookStatusParams{
+			ID:        delivery.ID,
+			Status:    status,
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return delivery.ID
+	}
+
+	processedID := logDelivery(WEBHOOK_PROCESSED)
+	failedID := logDelivery(WEBHOOK_FAILED)
+	processingID := logDelivery(WEBHOOK_PROCESSING)
+
+	// Nothing is old enough yet
+	err := cfg.deleteOldInboundWebhooks(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.GetInboundWebhook(context.Background(), processedID)
+	assertEquals(err, nil, "recent delivery kept", t)
+
+	// Once the retention period has passed
+	err = cfg.deleteOldInboundWebhooks(context.Background(), time.Now().Add(INBOUND_WEBHOOK_RETENTION+time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id      uuid.UUID
+		deleted bool
+	}{
+		{id: processedID, deleted: true},
+		{id: failedID, deleted: true},
+		{id: processingID, deleted: false},
+	}
+
+	for _, c := range cases {
+		_, err := cfg.db.GetInboundWebhook(context.Background(), c.id)
+		assertEquals(errors.Is(err, sql.ErrNoRows), c.deleted, c.id, t)
+	}
+}
+
+// Returns the number of deliveries listed with the status that match
+func countInboundWebhooks(cfg *apiConfig, status string, matches func(InboundWebhook) bool, t *testing.T) int {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", "/admin/webhooks?status="+status, nil)
+	w := httptest.NewRecorder()
+	cfg.getInboundWebhooksHandler()(w, request)
+
+	deliveries := []InboundWebhook{}
+	json.NewDecoder(w.Result().Body).Decode(&deliveries)
+
+	count := 0
+	for _, delivery := range deliveries {
+		if matches(delivery) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the first delivery listed with the status that matches
+func findInboundWebhook(cfg *apiConfig, status string, matches func(InboundWebhook) bool, t *testing.T) InboundWebhook {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", "/admin/webhooks?status="+status, nil)
+	w := httptest.NewRecorder()
+	cfg.getInboundWebhooksHandler()(w, request)
+
+	deliveries := []InboundWebhook{}
+	json.NewDecoder(w.Result().Body).Decode(&deliveries)
+	for _, delivery := range deliveries {
+		if matches(delivery) {
+			return delivery
+		}
+	}
+
+	t.Error(formatTestError("find "+status+" webhook", deliveries, "matching delivery"))
+	t.FailNow()
+	return InboundWebhook{}
+}
+
+func sendReplayRequest(cfg *apiConfig, webhookID string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/admin/webhooks/"+webhookID+"/replay", nil)
+	request.SetPathValue("webhookID", webhookID)
+	w := httptest.NewRecorder()
+	cfg.replayInboundWebhookHandler()(w, request)
+	return w
+}
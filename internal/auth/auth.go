@@ -10,7 +10,6 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Custom JWT Token with Claims
@@ -24,39 +23,50 @@ type CustomClaims struct {
 }
 
 const (
-	JWT_TOKEN_DURATION     = "1h"
-	REFRESH_TOKEN_DURATION = "1440h"
+	JWT_TOKEN_DURATION           = "1h"
+	REFRESH_TOKEN_DURATION       = "1440h"
+	MFA_CHALLENGE_TOKEN_DURATION = "5m"
 )
 
-// Returns `bcrypt`-hashed password
-func HashPassword(password string) (string, error) {
-	passwordBytes := []byte(password)
-	hashedBytes, err := bcrypt.GenerateFromPassword(passwordBytes, bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
+// JWT issuers
+// Challenge tokens are signed with the same secret as access tokens, so the issuer
+// keeps one from being accepted in place of the other
+const (
+	JWT_ISSUER               = "chirpy"
+	MFA_CHALLENGE_JWT_ISSUER = "chirpy-mfa"
+)
 
-	return string(hashedBytes), nil
+// Returns the password hashed with the default hasher (argon2id), see PasswordHasher
+func HashPassword(password string) (string, error) {
+	return DefaultPasswordHasher().Hash(password)
 }
 
 // Returns nil if plaintext password matches the hashed password, error otherwise
+// Accepts any hash format PasswordHasher can verify, including legacy bcrypt hashes
 func CheckPasswordHash(password, hash string) error {
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
-		return err
-	}
-	return nil
+	return DefaultPasswordHasher().Check(password, hash)
 }
 
 // Returns a JSON Web Token (JWT) for the given user
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWTWithIssuer(userID, tokenSecret, expiresIn, JWT_ISSUER)
+}
+
+// Returns a short-lived JWT proving the user passed the password step of a two-factor login
+// Only accepted by ValidateMFAChallengeToken, never as an access token
+func MakeMFAChallengeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWTWithIssuer(userID, tokenSecret, expiresIn, MFA_CHALLENGE_JWT_ISSUER)
+}
+
+func makeJWTWithIssuer(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, issuer string) (string, error) {
 	if userID == uuid.Nil || tokenSecret == "" {
-		return "", fmt.Errorf("invalid userID: %v, tokenSecret: %v", userID, tokenSecret)
+		return "", fmt.Errorf("invalid userID: %v, or empty tokenSecret", userID)
 	}
 
 	// Create token
 	claims := CustomClaims{
 		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
+			Issuer:    issuer,
 			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 			Subject:   userID.String(),
@@ -72,9 +82,18 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 
 // Validates the token, extracts and returns the userID
 func ValidateToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateTokenWithIssuer(tokenString, tokenSecret, JWT_ISSUER)
+}
+
+// Validates the two-factor login challenge token, extracts and returns the userID
+func ValidateMFAChallengeToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return validateTokenWithIssuer(tokenString, tokenSecret, MFA_CHALLENGE_JWT_ISSUER)
+}
+
+func validateTokenWithIssuer(tokenString, tokenSecret, issuer string) (uuid.UUID, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, jwt.WithIssuer(issuer), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return uuid.Nil, err
 	}
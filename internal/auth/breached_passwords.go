@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Hex characters of the SHA-1 hash used to split the breached password list into ranges
+const breachedPrefixLength = 5
+
+// Checks passwords against a local copy of the Have I Been Pwned password list:
+// https://haveibeenpwned.com/Passwords
+//
+// File format: one `SHA1HASH:COUNT` per line, hex, sorted by hash (the "ordered by hash" download)
+// Only the offset where each 5-character hash prefix starts is kept in memory, each check reads
+// just that prefix's range from disk - the same k-anonymity split the HIBP range API uses
+type BreachedPasswordFile struct {
+	file *os.File
+	size int64
+
+	// Indexed by the 20-bit hash prefix, -1 if no hashes have that prefix
+	offsets []int64
+}
+
+// Opens and indexes the breached password file
+func OpenBreachedPasswordFile(path string) (*BreachedPasswordFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]int64, 1<<(4*breachedPrefixLength))
+	for i := range offsets {
+		offsets[i] = -1
+	}
+
+	reader := bufio.NewReader(file)
+	lineStart := int64(0)
+	lastPrefix := int64(-1)
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			file.Close()
+			return nil, err
+		}
+
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			prefix, err := parseHashPrefix(trimmed)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%v line %v: %v", path, lineNumber, err)
+			}
+
+			if prefix < lastPrefix {
+				file.Close()
+				return nil, fmt.Errorf("%v line %v: hashes must be sorted", path, lineNumber)
+			}
+
+			if prefix != lastPrefix {
+				offsets[prefix] = lineStart
+				lastPrefix = prefix
+			}
+		}
+
+		lineStart += int64(len(line))
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return &BreachedPasswordFile{
+		file:    file,
+		size:    lineStart,
+		offsets: offsets,
+	}, nil
+}
+
+// Returns true if the password's SHA-1 hash is in the file
+// Safe for concurrent use
+func (b *BreachedPasswordFile) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	prefix, err := parseHashPrefix(hash)
+	if err != nil {
+		return false, err
+	}
+
+	start := b.offsets[prefix]
+	if start == -1 {
+		return false, nil
+	}
+
+	// Read the prefix's range, stops at the first hash with a different prefix
+	reader := bufio.NewReader(io.NewSectionReader(b.file, start, b.size-start))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		lineHash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		lineHash = strings.ToUpper(lineHash)
+
+		if lineHash == hash {
+			return true, nil
+		}
+		if !strings.HasPrefix(lineHash, hash[:breachedPrefixLength]) || err == io.EOF {
+			return false, nil
+		}
+	}
+}
+
+func (b *BreachedPasswordFile) Close() error {
+	return b.file.Close()
+}
+
+func parseHashPrefix(line string) (int64, error) {
+	if len(line) < breachedPrefixLength {
+		return 0, fmt.Errorf("invalid hash: %v", line)
+	}
+
+	prefix, err := strconv.ParseInt(line[:breachedPrefixLength], 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash: %v", line)
+	}
+
+	return prefix, nil
+}
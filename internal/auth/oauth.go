@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// Prefixes for opaque tokens, so leaked tokens are easy to recognize (ex: by secret scanners)
+// and the kind of bearer token can be told apart without a database lookup
+const (
+	OAUTH_ACCESS_TOKEN_PREFIX  = "chirpy_at_"
+	OAUTH_REFRESH_TOKEN_PREFIX = "chirpy_rt_"
+	OAUTH_CLIENT_SECRET_PREFIX = "chirpy_cs_"
+
+	PERSONAL_ACCESS_TOKEN_PREFIX = "chirpy_pat_"
+
+	WEBHOOK_SECRET_PREFIX = "chirpy_whsec_"
+)
+
+// Returns a random, URL-safe token with 256 bits of entropy
+// Only its HashToken should be saved
+func MakeOpaqueToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// True if the PKCE code verifier matches the S256 code challenge
+// https://datatracker.ietf.org/doc/html/rfc7636#section-4.6
+func VerifyPKCE(codeVerifier, codeChallenge string) bool {
+	// 43-128 characters, see https://datatracker.ietf.org/doc/html/rfc7636#section-4.1
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := oauth2.GenerateVerifier()
+	challenge := oauth2.S256ChallengeFromVerifier(verifier)
+
+	cases := []struct {
+		name      string
+		verifier  string
+		challenge string
+		expected  bool
+	}{
+		{
+			name:      "Matching verifier",
+			verifier:  verifier,
+			challenge: challenge,
+			expected:  true,
+		},
+		{
+			name:      "Different verifier",
+			verifier:  oauth2.GenerateVerifier(),
+			challenge: challenge,
+			expected:  false,
+		},
+		{
+			name:      "Plain challenge not accepted",
+			verifier:  verifier,
+			challenge: verifier,
+			expected:  false,
+		},
+		{
+			name:      "Verifier too short",
+			verifier:  "abc",
+			challenge: oauth2.S256ChallengeFromVerifier("abc"),
+			expected:  false,
+		},
+	}
+
+	for _, c := range cases {
+		assertEqual(VerifyPKCE(c.verifier, c.challenge), c.expected, c.name, t)
+	}
+}
+
+func TestMakeOpaqueToken(t *testing.T) {
+	token1, err := MakeOpaqueToken(OAUTH_ACCESS_TOKEN_PREFIX)
+	if err != nil {
+		t.Error(err)
+	}
+
+	token2, err := MakeOpaqueToken(OAUTH_ACCESS_TOKEN_PREFIX)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !strings.HasPrefix(token1, OAUTH_ACCESS_TOKEN_PREFIX) {
+		t.Error(formatTestError("Token prefix", token1, OAUTH_ACCESS_TOKEN_PREFIX+"..."))
+	}
+	assertEqual(len(token1), len(OAUTH_ACCESS_TOKEN_PREFIX)+43, token1, t)
+
+	if token1 == token2 {
+		t.Error(formatTestError("Tokens are random", token1, "!= "+token2))
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	ALGORITHM_ARGON2ID = "argon2id"
+	ALGORITHM_BCRYPT   = "bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Argon2id settings, see RFC 9106: https://datatracker.ietf.org/doc/html/rfc9106
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// OWASP's minimum recommended argon2id settings (19 MiB, 2 iterations, 1 thread)
+// https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html#argon2id
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hashes new passwords with the configured algorithm, and verifies hashes made by any supported algorithm
+// Hashes are self-describing strings, so old hashes keep working after the algorithm or parameters change:
+//   - argon2id, PHC string format: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//     https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+//   - bcrypt, modular crypt format: $2a$10$<salt+hash>
+type PasswordHasher struct {
+	Algorithm  string
+	Argon2id   Argon2idParams
+	BcryptCost int
+
+	// Hash compared against when a user doesn't exist, see SimulateCheck
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+func NewPasswordHasher(algorithm string, argon2idParams Argon2idParams, bcryptCost int) (*PasswordHasher, error) {
+	if algorithm != ALGORITHM_ARGON2ID && algorithm != ALGORITHM_BCRYPT {
+		return nil, fmt.Errorf("unsupported password hashing algorithm: %v", algorithm)
+	}
+
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %v and %v", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if argon2idParams.Memory == 0 || argon2idParams.Iterations == 0 || argon2idParams.Parallelism == 0 || argon2idParams.SaltLength == 0 || argon2idParams.KeyLength == 0 {
+		return nil, fmt.Errorf("argon2id parameters must be greater than 0: %+v", argon2idParams)
+	}
+
+	return &PasswordHasher{
+		Algorithm:  algorithm,
+		Argon2id:   argon2idParams,
+		BcryptCost: bcryptCost,
+	}, nil
+}
+
+// argon2id with the default parameters
+func DefaultPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  ALGORITHM_ARGON2ID,
+		Argon2id:   DefaultArgon2idParams,
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// Returns the encoded hash of the password
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case ALGORITHM_ARGON2ID:
+		return h.hashArgon2id(password)
+	case ALGORITHM_BCRYPT:
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashedBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported password hashing algorithm: %v", h.Algorithm)
+	}
+}
+
+// Returns nil if the password matches the encoded hash, ErrPasswordMismatch if not
+// Malformed and unrecognized hashes (e.g. users without a password) still cost a hash comparison, see SimulateCheck
+func (h *PasswordHasher) Check(password, encodedHash string) error {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encodedHash)
+		if err != nil {
+			h.SimulateCheck(password)
+			return err
+		}
+
+		actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	case isBcryptHash(encodedHash):
+		err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrPasswordMismatch
+		}
+		return err
+	default:
+		h.SimulateCheck(password)
+		return fmt.Errorf("unrecognized password hash format")
+	}
+}
+
+// True if the hash was made with a different algorithm or parameters than the hasher's current settings
+// Checked after a successful login, when the plaintext password is available to rehash
+func (h *PasswordHasher) NeedsRehash(encodedHash string) bool {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		if h.Algorithm != ALGORITHM_ARGON2ID {
+			return true
+		}
+
+		params, salt, key, err := decodeArgon2id(encodedHash)
+		if err != nil {
+			return false
+		}
+
+		return params.Memory != h.Argon2id.Memory ||
+			params.Iterations != h.Argon2id.Iterations ||
+			params.Parallelism != h.Argon2id.Parallelism ||
+			uint32(len(salt)) != h.Argon2id.SaltLength ||
+			uint32(len(key)) != h.Argon2id.KeyLength
+	case isBcryptHash(encodedHash):
+		if h.Algorithm != ALGORITHM_BCRYPT {
+			return true
+		}
+
+		cost, err := bcrypt.Cost([]byte(encodedHash))
+		if err != nil {
+			return false
+		}
+
+		return cost != h.BcryptCost
+	default:
+		return false
+	}
+}
+
+// Runs a hash comparison that always fails, taking the same time as Check on a current hash
+// Used when the user doesn't exist or has no usable hash, so response times don't reveal which emails are registered
+func (h *PasswordHasher) SimulateCheck(password string) {
+	h.dummyHashOnce.Do(func() {
+		h.dummyHash, _ = h.Hash("chirpy-dummy-password")
+	})
+
+	// Hash fails for an unsupported algorithm, and Check would simulate again on the empty hash
+	if h.dummyHash == "" {
+		return
+	}
+	h.Check(password, h.dummyHash)
+}
+
+func (h *PasswordHasher) hashArgon2id(password string) (string, error) {
+	salt := make([]byte, h.Argon2id.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Argon2id.Iterations, h.Argon2id.Memory, h.Argon2id.Parallelism, h.Argon2id.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Argon2id.Memory,
+		h.Argon2id.Iterations,
+		h.Argon2id.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Parses `$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>`
+func decodeArgon2id(encodedHash string) (Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id version: %v", err)
+	}
+	if version != argon2.Version {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("unsupported argon2id version: %v", version)
+	}
+
+	params := Argon2idParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, fmt.Errorf("invalid argon2id key: %v", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+func isBcryptHash(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") || strings.HasPrefix(encodedHash, "$2y$")
+}
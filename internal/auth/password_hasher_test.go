@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small settings to keep the tests fast
+var testArgon2idParams = Argon2idParams{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	password := "correct horse battery staple"
+
+	for _, algorithm := range []string{ALGORITHM_ARGON2ID, ALGORITHM_BCRYPT} {
+		hasher, err := NewPasswordHasher(algorithm, testArgon2idParams, bcrypt.MinCost)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		hash, err := hasher.Hash(password)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assertEqual(hasher.Check(password, hash), nil, algorithm+": correct password", t)
+		assertEqual(hasher.Check("wrong password", hash), ErrPasswordMismatch, algorithm+": wrong password", t)
+		assertEqual(hasher.NeedsRehash(hash), false, algorithm+": current settings", t)
+	}
+}
+
+func TestPasswordHasherArgon2idFormat(t *testing.T) {
+	hasher, err := NewPasswordHasher(ALGORITHM_ARGON2ID, testArgon2idParams, bcrypt.MinCost)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	hash, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedPrefix := "$argon2id$v=19$m=64,t=1,p=1$"
+	if !strings.HasPrefix(hash, expectedPrefix) {
+		t.Error(formatTestError("PHC string format", hash, expectedPrefix+"<salt>$<hash>"))
+	}
+	assertEqual(strings.Count(hash, "$"), 5, hash, t)
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	password := "correct horse battery staple"
+
+	argon2idHasher, _ := NewPasswordHasher(ALGORITHM_ARGON2ID, testArgon2idParams, bcrypt.MinCost)
+	bcryptHasher, _ := NewPasswordHasher(ALGORITHM_BCRYPT, testArgon2idParams, bcrypt.MinCost)
+
+	strongerParams := testArgon2idParams
+	strongerParams.Iterations = 2
+	strongerArgon2idHasher, _ := NewPasswordHasher(ALGORITHM_ARGON2ID, strongerParams, bcrypt.MinCost)
+	strongerBcryptHasher, _ := NewPasswordHasher(ALGORITHM_BCRYPT, testArgon2idParams, bcrypt.MinCost+1)
+
+	argon2idHash, _ := argon2idHasher.Hash(password)
+	bcryptHash, _ := bcryptHasher.Hash(password)
+
+	cases := []struct {
+		name     string
+		hasher   *PasswordHasher
+		hash     string
+		expected bool
+	}{
+		{
+			name:     "Legacy bcrypt hash, argon2id configured",
+			hasher:   argon2idHasher,
+			hash:     bcryptHash,
+			expected: true,
+		},
+		{
+			name:     "argon2id hash, bcrypt configured",
+			hasher:   bcryptHasher,
+			hash:     argon2idHash,
+			expected: true,
+		},
+		{
+			name:     "Outdated argon2id parameters",
+			hasher:   strongerArgon2idHasher,
+			hash:     argon2idHash,
+			expected: true,
+		},
+		{
+			name:     "Outdated bcrypt cost",
+			hasher:   strongerBcryptHasher,
+			hash:     bcryptHash,
+			expected: true,
+		},
+		{
+			name:     "Unrecognized hash",
+			hasher:   argon2idHasher,
+			hash:     "unset",
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		assertEqual(c.hasher.NeedsRehash(c.hash), c.expected, c.name, t)
+	}
+
+	// Old hashes can still be verified after the settings change
+	assertEqual(argon2idHasher.Check(password, bcryptHash), nil, "argon2id hasher checks bcrypt hash", t)
+	assertEqual(strongerArgon2idHasher.Check(password, argon2idHash), nil, "checks hash with old parameters", t)
+}
+
+func TestNewPasswordHasherRejectsInvalidSettings(t *testing.T) {
+	cases := []struct {
+		name       string
+		algorithm  string
+		params     Argon2idParams
+		bcryptCost int
+	}{
+		{
+			name:       "Unknown algorithm",
+			algorithm:  "md5",
+			params:     testArgon2idParams,
+			bcryptCost: bcrypt.DefaultCost,
+		},
+		{
+			name:       "bcrypt cost too high",
+			algorithm:  ALGORITHM_BCRYPT,
+			params:     testArgon2idParams,
+			bcryptCost: bcrypt.MaxCost + 1,
+		},
+		{
+			name:       "Zero argon2id memory",
+			algorithm:  ALGORITHM_ARGON2ID,
+			params:     Argon2idParams{Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+			bcryptCost: bcrypt.DefaultCost,
+		},
+	}
+
+	for _, c := range cases {
+		_, err := NewPasswordHasher(c.algorithm, c.params, c.bcryptCost)
+		if err == nil {
+			t.Error(formatTestError(c.name, err, "error"))
+		}
+	}
+}
+
+func TestPasswordHasherRejectsMalformedHash(t *testing.T) {
+	hasher := DefaultPasswordHasher()
+
+	for _, hash := range []string{"", "unset", "$argon2id$v=19$m=64,t=1$c2FsdA$a2V5", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		err := hasher.Check("password", hash)
+		if err == nil || err == ErrPasswordMismatch {
+			t.Error(formatTestError(hash, err, "malformed hash error"))
+		}
+	}
+
+	// Compared against the dummy hash, so the response time matches a real password check
+	if hasher.dummyHash == "" {
+		t.Error(formatTestError("Malformed hashes", hasher.dummyHash, "dummy hash checked"))
+	}
+}
+
+func TestPasswordHasherUnsupportedAlgorithmSimulateCheck(t *testing.T) {
+	hasher := &PasswordHasher{Algorithm: "md5"}
+
+	// Doesn't recurse on the empty dummy hash
+	err := hasher.Check("password", "unset")
+	if err == nil || err == ErrPasswordMismatch {
+		t.Error(formatTestError("Unsupported algorithm", err, "unrecognized hash error"))
+	}
+}
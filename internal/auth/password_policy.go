@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt only uses the first 72 bytes of a password, and golang.org/x/crypto/bcrypt rejects anything longer
+const BCRYPT_MAX_PASSWORD_BYTES = 72
+
+// Minimum password length in characters, unless configured otherwise
+const DEFAULT_PASSWORD_MIN_LENGTH = 8
+
+// Machine-readable password violation codes
+const (
+	PASSWORD_TOO_SHORT        = "password_too_short"
+	PASSWORD_TOO_LONG         = "password_too_long"
+	PASSWORD_TOO_COMMON       = "password_too_common"
+	PASSWORD_SIMILAR_TO_EMAIL = "password_similar_to_email"
+	PASSWORD_BREACHED         = "password_breached"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile []byte
+
+// Rules new passwords must follow
+type PasswordPolicy struct {
+	MinLength int // In characters
+	MaxBytes  int // Longer passwords are rejected instead of silently truncated by the hasher
+
+	// Lowercase passwords that are always rejected
+	Blocklist map[string]bool
+
+	// Reject passwords containing the email's username (the part before the @)
+	DisallowEmailSimilarity bool
+
+	// Optional, checked last since it may read from disk
+	Breached BreachedPasswordChecker
+}
+
+// Reports if a password appears in a known data breach
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Returned when a password doesn't meet the policy, lists every rule that failed
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := []string{}
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "password does not meet requirements: " + strings.Join(messages, "; ")
+}
+
+// Default policy, roughly following NIST SP 800-63B: length over complexity rules, plus blocklists
+// https://pages.nist.gov/800-63-3/sp800-63b.html#memsecret
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:               DEFAULT_PASSWORD_MIN_LENGTH,
+		MaxBytes:                BCRYPT_MAX_PASSWORD_BYTES,
+		Blocklist:               ParsePasswordList(commonPasswordsFile),
+		DisallowEmailSimilarity: true,
+	}
+}
+
+// Parses a newline-separated password list, skipping blank lines and `#` comments
+func ParsePasswordList(data []byte) map[string]bool {
+	passwords := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+
+	return passwords
+}
+
+// Returns a *PasswordPolicyError if the password breaks any rules
+// Other errors mean the check itself failed (ex: the breached password file couldn't be read)
+func (p PasswordPolicy) Validate(password, email string) error {
+	violations := []PasswordViolation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PASSWORD_TOO_SHORT,
+			Message: fmt.Sprintf("Password must be at least %v characters", p.MinLength),
+		})
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, PasswordViolation{
+			Code:    PASSWORD_TOO_LONG,
+			Message: fmt.Sprintf("Password must be at most %v bytes", p.MaxBytes),
+		})
+	}
+
+	if p.Blocklist[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{
+			Code:    PASSWORD_TOO_COMMON,
+			Message: "Password is too common",
+		})
+	}
+
+	if p.DisallowEmailSimilarity && isSimilarToEmail(password, email) {
+		violations = append(violations, PasswordViolation{
+			Code:    PASSWORD_SIMILAR_TO_EMAIL,
+			Message: "Password must not contain your email",
+		})
+	}
+
+	// Skip the breach lookup if the password is already rejected
+	if len(violations) == 0 && p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return err
+		}
+
+		if breached {
+			violations = append(violations, PasswordViolation{
+				Code:    PASSWORD_BREACHED,
+				Message: "Password has appeared in a data breach, choose a different one",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// True if the password contains the email, or its username, forwards or backwards
+// Usernames shorter than 3 characters are ignored, they'd match too many passwords
+func isSimilarToEmail(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+
+	username, _, _ := strings.Cut(email, "@")
+
+	candidates := []string{email}
+	if len(username) >= 3 {
+		candidates = append(candidates, username)
+	}
+
+	reversedPassword := reverse(password)
+	for _, candidate := range candidates {
+		if strings.Contains(password, candidate) || strings.Contains(reversedPassword, candidate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
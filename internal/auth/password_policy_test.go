@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	cases := []struct {
+		name               string
+		password           string
+		email              string
+		expectedViolations []string
+	}{
+		{
+			name:               "Valid password",
+			password:           "correct horse battery staple",
+			email:              "user@email.com",
+			expectedViolations: []string{},
+		},
+		{
+			name:               "Too short",
+			password:           "x7#kQ",
+			email:              "user@email.com",
+			expectedViolations: []string{PASSWORD_TOO_SHORT},
+		},
+		{
+			name:               "Multi-byte characters count as 1 character",
+			password:           "ñañañaña",
+			email:              "user@email.com",
+			expectedViolations: []string{},
+		},
+		{
+			name:               "Over the bcrypt byte limit",
+			password:           strings.Repeat("ab", 40),
+			email:              "user@email.com",
+			expectedViolations: []string{PASSWORD_TOO_LONG},
+		},
+		{
+			name:               "Common password, any case",
+			password:           "PassWord123",
+			email:              "user@email.com",
+			expectedViolations: []string{PASSWORD_TOO_COMMON},
+		},
+		{
+			name:               "Contains email username",
+			password:           "lamont-is-great",
+			email:              "Lamont@email.com",
+			expectedViolations: []string{PASSWORD_SIMILAR_TO_EMAIL},
+		},
+		{
+			name:               "Contains reversed email username",
+			password:           "xx-tnomal-xx",
+			email:              "lamont@email.com",
+			expectedViolations: []string{PASSWORD_SIMILAR_TO_EMAIL},
+		},
+		{
+			name:               "Multiple violations",
+			password:           "abc",
+			email:              "abc@email.com",
+			expectedViolations: []string{PASSWORD_TOO_SHORT, PASSWORD_SIMILAR_TO_EMAIL},
+		},
+	}
+
+	for _, c := range cases {
+		err := policy.Validate(c.password, c.email)
+
+		actualViolations := []string{}
+		policyErr := &PasswordPolicyError{}
+		if errors.As(err, &policyErr) {
+			for _, v := range policyErr.Violations {
+				actualViolations = append(actualViolations, v.Code)
+			}
+		} else if err != nil {
+			t.Error(formatTestError(c.name, err, c.expectedViolations))
+		}
+
+		if !slices.Equal(actualViolations, c.expectedViolations) {
+			t.Error(formatTestError(c.name, actualViolations, c.expectedViolations))
+		}
+	}
+}
+
+func TestBreachedPasswordFile(t *testing.T) {
+	breachedPasswords := []string{"hunter2-but-longer", "Tr0ub4dor&3", "monkey-banana-42"}
+
+	// Write a small, sorted HIBP-style file, plus a hash sharing a prefix with a breached one
+	lines := []string{}
+	for i, password := range breachedPasswords {
+		lines = append(lines, fmt.Sprintf("%v:%v", sha1Hex(password), i+1))
+	}
+	lines = append(lines, sha1Hex(breachedPasswords[0])[:5]+"00000000000000000000000000000000000:1")
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned-passwords.txt")
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0600)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	breachedFile, err := OpenBreachedPasswordFile(path)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer breachedFile.Close()
+
+	for _, password := range breachedPasswords {
+		breached, err := breachedFile.IsBreached(password)
+		if err != nil {
+			t.Error(err)
+		}
+		assertEqual(breached, true, password, t)
+	}
+
+	for _, password := range []string{"correct horse battery staple", "hunter2-but-shorter"} {
+		breached, err := breachedFile.IsBreached(password)
+		if err != nil {
+			t.Error(err)
+		}
+		assertEqual(breached, false, password, t)
+	}
+
+	// Policy reports breached passwords
+	policy := DefaultPasswordPolicy()
+	policy.Breached = breachedFile
+
+	err = policy.Validate("monkey-banana-42", "user@email.com")
+	policyErr := &PasswordPolicyError{}
+	if !errors.As(err, &policyErr) || policyErr.Violations[0].Code != PASSWORD_BREACHED {
+		t.Error(formatTestError("breached password", err, PASSWORD_BREACHED))
+	}
+}
+
+func TestBreachedPasswordFileMustBeSorted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unsorted.txt")
+	err := os.WriteFile(path, []byte("FFFFF00000000000000000000000000000000000:1\n00000000000000000000000000000000000000AA:1\n"), 0600)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	_, err = OpenBreachedPasswordFile(path)
+	if err == nil {
+		t.Error(formatTestError("unsorted file", err, "error"))
+	}
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Time-based One-Time Password (TOTP) settings
+// RFC 6238: https://datatracker.ietf.org/doc/html/rfc6238
+// These are the defaults assumed by most authenticator apps (Google Authenticator, 1Password, etc.)
+const (
+	TOTP_ISSUER      = "Chirpy"
+	TOTP_DIGITS      = 6
+	TOTP_PERIOD      = 30 * time.Second
+	TOTP_SKEW_STEPS  = 1 // Accept codes from 1 step before/after the current step to allow for clock drift
+	TOTP_SECRET_SIZE = 20
+
+	RECOVERY_CODE_COUNT = 10
+)
+
+// TOTP secrets are encrypted at rest with AES-256-GCM, see EncryptTOTPSecret
+const (
+	TOTP_ENCRYPTION_KEY_SIZE = 32
+	TOTP_ENCRYPTED_PREFIX    = "v1:" // Secrets saved before encryption don't have it
+)
+
+// Unpadded base32, the encoding expected in otpauth:// URIs
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a new random, base32-encoded TOTP secret
+func MakeTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_SIZE)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// Returns the otpauth:// URI used by authenticator apps to enroll the secret, usually displayed as a QR code
+// Format: https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TOTPAuthURI(secret, accountName string) string {
+	label := url.PathEscape(TOTP_ISSUER + ":" + accountName)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTP_ISSUER)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	params.Set("period", fmt.Sprint(int(TOTP_PERIOD.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Returns the TOTP time step for the given time
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD.Seconds())
+}
+
+// Returns the TOTP code for the secret at the given time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+
+	// HOTP: https://datatracker.ietf.org/doc/html/rfc4226#section-5.3
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	binCode := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range TOTP_DIGITS {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, binCode%mod), nil
+}
+
+// Validates the code against the secret at time `t`, allowing for clock drift
+// Returns the matched time step - callers should reject steps at or before the last one used to prevent code reuse
+func ValidateTOTP(secret, code string, t time.Time) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return 0, fmt.Errorf("invalid TOTP code length")
+	}
+
+	currentStep := TOTPStep(t)
+	for step := currentStep - TOTP_SKEW_STEPS; step <= currentStep+TOTP_SKEW_STEPS; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid TOTP code")
+}
+
+// Returns single-use recovery codes, formatted as `xxxxx-xxxxx`
+// Only the hashes (see HashToken) should be saved
+func MakeRecoveryCodes() ([]string, error) {
+	codes := []string{}
+
+	for range RECOVERY_CODE_COUNT {
+		randBytes := make([]byte, 5)
+
+		_, err := rand.Read(randBytes)
+		if err != nil {
+			return []string{}, err
+		}
+
+		code := hex.EncodeToString(randBytes)
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// Returns the hex-encoded SHA-256 hash of a high-entropy token (recovery codes, etc.)
+// Unlike passwords, random tokens don't need a slow, salted hash, which also allows looking them up by hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Encrypts the TOTP secret for storage, returns "v1:" + base64(nonce + ciphertext)
+// The user ID is authenticated too, so a secret copied to another user's row doesn't decrypt
+func EncryptTOTPSecret(secret string, userID uuid.UUID, key []byte) (string, error) {
+	aead, err := totpAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), userID[:])
+	return TOTP_ENCRYPTED_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Reverses EncryptTOTPSecret
+// Secrets saved before encryption are returned as-is, with `legacy` set so they can be encrypted
+func DecryptTOTPSecret(stored string, userID uuid.UUID, key []byte) (secret string, legacy bool, err error) {
+	encoded, ok := strings.CutPrefix(stored, TOTP_ENCRYPTED_PREFIX)
+	if !ok {
+		return stored, true, nil
+	}
+
+	aead, err := totpAEAD(key)
+	if err != nil {
+		return "", false, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", false, fmt.Errorf("encrypted TOTP secret too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], userID[:])
+	if err != nil {
+		return "", false, fmt.Errorf("error decrypting TOTP secret: %w", err)
+	}
+
+	return string(plaintext), false, nil
+}
+
+func totpAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != TOTP_ENCRYPTION_KEY_SIZE {
+		return nil, fmt.Errorf("TOTP encryption key must be %v bytes, got %v", TOTP_ENCRYPTION_KEY_SIZE, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
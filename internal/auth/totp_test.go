@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B test vectors (SHA1), truncated to 6 digits
+	// https://datatracker.ietf.org/doc/html/rfc6238#appendix-B
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	cases := []struct {
+		unixTime int64
+		expected string
+	}{
+		{unixTime: 59, expected: "287082"},
+		{unixTime: 1111111109, expected: "081804"},
+		{unixTime: 1111111111, expected: "050471"},
+		{unixTime: 1234567890, expected: "005924"},
+		{unixTime: 2000000000, expected: "279037"},
+	}
+
+	for _, c := range cases {
+		actual, err := TOTPCode(secret, TOTPStep(time.Unix(c.unixTime, 0)))
+		if err != nil {
+			t.Error(err)
+		}
+
+		assertEqual(actual, c.expected, c.unixTime, t)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := MakeTOTPSecret()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	now := time.Now()
+	currentStep := TOTPStep(now)
+
+	cases := []struct {
+		name      string
+		step      int64
+		expectErr bool
+	}{
+		{name: "Current code", step: currentStep, expectErr: false},
+		{name: "Previous code within skew", step: currentStep - 1, expectErr: false},
+		{name: "Next code within skew", step: currentStep + 1, expectErr: false},
+		{name: "Old code", step: currentStep - 5, expectErr: true},
+	}
+
+	for _, c := range cases {
+		code, err := TOTPCode(secret, c.step)
+		if err != nil {
+			t.Error(err)
+		}
+
+		step, err := ValidateTOTP(secret, code, now)
+		if c.expectErr {
+			if err == nil {
+				t.Error(formatTestError(c.name, err, "error"))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Error(formatTestError(c.name, err, nil))
+		}
+		assertEqual(step, c.step, c.name, t)
+	}
+
+	// Malformed codes
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		_, err := ValidateTOTP(secret, code, now)
+		if err == nil {
+			t.Error(formatTestError(code, err, "error"))
+		}
+	}
+}
+
+func TestTOTPAuthURI(t *testing.T) {
+	uri := TOTPAuthURI("JBSWY3DPEHPK3PXP", "user@example.com")
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	assertEqual(parsed.Scheme, "otpauth", uri, t)
+	assertEqual(parsed.Host, "totp", uri, t)
+	assertEqual(parsed.Path, "/Chirpy:user@example.com", uri, t)
+	assertEqual(parsed.Query().Get("secret"), "JBSWY3DPEHPK3PXP", uri, t)
+	assertEqual(parsed.Query().Get("issuer"), TOTP_ISSUER, uri, t)
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes()
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertEqual(len(codes), RECOVERY_CODE_COUNT, codes, t)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || strings.Count(code, "-") != 1 {
+			t.Error(formatTestError("Recovery code format", code, "xxxxx-xxxxx"))
+		}
+		if seen[code] {
+			t.Error(formatTestError("Recovery codes are unique", code, "no duplicates"))
+		}
+		seen[code] = true
+	}
+}
+
+func TestEncryptTOTPSecret(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	userID := uuid.New()
+	key := []byte(strings.Repeat("k", TOTP_ENCRYPTION_KEY_SIZE))
+
+	encrypted, err := EncryptTOTPSecret(secret, userID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, TOTP_ENCRYPTED_PREFIX) || strings.Contains(encrypted, secret) {
+		t.Error(formatTestError("Encrypted secret", encrypted, TOTP_ENCRYPTED_PREFIX+"..."))
+	}
+
+	cases := []struct {
+		name           string
+		stored         string
+		userID         uuid.UUID
+		key            []byte
+		expectedSecret string
+		expectedLegacy bool
+		expectErr      bool
+	}{
+		{
+			name:           "Encrypted",
+			stored:         encrypted,
+			userID:         userID,
+			key:            key,
+			expectedSecret: secret,
+		},
+		{
+			name:           "Saved before encryption",
+			stored:         secret,
+			userID:         userID,
+			key:            key,
+			expectedSecret: secret,
+			expectedLegacy: true,
+		},
+		{
+			name:      "Another user's secret",
+			stored:    encrypted,
+			userID:    uuid.New(),
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "Wrong key",
+			stored:    encrypted,
+			userID:    userID,
+			key:       []byte(strings.Repeat("x", TOTP_ENCRYPTION_KEY_SIZE)),
+			expectErr: true,
+		},
+		{
+			name:      "Key too short",
+			stored:    encrypted,
+			userID:    userID,
+			key:       []byte("short"),
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		actualSecret, actualLegacy, err := DecryptTOTPSecret(c.stored, c.userID, c.key)
+		assertEqual(err != nil, c.expectErr, c.name, t)
+		assertEqual(actualSecret, c.expectedSecret, c.name, t)
+		assertEqual(actualLegacy, c.expectedLegacy, c.name, t)
+	}
+}
+
+func TestMFAChallengeTokenNotAcceptedAsAccessToken(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "acb123xyz!@#"
+	expiresIn, _ := time.ParseDuration(MFA_CHALLENGE_TOKEN_DURATION)
+
+	challengeToken, err := MakeMFAChallengeJWT(userID, tokenSecret, expiresIn)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Rejected as an access token
+	_, err = ValidateToken(challengeToken, tokenSecret)
+	if err == nil {
+		t.Error(formatTestError("Challenge token used as access token", err, "error"))
+	}
+
+	// Accepted as a challenge token
+	extractedUserID, err := ValidateMFAChallengeToken(challengeToken, tokenSecret)
+	if err != nil {
+		t.Error(err)
+	}
+	assertEqual(extractedUserID, userID, nil, t)
+
+	// Access tokens are not accepted as challenge tokens
+	accessToken, err := MakeJWT(userID, tokenSecret, expiresIn)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = ValidateMFAChallengeToken(accessToken, tokenSecret)
+	if err == nil {
+		t.Error(formatTestError("Access token used as challenge token", err, "error"))
+	}
+}
@@ -0,0 +1,444 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/logging"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Optional YAML config file, also set with --config
+const CONFIG_FILE_ENV = "CHIRPY_CONFIG"
+
+// Chirpy's settings
+// Each field's `env` tag is its environment variable, the YAML key is the same in lowercase (ex: db_url) and the flag is --db-url
+// `required` fields must be set, `required:"serve"` ones only to start the server, `secret` fields are redacted by Print
+type Config struct {
+	Addr     string `env:"ADDR"`
+	Platform string `env:"PLATFORM"` // "dev" enables /admin/reset
+	LogLevel string `env:"LOG_LEVEL"`
+
+	DBURL             string        `env:"DB_URL" required:"true" secret:"true"`
+	DBMaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS"`
+	DBMaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS"`
+	DBConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME"`
+	DBConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME"`
+
+	// Requests are still served this long after SIGTERM, see serveUntilDone
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY"`
+	OTelTracesExporter string        `env:"OTEL_TRACES_EXPORTER"`
+
+	JWTSecret string `env:"JWT_SECRET" required:"serve" secret:"true"`
+	// Any of these may sign Polka webhooks, more than one while rotating
+	PolkaWebhookSecrets []string `env:"POLKA_WEBHOOK_SECRETS" required:"serve" secret:"true"`
+	// Encrypts TOTP secrets at rest, 32 base64-encoded bytes, ex: from `openssl rand -base64 32`
+	TOTPEncryptionKey string `env:"TOTP_ENCRYPTION_KEY" required:"serve" secret:"true"`
+
+	// Passkeys (WebAuthn) relying party, must match the domain/origin the browser sees
+	WebAuthnRPID      string   `env:"WEBAUTHN_RP_ID"`
+	WebAuthnRPOrigins []string `env:"WEBAUTHN_RP_ORIGINS"`
+
+	// Each provider, ex: "google", is configured with OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID, OIDC_GOOGLE_CLIENT_SECRET
+	OIDCRedirectBaseURL string   `env:"OIDC_REDIRECT_BASE_URL"`
+	OIDCProviderNames   []string `env:"OIDC_PROVIDERS"`
+	OIDCProviders       []OIDCProvider
+
+	PasswordMinLength     int    `env:"PASSWORD_MIN_LENGTH"`
+	BreachedPasswordsFile string `env:"BREACHED_PASSWORDS_FILE"`
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM"`
+	Argon2MemoryKiB       int    `env:"ARGON2_MEMORY_KIB"`
+	Argon2Iterations      int    `env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism     int    `env:"ARGON2_PARALLELISM"`
+	BcryptCost            int    `env:"BCRYPT_COST"`
+}
+
+// "Sign in with" OpenID Connect provider
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// Settings for anything left unset
+func Defaults() Config {
+	return Config{
+		Addr:     ":8080",
+		LogLevel: "info",
+
+		DBMaxOpenConns:    25,
+		DBMaxIdleConns:    25,
+		DBConnMaxLifetime: 30 * time.Minute,
+		DBConnMaxIdleTime: 5 * time.Minute,
+
+		ShutdownDrainDelay: 5 * time.Second,
+
+		WebAuthnRPID:      "localhost",
+		WebAuthnRPOrigins: []string{"http://localhost:8080"},
+
+		OIDCRedirectBaseURL: "http://localhost:8080",
+
+		PasswordMinLength:     auth.DEFAULT_PASSWORD_MIN_LENGTH,
+		PasswordHashAlgorithm: auth.ALGORITHM_ARGON2ID,
+		Argon2MemoryKiB:       int(auth.DefaultArgon2idParams.Memory),
+		Argon2Iterations:      int(auth.DefaultArgon2idParams.Iterations),
+		Argon2Parallelism:     int(auth.DefaultArgon2idParams.Parallelism),
+		BcryptCost:            bcrypt.DefaultCost,
+	}
+}
+
+// A Config field and where it's set from
+type field struct {
+	key       string // Environment variable
+	index     int
+	required  bool
+	serveOnly bool // Only required to start the server, not for commands
+	secret    bool
+}
+
+func fields() []field {
+	fields := []field{}
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		key := t.Field(i).Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		fields = append(fields, field{
+			key:       key,
+			index:     i,
+			required:  t.Field(i).Tag.Get("required") != "",
+			serveOnly: t.Field(i).Tag.Get("required") == "serve",
+			secret:    t.Field(i).Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+func fileKey(key string) string {
+	return strings.ToLower(key)
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Reads the config, each source overriding the ones before it:
+// defaults, the YAML file (--config or CHIRPY_CONFIG), .env, environment variables, then flags
+// Empty values are treated as unset
+// Returns the arguments left after the flags, ex: a command
+func Load(args []string) (*Config, []string, error) {
+	// Flags are parsed first to find --config, but applied last
+	flagValues := map[string]string{}
+	flags := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML config file, overrides "+CONFIG_FILE_ENV)
+	for _, f := range fields() {
+		flags.Func(flagName(f.key), "overrides "+f.key, func(value string) error {
+			flagValues[f.key] = value
+			return nil
+		})
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// .env at root, optional
+	dotEnvValues, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("error reading .env: %w", err)
+	}
+
+	envValues := map[string]string{}
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		envValues[key] = value
+	}
+
+	if *configFile == "" {
+		*configFile = firstNonEmpty(envValues[CONFIG_FILE_ENV], dotEnvValues[CONFIG_FILE_ENV])
+	}
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		fileValues, err = readFile(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %v: %w", *configFile, err)
+		}
+	}
+
+	values := map[string]string{}
+	for _, source := range []map[string]string{fileValues, dotEnvValues, envValues, flagValues} {
+		for key, value := range source {
+			if value != "" {
+				values[key] = value
+			}
+		}
+	}
+
+	config := Defaults()
+	errs := []error{}
+	for _, f := range fields() {
+		value, ok := values[f.key]
+		if !ok {
+			continue
+		}
+
+		err := setField(reflect.ValueOf(&config).Elem().Field(f.index), value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v: %w", f.key, err))
+		}
+	}
+
+	for _, name := range config.OIDCProviderNames {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config.OIDCProviders = append(config.OIDCProviders, OIDCProvider{
+			Name:         name,
+			Issuer:       values[prefix+"ISSUER"],
+			ClientID:     values[prefix+"CLIENT_ID"],
+			ClientSecret: values[prefix+"CLIENT_SECRET"],
+		})
+	}
+
+	return &config, flags.Args(), errors.Join(errs...)
+}
+
+// Reports every required setting that's missing or invalid, to start the server
+func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+// Same as Validate, for one-off commands (ex: `migrate up`), which don't need the server's secrets
+func (c *Config) ValidateCommand() error {
+	return c.validate(false)
+}
+
+func (c *Config) validate(serving bool) error {
+	errs := []error{}
+
+	value := reflect.ValueOf(c).Elem()
+	for _, f := range fields() {
+		if !f.required || f.serveOnly && !serving {
+			continue
+		}
+
+		fieldValue := value.Field(f.index)
+		if fieldValue.IsZero() || fieldValue.Kind() == reflect.Slice && fieldValue.Len() == 0 {
+			errs = append(errs, fmt.Errorf("%v is required", f.key))
+		}
+	}
+
+	_, err := logging.ParseLevel(c.LogLevel)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid LOG_LEVEL: %w", err))
+	}
+
+	if c.PasswordHashAlgorithm != auth.ALGORITHM_ARGON2ID && c.PasswordHashAlgorithm != auth.ALGORITHM_BCRYPT {
+		errs = append(errs, fmt.Errorf("PASSWORD_HASH_ALGORITHM must be %v or %v", auth.ALGORITHM_ARGON2ID, auth.ALGORITHM_BCRYPT))
+	}
+
+	// Numbers are converted to smaller types (ex: uint8) when used, so out of range values would wrap around
+	ranges := []struct {
+		key      string
+		value    int
+		min, max int
+	}{
+		{"DB_MAX_OPEN_CONNS", c.DBMaxOpenConns, 0, math.MaxInt},
+		{"DB_MAX_IDLE_CONNS", c.DBMaxIdleConns, 0, math.MaxInt},
+		{"PASSWORD_MIN_LENGTH", c.PasswordMinLength, 1, math.MaxInt},
+		{"ARGON2_MEMORY_KIB", c.Argon2MemoryKiB, 1, math.MaxUint32},
+		{"ARGON2_ITERATIONS", c.Argon2Iterations, 1, math.MaxUint32},
+		{"ARGON2_PARALLELISM", c.Argon2Parallelism, 1, math.MaxUint8},
+		{"BCRYPT_COST", c.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost},
+	}
+	for _, r := range ranges {
+		if r.value < r.min || r.value > r.max {
+			errs = append(errs, fmt.Errorf("%v must be between %v and %v", r.key, r.min, r.max))
+		}
+	}
+
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"DB_CONN_MAX_LIFETIME", c.DBConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", c.DBConnMaxIdleTime},
+		{"SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%v can't be negative", d.key))
+		}
+	}
+
+	if c.TOTPEncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.TOTPEncryptionKey)
+		if err != nil || len(key) != auth.TOTP_ENCRYPTION_KEY_SIZE {
+			errs = append(errs, fmt.Errorf("TOTP_ENCRYPTION_KEY must be %v base64-encoded bytes", auth.TOTP_ENCRYPTION_KEY_SIZE))
+		}
+	}
+
+	for _, provider := range c.OIDCProviders {
+		prefix := "OIDC_" + strings.ToUpper(provider.Name) + "_"
+		if provider.Issuer == "" || provider.ClientID == "" {
+			errs = append(errs, fmt.Errorf("%vISSUER and %vCLIENT_ID required for OIDC provider %v", prefix, prefix, provider.Name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Writes the config as YAML (usable as a config file), with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value *yaml.Node) {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fileKey(key)}, value)
+	}
+	scalar := func(value string, secret bool) *yaml.Node {
+		if secret && value != "" {
+			value = logging.REDACTED
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: value, Style: yaml.DoubleQuotedStyle}
+	}
+
+	value := reflect.ValueOf(c).Elem()
+	for _, f := range fields() {
+		fieldValue := value.Field(f.index)
+		if fieldValue.Kind() == reflect.Slice {
+			list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for _, item := range fieldValue.Interface().([]string) {
+				list.Content = append(list.Content, scalar(item, f.secret))
+			}
+			add(f.key, list)
+			continue
+		}
+		if fieldValue.Kind() == reflect.Int {
+			add(f.key, &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(fieldValue.Int())})
+			continue
+		}
+		add(f.key, scalar(fmt.Sprint(fieldValue.Interface()), f.secret))
+	}
+
+	for _, provider := range c.OIDCProviders {
+		prefix := "OIDC_" + strings.ToUpper(provider.Name) + "_"
+		add(prefix+"ISSUER", scalar(provider.Issuer, false))
+		add(prefix+"CLIENT_ID", scalar(provider.ClientID, false))
+		add(prefix+"CLIENT_SECRET", scalar(provider.ClientSecret, true))
+	}
+
+	encoder := yaml.NewEncoder(w)
+	defer encoder.Close()
+	return encoder.Encode(doc)
+}
+
+// Reads a YAML file of `key: value` settings, keyed by environment variable
+// Lists may be YAML sequences or comma-separated
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]any{}
+	err = yaml.Unmarshal(data, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	knownKeys := map[string]bool{}
+	for _, f := range fields() {
+		knownKeys[f.key] = true
+	}
+
+	values := map[string]string{}
+	for key, value := range settings {
+		key = strings.ToUpper(key)
+		if !knownKeys[key] && !isOIDCProviderKey(key) {
+			return nil, fmt.Errorf("unknown setting %v", fileKey(key))
+		}
+
+		switch value := value.(type) {
+		case nil:
+		case []any:
+			items := []string{}
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("%v must be a value or a list", fileKey(key))
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+
+	return values, nil
+}
+
+// Ex: OIDC_GOOGLE_CLIENT_ID
+func isOIDCProviderKey(key string) bool {
+	if !strings.HasPrefix(key, "OIDC_") {
+		return false
+	}
+	for _, suffix := range []string{"_ISSUER", "_CLIENT_ID", "_CLIENT_SECRET"} {
+		if strings.HasSuffix(key, suffix) && len(key) > len("OIDC_")+len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		// Comma-separated, ex: "new-secret,old-secret"
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,262 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: inbound_webhooks.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const claimInboundWebhook = `-- name: ClaimInboundWebhook :execrows
+UPDATE inbound_webhooks
+SET event_id = $1,
+    event = $2,
+    status = 'processing',
+    processing_until = $3,
+    attempts = attempts + 1,
+    error = NULL,
+    updated_at = $4
+WHERE inbound_webhooks.id = $5
+    AND inbound_webhooks.status IN ('received', 'failed')
+    AND NOT EXISTS (
+    SELECT 1 FROM inbound_webhooks AS other
+    WHERE other.source = inbound_webhooks.source
+        AND other.event_id = $1
+        AND other.status IN ('processing', 'processed')
+        AND other.id <> $5
+)
+`
+
+type ClaimInboundWebhookParams struct {
+	EventID         sql.NullString
+	Event           sql.NullString
+	ProcessingUntil sql.NullTime
+	UpdatedAt       time.Time
+	ID              uuid.UUID
+}
+
+// Marks the delivery as processing the event until the lease expires, unless another delivery of the event is processing or processed it
+// 0 rows if the event was already handled
+func (q *Queries) ClaimInboundWebhook(ctx context.Context, arg ClaimInboundWebhookParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimInboundWebhook,
+		arg.EventID,
+		arg.Event,
+		arg.ProcessingUntil,
+		arg.UpdatedAt,
+		arg.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createInboundWebhook = `-- name: CreateInboundWebhook :one
+INSERT INTO inbound_webhooks (id, received_at, updated_at, source, headers, body, status)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    'received'
+)
+RETURNING id, received_at, updated_at, source, headers, body, event_id, event, status, error, attempts, processing_until
+`
+
+type CreateInboundWebhookParams struct {
+	ID         uuid.UUID
+	ReceivedAt time.Time
+	UpdatedAt  time.Time
+	Source     string
+	Headers    json.RawMessage
+	Body       []byte
+}
+
+func (q *Queries) CreateInboundWebhook(ctx context.Context, arg CreateInboundWebhookParams) (InboundWebhook, error) {
+	row := q.db.QueryRowContext(ctx, createInboundWebhook,
+		arg.ID,
+		arg.ReceivedAt,
+		arg.UpdatedAt,
+		arg.Source,
+		arg.Headers,
+		arg.Body,
+	)
+	var i InboundWebhook
+	err := row.Scan(
+		&i.ID,
+		&i.ReceivedAt,
+		&i.UpdatedAt,
+		&i.Source,
+		&i.Headers,
+		&i.Body,
+		&i.EventID,
+		&i.Event,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ProcessingUntil,
+	)
+	return i, err
+}
+
+const deleteInboundWebhooksBefore = `-- name: DeleteInboundWebhooksBefore :execrows
+DELETE FROM inbound_webhooks
+WHERE status <> 'processing' AND updated_at < $1
+`
+
+// Deliveries last updated before the cutoff, except any still processing
+// Their events are forgotten too, so a retry of one of them would be processed again
+func (q *Queries) DeleteInboundWebhooksBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteInboundWebhooksBefore, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const expireInboundWebhookLeases = `-- name: ExpireInboundWebhookLeases :exec
+UPDATE inbound_webhooks
+SET status = 'failed',
+    error = 'processing lease expired',
+    processing_until = NULL,
+    updated_at = $1
+WHERE status = 'processing' AND processing_until <= $1
+`
+
+// Fails deliveries whose processing lease expired (ex: the server crashed), so their events can be claimed again
+func (q *Queries) ExpireInboundWebhookLeases(ctx context.Context, now time.Time) error {
+	_, err := q.db.ExecContext(ctx, expireInboundWebhookLeases, now)
+	return err
+}
+
+const finishInboundWebhookProcessing = `-- name: FinishInboundWebhookProcessing :execrows
+UPDATE inbound_webhooks
+SET status = 'processed',
+    error = NULL,
+    processing_until = NULL,
+    updated_at = $1
+WHERE id = $2
+    AND status = 'processing'
+    AND processing_until > $1
+`
+
+type FinishInboundWebhookProcessingParams struct {
+	Now time.Time
+	ID  uuid.UUID
+}
+
+// Marks the delivery processed, in the same transaction as the event's changes
+// 0 rows if its lease expired, then the transaction must be rolled back since the event may be claimed again
+func (q *Queries) FinishInboundWebhookProcessing(ctx context.Context, arg FinishInboundWebhookProcessingParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, finishInboundWebhookProcessing, arg.Now, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getInboundWebhook = `-- name: GetInboundWebhook :one
+SELECT id, received_at, updated_at, source, headers, body, event_id, event, status, error, attempts, processing_until FROM inbound_webhooks
+WHERE id = $1
+`
+
+func (q *Queries) GetInboundWebhook(ctx context.Context, id uuid.UUID) (InboundWebhook, error) {
+	row := q.db.QueryRowContext(ctx, getInboundWebhook, id)
+	var i InboundWebhook
+	err := row.Scan(
+		&i.ID,
+		&i.ReceivedAt,
+		&i.UpdatedAt,
+		&i.Source,
+		&i.Headers,
+		&i.Body,
+		&i.EventID,
+		&i.Event,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ProcessingUntil,
+	)
+	return i, err
+}
+
+const getInboundWebhooksByStatus = `-- name: GetInboundWebhooksByStatus :many
+SELECT id, received_at, updated_at, source, headers, body, event_id, event, status, error, attempts, processing_until FROM inbound_webhooks
+WHERE status = $1
+ORDER BY received_at DESC
+LIMIT $2
+`
+
+type GetInboundWebhooksByStatusParams struct {
+	Status string
+	Limit  int32
+}
+
+// Newest first
+func (q *Queries) GetInboundWebhooksByStatus(ctx context.Context, arg GetInboundWebhooksByStatusParams) ([]InboundWebhook, error) {
+	rows, err := q.db.QueryContext(ctx, getInboundWebhooksByStatus, arg.Status, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []InboundWebhook
+	for rows.Next() {
+		var i InboundWebhook
+		if err := rows.Scan(
+			&i.ID,
+			&i.ReceivedAt,
+			&i.UpdatedAt,
+			&i.Source,
+			&i.Headers,
+			&i.Body,
+			&i.EventID,
+			&i.Event,
+			&i.Status,
+			&i.Error,
+			&i.Attempts,
+			&i.ProcessingUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setInboundWebhookStatus = `-- name: SetInboundWebhookStatus :exec
+UPDATE inbound_webhooks
+SET status = $2, error = $3, updated_at = $4, processing_until = NULL
+WHERE id = $1
+`
+
+type SetInboundWebhookStatusParams struct {
+	ID        uuid.UUID
+	Status    string
+	Error     sql.NullString
+	UpdatedAt time.Time
+}
+
+func (q *Queries) SetInboundWebhookStatus(ctx context.Context, arg SetInboundWebhookStatusParams) error {
+	_, err := q.db.ExecContext(ctx, setInboundWebhookStatus,
+		arg.ID,
+		arg.Status,
+		arg.Error,
+		arg.UpdatedAt,
+	)
+	return err
+}
@@ -6,6 +6,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,6 +20,81 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
+type InboundWebhook struct {
+	ID              uuid.UUID
+	ReceivedAt      time.Time
+	UpdatedAt       time.Time
+	Source          string
+	Headers         json.RawMessage
+	Body            []byte
+	EventID         sql.NullString
+	Event           sql.NullString
+	Status          string
+	Error           sql.NullString
+	Attempts        int32
+	ProcessingUntil sql.NullTime
+}
+
+type OauthApp struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	OwnerID          uuid.UUID
+	Name             string
+	ClientSecretHash sql.NullString
+	RedirectUris     []string
+}
+
+type OauthAuthorization struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	AppID     uuid.UUID
+	Scopes    []string
+}
+
+type OauthCode struct {
+	CodeHash        string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	AuthorizationID uuid.UUID
+	RedirectUri     string
+	Scopes          []string
+	CodeChallenge   string
+	UsedAt          sql.NullTime
+}
+
+type OauthToken struct {
+	TokenHash       string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	AuthorizationID uuid.UUID
+	Kind            string
+	Scopes          []string
+}
+
+type OidcLoginState struct {
+	State        string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       uuid.NullUUID
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+}
+
 type RefreshToken struct {
 	Token     string
 	CreatedAt time.Time
@@ -28,6 +104,35 @@ type RefreshToken struct {
 	RevokedAt sql.NullTime
 }
 
+type Subscription struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+}
+
+type SubscriptionEvent struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	SubscriptionID   uuid.UUID
+	Event            string
+	PolkaEventID     sql.NullString
+	Status           string
+	CurrentPeriodEnd time.Time
+}
+
+type TotpRecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
 type User struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
@@ -35,4 +140,74 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	Role           string
+	TotpSecret     sql.NullString
+	TotpEnabled    bool
+	TotpLastStep   int64
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	Provider  string
+	Subject   string
+	Email     string
+}
+
+type WebauthnCredential struct {
+	ID              []byte
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uuid.UUID
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+	LastUsedAt      sql.NullTime
+}
+
+type WebauthnSession struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UserID      uuid.NullUUID
+	Ceremony    string
+	SessionData json.RawMessage
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	EndpointID    uuid.UUID
+	EventID       uuid.UUID
+	EventType     string
+	Payload       []byte
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          uuid.UUID
+	AttemptedAt time.Time
+	DeliveryID  uuid.UUID
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+	DurationMs  int32
+}
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Url        string
+	Secret     string
+	EventTypes []string
 }
@@ -0,0 +1,443 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oauth.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createOAuthApp = `-- name: CreateOAuthApp :one
+INSERT INTO oauth_apps (id, created_at, updated_at, owner_id, name, client_secret_hash, redirect_uris)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING id, created_at, updated_at, owner_id, name, client_secret_hash, redirect_uris
+`
+
+type CreateOAuthAppParams struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	OwnerID          uuid.UUID
+	Name             string
+	ClientSecretHash sql.NullString
+	RedirectUris     []string
+}
+
+func (q *Queries) CreateOAuthApp(ctx context.Context, arg CreateOAuthAppParams) (OauthApp, error) {
+	row := q.db.QueryRowContext(ctx, createOAuthApp,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.OwnerID,
+		arg.Name,
+		arg.ClientSecretHash,
+		pq.Array(arg.RedirectUris),
+	)
+	var i OauthApp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.Name,
+		&i.ClientSecretHash,
+		pq.Array(&i.RedirectUris),
+	)
+	return i, err
+}
+
+const createOAuthCode = `-- name: CreateOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, created_at, expires_at, authorization_id, redirect_uri, scopes, code_challenge)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+`
+
+type CreateOAuthCodeParams struct {
+	CodeHash        string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	AuthorizationID uuid.UUID
+	RedirectUri     string
+	Scopes          []string
+	CodeChallenge   string
+}
+
+func (q *Queries) CreateOAuthCode(ctx context.Context, arg CreateOAuthCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthCode,
+		arg.CodeHash,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.AuthorizationID,
+		arg.RedirectUri,
+		pq.Array(arg.Scopes),
+		arg.CodeChallenge,
+	)
+	return err
+}
+
+const createOAuthToken = `-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, expires_at, authorization_id, kind, scopes)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+`
+
+type CreateOAuthTokenParams struct {
+	TokenHash       string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	AuthorizationID uuid.UUID
+	Kind            string
+	Scopes          []string
+}
+
+func (q *Queries) CreateOAuthToken(ctx context.Context, arg CreateOAuthTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthToken,
+		arg.TokenHash,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.AuthorizationID,
+		arg.Kind,
+		pq.Array(arg.Scopes),
+	)
+	return err
+}
+
+const deleteAllOAuthTokens = `-- name: DeleteAllOAuthTokens :execrows
+DELETE FROM oauth_tokens
+`
+
+func (q *Queries) DeleteAllOAuthTokens(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAllOAuthTokens)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredOAuthCodes = `-- name: DeleteExpiredOAuthCodes :exec
+DELETE FROM oauth_codes
+WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredOAuthCodes(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOAuthCodes, expiresAt)
+	return err
+}
+
+const deleteExpiredOAuthTokens = `-- name: DeleteExpiredOAuthTokens :exec
+DELETE FROM oauth_tokens
+WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredOAuthTokens(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOAuthTokens, expiresAt)
+	return err
+}
+
+const deleteOAuthAuthorization = `-- name: DeleteOAuthAuthorization :execrows
+DELETE FROM oauth_authorizations
+WHERE user_id = $1 AND app_id = $2
+`
+
+type DeleteOAuthAuthorizationParams struct {
+	UserID uuid.UUID
+	AppID  uuid.UUID
+}
+
+func (q *Queries) DeleteOAuthAuthorization(ctx context.Context, arg DeleteOAuthAuthorizationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOAuthAuthorization, arg.UserID, arg.AppID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteOAuthAuthorizationByID = `-- name: DeleteOAuthAuthorizationByID :exec
+DELETE FROM oauth_authorizations
+WHERE id = $1
+`
+
+// Revokes the authorization, with its codes and tokens
+func (q *Queries) DeleteOAuthAuthorizationByID(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteOAuthAuthorizationByID, id)
+	return err
+}
+
+const deleteOAuthToken = `-- name: DeleteOAuthToken :exec
+DELETE FROM oauth_tokens
+WHERE token_hash = $1
+`
+
+// Refresh tokens are rotated, each one is deleted as it's used
+func (q *Queries) DeleteOAuthToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, deleteOAuthToken, tokenHash)
+	return err
+}
+
+const getAuthorizedOAuthApps = `-- name: GetAuthorizedOAuthApps :many
+SELECT oauth_apps.id, oauth_apps.name, oauth_authorizations.scopes, oauth_authorizations.created_at AS authorized_at
+FROM oauth_authorizations
+JOIN oauth_apps ON oauth_apps.id = oauth_authorizations.app_id
+WHERE oauth_authorizations.user_id = $1
+ORDER BY oauth_authorizations.created_at ASC
+`
+
+type GetAuthorizedOAuthAppsRow struct {
+	ID           uuid.UUID
+	Name         string
+	Scopes       []string
+	AuthorizedAt time.Time
+}
+
+func (q *Queries) GetAuthorizedOAuthApps(ctx context.Context, userID uuid.UUID) ([]GetAuthorizedOAuthAppsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAuthorizedOAuthApps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAuthorizedOAuthAppsRow
+	for rows.Next() {
+		var i GetAuthorizedOAuthAppsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			pq.Array(&i.Scopes),
+			&i.AuthorizedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOAuthAccessToken = `-- name: GetOAuthAccessToken :one
+SELECT oauth_tokens.expires_at, oauth_tokens.scopes, oauth_authorizations.user_id, oauth_authorizations.app_id
+FROM oauth_tokens
+JOIN oauth_authorizations ON oauth_authorizations.id = oauth_tokens.authorization_id
+WHERE oauth_tokens.token_hash = $1 AND oauth_tokens.kind = 'access'
+`
+
+type GetOAuthAccessTokenRow struct {
+	ExpiresAt time.Time
+	Scopes    []string
+	UserID    uuid.UUID
+	AppID     uuid.UUID
+}
+
+func (q *Queries) GetOAuthAccessToken(ctx context.Context, tokenHash string) (GetOAuthAccessTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthAccessToken, tokenHash)
+	var i GetOAuthAccessTokenRow
+	err := row.Scan(
+		&i.ExpiresAt,
+		pq.Array(&i.Scopes),
+		&i.UserID,
+		&i.AppID,
+	)
+	return i, err
+}
+
+const getOAuthApp = `-- name: GetOAuthApp :one
+SELECT id, created_at, updated_at, owner_id, name, client_secret_hash, redirect_uris FROM oauth_apps
+WHERE id = $1
+`
+
+func (q *Queries) GetOAuthApp(ctx context.Context, id uuid.UUID) (OauthApp, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthApp, id)
+	var i OauthApp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.Name,
+		&i.ClientSecretHash,
+		pq.Array(&i.RedirectUris),
+	)
+	return i, err
+}
+
+const getOAuthAuthorization = `-- name: GetOAuthAuthorization :one
+SELECT id, created_at, updated_at, user_id, app_id, scopes FROM oauth_authorizations
+WHERE user_id = $1 AND app_id = $2
+`
+
+type GetOAuthAuthorizationParams struct {
+	UserID uuid.UUID
+	AppID  uuid.UUID
+}
+
+func (q *Queries) GetOAuthAuthorization(ctx context.Context, arg GetOAuthAuthorizationParams) (OauthAuthorization, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthAuthorization, arg.UserID, arg.AppID)
+	var i OauthAuthorization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.AppID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getOAuthAuthorizationByID = `-- name: GetOAuthAuthorizationByID :one
+SELECT id, created_at, updated_at, user_id, app_id, scopes FROM oauth_authorizations
+WHERE id = $1
+`
+
+func (q *Queries) GetOAuthAuthorizationByID(ctx context.Context, id uuid.UUID) (OauthAuthorization, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthAuthorizationByID, id)
+	var i OauthAuthorization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.AppID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getOAuthCodeForUpdate = `-- name: GetOAuthCodeForUpdate :one
+SELECT code_hash, created_at, expires_at, authorization_id, redirect_uri, scopes, code_challenge, used_at FROM oauth_codes
+WHERE code_hash = $1
+FOR UPDATE
+`
+
+// Locks the code until the transaction ends, so it's only exchanged once
+func (q *Queries) GetOAuthCodeForUpdate(ctx context.Context, codeHash string) (OauthCode, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthCodeForUpdate, codeHash)
+	var i OauthCode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AuthorizationID,
+		&i.RedirectUri,
+		pq.Array(&i.Scopes),
+		&i.CodeChallenge,
+		&i.UsedAt,
+	)
+	return i, err
+}
+
+const getOAuthRefreshTokenForUpdate = `-- name: GetOAuthRefreshTokenForUpdate :one
+SELECT token_hash, created_at, expires_at, authorization_id, kind, scopes FROM oauth_tokens
+WHERE token_hash = $1 AND kind = 'refresh'
+FOR UPDATE
+`
+
+// Locks the refresh token until the transaction ends, so it's only used once
+func (q *Queries) GetOAuthRefreshTokenForUpdate(ctx context.Context, tokenHash string) (OauthToken, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthRefreshTokenForUpdate, tokenHash)
+	var i OauthToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.AuthorizationID,
+		&i.Kind,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const upsertOAuthAuthorization = `-- name: UpsertOAuthAuthorization :one
+INSERT INTO oauth_authorizations (id, created_at, updated_at, user_id, app_id, scopes)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (user_id, app_id) DO UPDATE
+SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_authorizations.scopes || EXCLUDED.scopes) ORDER BY 1),
+    updated_at = EXCLUDED.updated_at
+RETURNING id, created_at, updated_at, user_id, app_id, scopes
+`
+
+type UpsertOAuthAuthorizationParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	AppID     uuid.UUID
+	Scopes    []string
+}
+
+// Adds the newly granted scopes to any the user already granted the app
+func (q *Queries) UpsertOAuthAuthorization(ctx context.Context, arg UpsertOAuthAuthorizationParams) (OauthAuthorization, error) {
+	row := q.db.QueryRowContext(ctx, upsertOAuthAuthorization,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.UserID,
+		arg.AppID,
+		pq.Array(arg.Scopes),
+	)
+	var i OauthAuthorization
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.AppID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const useOAuthCode = `-- name: UseOAuthCode :exec
+UPDATE oauth_codes
+SET used_at = $2
+WHERE code_hash = $1
+`
+
+type UseOAuthCodeParams struct {
+	CodeHash string
+	UsedAt   sql.NullTime
+}
+
+// Codes are single-use, used ones are kept until they expire to detect reuse
+func (q *Queries) UseOAuthCode(ctx context.Context, arg UseOAuthCodeParams) error {
+	_, err := q.db.ExecContext(ctx, useOAuthCode, arg.CodeHash, arg.UsedAt)
+	return err
+}
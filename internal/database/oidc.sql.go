@@ -0,0 +1,162 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oidc.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const consumeOIDCLoginState = `-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state = $1 AND provider = $2
+RETURNING state, created_at, expires_at, provider, nonce, code_verifier, user_id
+`
+
+type ConsumeOIDCLoginStateParams struct {
+	State    string
+	Provider string
+}
+
+// States are single-use, so they're deleted as they're read
+func (q *Queries) ConsumeOIDCLoginState(ctx context.Context, arg ConsumeOIDCLoginStateParams) (OidcLoginState, error) {
+	row := q.db.QueryRowContext(ctx, consumeOIDCLoginState, arg.State, arg.Provider)
+	var i OidcLoginState
+	err := row.Scan(
+		&i.State,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Provider,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const createOIDCLoginState = `-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state, created_at, expires_at, provider, nonce, code_verifier, user_id)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+`
+
+type CreateOIDCLoginStateParams struct {
+	State        string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       uuid.NullUUID
+}
+
+func (q *Queries) CreateOIDCLoginState(ctx context.Context, arg CreateOIDCLoginStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCLoginState,
+		arg.State,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.Provider,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.UserID,
+	)
+	return err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identities (id, created_at, updated_at, user_id, provider, subject, email)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING id, created_at, updated_at, user_id, provider, subject, email
+`
+
+type CreateUserIdentityParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	Provider  string
+	Subject   string
+	Email     string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRowContext(ctx, createUserIdentity,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.UserID,
+		arg.Provider,
+		arg.Subject,
+		arg.Email,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+	)
+	return i, err
+}
+
+const deleteExpiredOIDCLoginStates = `-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredOIDCLoginStates(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCLoginStates, expiresAt)
+	return err
+}
+
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red, users.role, users.totp_secret, users.totp_enabled, users.totp_last_step FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.provider = $1 AND user_identities.subject = $2
+`
+
+type GetUserByIdentityParams struct {
+	Provider string
+	Subject  string
+}
+
+func (q *Queries) GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByIdentity, arg.Provider, arg.Subject)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.TotpLastStep,
+	)
+	return i, err
+}
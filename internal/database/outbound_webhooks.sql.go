@@ -0,0 +1,347 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: outbound_webhooks.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const claimDueWebhookDeliveries = `-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = $1, updated_at = $2
+FROM webhook_endpoints
+WHERE webhook_endpoints.id = webhook_deliveries.endpoint_id
+    AND webhook_deliveries.id IN (
+        SELECT due.id FROM webhook_deliveries AS due
+        WHERE due.status = 'pending' AND due.next_attempt_at <= $2
+        ORDER BY due.next_attempt_at
+        LIMIT $3
+        FOR UPDATE SKIP LOCKED
+    )
+RETURNING webhook_deliveries.id, webhook_deliveries.created_at, webhook_deliveries.updated_at, webhook_deliveries.endpoint_id, webhook_deliveries.event_id, webhook_deliveries.event_type, webhook_deliveries.payload, webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.next_attempt_at, webhook_endpoints.url, webhook_endpoints.secret
+`
+
+type ClaimDueWebhookDeliveriesParams struct {
+	LeaseUntil    time.Time
+	Now           time.Time
+	MaxDeliveries int32
+}
+
+type ClaimDueWebhookDeliveriesRow struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	EndpointID    uuid.UUID
+	EventID       uuid.UUID
+	EventType     string
+	Payload       []byte
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	Url           string
+	Secret        string
+}
+
+// Takes due deliveries off the queue until `lease_until`, so they aren't sent twice by concurrent workers
+// If the worker stops before recording the result, the delivery is retried after the lease
+func (q *Queries) ClaimDueWebhookDeliveries(ctx context.Context, arg ClaimDueWebhookDeliveriesParams) ([]ClaimDueWebhookDeliveriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueWebhookDeliveries, arg.LeaseUntil, arg.Now, arg.MaxDeliveries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimDueWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimDueWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.EndpointID,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createWebhookDeliveryAttempt = `-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, attempted_at, delivery_id, status_code, error, duration_ms)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+`
+
+type CreateWebhookDeliveryAttemptParams struct {
+	ID          uuid.UUID
+	AttemptedAt time.Time
+	DeliveryID  uuid.UUID
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+	DurationMs  int32
+}
+
+func (q *Queries) CreateWebhookDeliveryAttempt(ctx context.Context, arg CreateWebhookDeliveryAttemptParams) error {
+	_, err := q.db.ExecContext(ctx, createWebhookDeliveryAttempt,
+		arg.ID,
+		arg.AttemptedAt,
+		arg.DeliveryID,
+		arg.StatusCode,
+		arg.Error,
+		arg.DurationMs,
+	)
+	return err
+}
+
+const createWebhookEndpoint = `-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, url, secret, event_types)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING id, created_at, updated_at, url, secret, event_types
+`
+
+type CreateWebhookEndpointParams struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Url        string
+	Secret     string
+	EventTypes []string
+}
+
+func (q *Queries) CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRowContext(ctx, createWebhookEndpoint,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.Url,
+		arg.Secret,
+		pq.Array(arg.EventTypes),
+	)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Url,
+		&i.Secret,
+		pq.Array(&i.EventTypes),
+	)
+	return i, err
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1
+`
+
+func (q *Queries) DeleteWebhookEndpoint(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWebhookEndpoint, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), $1, $1, webhook_endpoints.id, $2, $3, $4, 'pending', $1
+FROM webhook_endpoints
+WHERE $3::TEXT = ANY(webhook_endpoints.event_types)
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	Now       time.Time
+	EventID   uuid.UUID
+	EventType string
+	Payload   []byte
+}
+
+// Queues the event for every endpoint subscribed to its type
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enqueueWebhookDeliveries,
+		arg.Now,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getWebhookDeliveryAttempts = `-- name: GetWebhookDeliveryAttempts :many
+SELECT webhook_delivery_attempts.id, webhook_delivery_attempts.attempted_at, webhook_delivery_attempts.delivery_id, webhook_delivery_attempts.status_code, webhook_delivery_attempts.error, webhook_delivery_attempts.duration_ms, webhook_deliveries.event_id, webhook_deliveries.event_type,
+    webhook_deliveries.status AS delivery_status
+FROM webhook_delivery_attempts
+JOIN webhook_deliveries ON webhook_deliveries.id = webhook_delivery_attempts.delivery_id
+WHERE webhook_deliveries.endpoint_id = $1
+ORDER BY webhook_delivery_attempts.attempted_at DESC
+LIMIT $2
+`
+
+type GetWebhookDeliveryAttemptsParams struct {
+	EndpointID uuid.UUID
+	Limit      int32
+}
+
+type GetWebhookDeliveryAttemptsRow struct {
+	ID             uuid.UUID
+	AttemptedAt    time.Time
+	DeliveryID     uuid.UUID
+	StatusCode     sql.NullInt32
+	Error          sql.NullString
+	DurationMs     int32
+	EventID        uuid.UUID
+	EventType      string
+	DeliveryStatus string
+}
+
+// Newest first
+func (q *Queries) GetWebhookDeliveryAttempts(ctx context.Context, arg GetWebhookDeliveryAttemptsParams) ([]GetWebhookDeliveryAttemptsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getWebhookDeliveryAttempts, arg.EndpointID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetWebhookDeliveryAttemptsRow
+	for rows.Next() {
+		var i GetWebhookDeliveryAttemptsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.AttemptedAt,
+			&i.DeliveryID,
+			&i.StatusCode,
+			&i.Error,
+			&i.DurationMs,
+			&i.EventID,
+			&i.EventType,
+			&i.DeliveryStatus,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookEndpoints = `-- name: GetWebhookEndpoints :many
+SELECT id, created_at, updated_at, url, secret, event_types FROM webhook_endpoints
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error) {
+	rows, err := q.db.QueryContext(ctx, getWebhookEndpoints)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEndpoint
+	for rows.Next() {
+		var i WebhookEndpoint
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Url,
+			&i.Secret,
+			pq.Array(&i.EventTypes),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const requeueWebhookDelivery = `-- name: RequeueWebhookDelivery :execrows
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = $1, updated_at = $1
+WHERE id = $2 AND status = 'dead'
+`
+
+type RequeueWebhookDeliveryParams struct {
+	Now time.Time
+	ID  uuid.UUID
+}
+
+// Sends a dead-lettered delivery again, as if it were new
+func (q *Queries) RequeueWebhookDelivery(ctx context.Context, arg RequeueWebhookDeliveryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueWebhookDelivery, arg.Now, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setWebhookDeliveryResult = `-- name: SetWebhookDeliveryResult :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, next_attempt_at = $4, updated_at = $5
+WHERE id = $1
+`
+
+type SetWebhookDeliveryResultParams struct {
+	ID            uuid.UUID
+	Status        string
+	Attempts      int32
+	NextAttemptAt time.Time
+	UpdatedAt     time.Time
+}
+
+func (q *Queries) SetWebhookDeliveryResult(ctx context.Context, arg SetWebhookDeliveryResultParams) error {
+	_, err := q.db.ExecContext(ctx, setWebhookDeliveryResult,
+		arg.ID,
+		arg.Status,
+		arg.Attempts,
+		arg.NextAttemptAt,
+		arg.UpdatedAt,
+	)
+	return err
+}
@@ -0,0 +1,47 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: polka_webhooks.sql
+
+package database
+
+import (
+	"context"
+	"time"
+)
+
+const deletePolkaWebhookEvent = `-- name: DeletePolkaWebhookEvent :exec
+DELETE FROM polka_webhook_events
+WHERE event_id = $1
+`
+
+// Forgets an event that failed, so Polka's retry is handled
+func (q *Queries) DeletePolkaWebhookEvent(ctx context.Context, eventID string) error {
+	_, err := q.db.ExecContext(ctx, deletePolkaWebhookEvent, eventID)
+	return err
+}
+
+const recordPolkaWebhookEvent = `-- name: RecordPolkaWebhookEvent :execrows
+INSERT INTO polka_webhook_events (event_id, event, received_at)
+VALUES (
+    $1,
+    $2,
+    $3
+)
+ON CONFLICT (event_id) DO NOTHING
+`
+
+type RecordPolkaWebhookEventParams struct {
+	EventID    string
+	Event      string
+	ReceivedAt time.Time
+}
+
+// 0 rows if the event was already recorded
+func (q *Queries) RecordPolkaWebhookEvent(ctx context.Context, arg RecordPolkaWebhookEventParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, recordPolkaWebhookEvent, arg.EventID, arg.Event, arg.ReceivedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
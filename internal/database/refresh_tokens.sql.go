@@ -71,6 +71,20 @@ func (q *Queries) GetRefreshTokenInfo(ctx context.Context, token string) (GetRef
 	return i, err
 }
 
+const revokeAllRefreshTokens = `-- name: RevokeAllRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = $1, updated_at = $1
+WHERE revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllRefreshTokens(ctx context.Context, revokedAt sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeAllRefreshTokens, revokedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const setRefreshTokenRevokeAtTime = `-- name: SetRefreshTokenRevokeAtTime :exec
 UPDATE refresh_tokens
 SET revoked_at = $2, updated_at = $3
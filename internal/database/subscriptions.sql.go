@@ -0,0 +1,335 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: subscriptions.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createSubscriptionEvent = `-- name: CreateSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, subscription_id, event, polka_event_id, status, current_period_end)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+`
+
+type CreateSubscriptionEventParams struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	SubscriptionID   uuid.UUID
+	Event            string
+	PolkaEventID     sql.NullString
+	Status           string
+	CurrentPeriodEnd time.Time
+}
+
+func (q *Queries) CreateSubscriptionEvent(ctx context.Context, arg CreateSubscriptionEventParams) error {
+	_, err := q.db.ExecContext(ctx, createSubscriptionEvent,
+		arg.ID,
+		arg.CreatedAt,
+		arg.SubscriptionID,
+		arg.Event,
+		arg.PolkaEventID,
+		arg.Status,
+		arg.CurrentPeriodEnd,
+	)
+	return err
+}
+
+const expireLapsedSubscriptions = `-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired', updated_at = $1
+WHERE status <> 'expired' AND current_period_end <= $1
+RETURNING id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end
+`
+
+// Subscriptions whose period ended without a renewal
+func (q *Queries) ExpireLapsedSubscriptions(ctx context.Context, now time.Time) ([]Subscription, error) {
+	rows, err := q.db.QueryContext(ctx, expireLapsedSubscriptions, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Plan,
+			&i.Status,
+			&i.CurrentPeriodStart,
+			&i.CurrentPeriodEnd,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubscriptionByUserID = `-- name: GetSubscriptionByUserID :one
+SELECT id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end FROM subscriptions
+WHERE user_id = $1
+`
+
+func (q *Queries) GetSubscriptionByUserID(ctx context.Context, userID uuid.UUID) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, getSubscriptionByUserID, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Plan,
+		&i.Status,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
+
+const getSubscriptionByUserIDForUpdate = `-- name: GetSubscriptionByUserIDForUpdate :one
+SELECT id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE
+`
+
+// Locks the row until the transaction ends, so concurrent events for the user apply one at a time
+func (q *Queries) GetSubscriptionByUserIDForUpdate(ctx context.Context, userID uuid.UUID) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, getSubscriptionByUserIDForUpdate, userID)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Plan,
+		&i.Status,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
+
+const getSubscriptionEvents = `-- name: GetSubscriptionEvents :many
+SELECT id, created_at, subscription_id, event, polka_event_id, status, current_period_end FROM subscription_events
+WHERE subscription_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetSubscriptionEvents(ctx context.Context, subscriptionID uuid.UUID) ([]SubscriptionEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getSubscriptionEvents, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubscriptionEvent
+	for rows.Next() {
+		var i SubscriptionEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.SubscriptionID,
+			&i.Event,
+			&i.PolkaEventID,
+			&i.Status,
+			&i.CurrentPeriodEnd,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const refreshUserChirpyRed = `-- name: RefreshUserChirpyRed :one
+UPDATE users
+SET is_chirpy_red = EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+        AND subscriptions.status <> 'expired'
+        AND subscriptions.current_period_end > $1
+)
+WHERE users.id = $2
+RETURNING users.id, users.is_chirpy_red
+`
+
+type RefreshUserChirpyRedParams struct {
+	Now    time.Time
+	UserID uuid.UUID
+}
+
+type RefreshUserChirpyRedRow struct {
+	ID          uuid.UUID
+	IsChirpyRed bool
+}
+
+// Sets is_chirpy_red from the user's subscription
+func (q *Queries) RefreshUserChirpyRed(ctx context.Context, arg RefreshUserChirpyRedParams) (RefreshUserChirpyRedRow, error) {
+	row := q.db.QueryRowContext(ctx, refreshUserChirpyRed, arg.Now, arg.UserID)
+	var i RefreshUserChirpyRedRow
+	err := row.Scan(&i.ID, &i.IsChirpyRed)
+	return i, err
+}
+
+const revokeLapsedChirpyRed = `-- name: RevokeLapsedChirpyRed :many
+UPDATE users
+SET is_chirpy_red = false
+WHERE is_chirpy_red AND NOT EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+        AND subscriptions.status <> 'expired'
+        AND subscriptions.current_period_end > $1
+)
+RETURNING users.id
+`
+
+// Turns off is_chirpy_red for every user without a current subscription
+func (q *Queries) RevokeLapsedChirpyRed(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, revokeLapsedChirpyRed, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateSubscription = `-- name: UpdateSubscription :one
+UPDATE subscriptions
+SET status = $1,
+    current_period_start = $2,
+    current_period_end = $3,
+    updated_at = $4
+WHERE user_id = $5
+RETURNING id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end
+`
+
+type UpdateSubscriptionParams struct {
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+}
+
+func (q *Queries) UpdateSubscription(ctx context.Context, arg UpdateSubscriptionParams) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, updateSubscription,
+		arg.Status,
+		arg.CurrentPeriodStart,
+		arg.CurrentPeriodEnd,
+		arg.UpdatedAt,
+		arg.UserID,
+	)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Plan,
+		&i.Status,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    updated_at = EXCLUDED.updated_at
+RETURNING id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end
+`
+
+type UpsertSubscriptionParams struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+}
+
+// Starts a new period, replacing any earlier subscription
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, upsertSubscription,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.UserID,
+		arg.Plan,
+		arg.Status,
+		arg.CurrentPeriodStart,
+		arg.CurrentPeriodEnd,
+	)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Plan,
+		&i.Status,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+	)
+	return i, err
+}
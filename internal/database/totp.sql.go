@@ -0,0 +1,143 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: totp.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO totp_recovery_codes (id, created_at, user_id, code_hash)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+)
+`
+
+type CreateRecoveryCodeParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UserID,
+		arg.CodeHash,
+	)
+	return err
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM totp_recovery_codes
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const enableUserTOTP = `-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled = true, totp_last_step = $2, updated_at = $3
+WHERE id = $1
+`
+
+type EnableUserTOTPParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+	UpdatedAt    time.Time
+}
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, arg EnableUserTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, enableUserTOTP, arg.ID, arg.TotpLastStep, arg.UpdatedAt)
+	return err
+}
+
+const replaceUserTOTPSecret = `-- name: ReplaceUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2
+WHERE id = $1
+`
+
+type ReplaceUserTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+// Replaces the secret without resetting enrollment, ex: to encrypt a secret saved before encryption
+func (q *Queries) ReplaceUserTOTPSecret(ctx context.Context, arg ReplaceUserTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, replaceUserTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserTOTPLastStep = `-- name: SetUserTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2
+`
+
+type SetUserTOTPLastStepParams struct {
+	ID           uuid.UUID
+	TotpLastStep int64
+}
+
+// Only succeeds (1 row affected) if the step is newer than the last accepted one
+func (q *Queries) SetUserTOTPLastStep(ctx context.Context, arg SetUserTOTPLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserTOTPLastStep, arg.ID, arg.TotpLastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserTOTPSecret = `-- name: SetUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = false, updated_at = $3
+WHERE id = $1
+`
+
+type SetUserTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+	UpdatedAt  time.Time
+}
+
+func (q *Queries) SetUserTOTPSecret(ctx context.Context, arg SetUserTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setUserTOTPSecret, arg.ID, arg.TotpSecret, arg.UpdatedAt)
+	return err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE totp_recovery_codes
+SET used_at = $3
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   uuid.UUID
+	CodeHash string
+	UsedAt   sql.NullTime
+}
+
+// Only succeeds (1 row affected) if the code exists and hasn't been used
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, arg.UserID, arg.CodeHash, arg.UsedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,219 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: webauthn.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const consumeWebAuthnSession = `-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1 AND ceremony = $2
+RETURNING id, created_at, expires_at, user_id, ceremony, session_data
+`
+
+type ConsumeWebAuthnSessionParams struct {
+	ID       uuid.UUID
+	Ceremony string
+}
+
+// Sessions are single-use, so they're deleted as they're read
+func (q *Queries) ConsumeWebAuthnSession(ctx context.Context, arg ConsumeWebAuthnSessionParams) (WebauthnSession, error) {
+	row := q.db.QueryRowContext(ctx, consumeWebAuthnSession, arg.ID, arg.Ceremony)
+	var i WebauthnSession
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UserID,
+		&i.Ceremony,
+		&i.SessionData,
+	)
+	return i, err
+}
+
+const createWebAuthnCredential = `-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    $11,
+    $12
+)
+RETURNING id, created_at, updated_at, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, last_used_at
+`
+
+type CreateWebAuthnCredentialParams struct {
+	ID              []byte
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	UserID          uuid.UUID
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	Aaguid          []byte
+	SignCount       int64
+	BackupEligible  bool
+	BackupState     bool
+}
+
+func (q *Queries) CreateWebAuthnCredential(ctx context.Context, arg CreateWebAuthnCredentialParams) (WebauthnCredential, error) {
+	row := q.db.QueryRowContext(ctx, createWebAuthnCredential,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.UserID,
+		arg.Name,
+		arg.PublicKey,
+		arg.AttestationType,
+		pq.Array(arg.Transports),
+		arg.Aaguid,
+		arg.SignCount,
+		arg.BackupEligible,
+		arg.BackupState,
+	)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.Name,
+		&i.PublicKey,
+		&i.AttestationType,
+		pq.Array(&i.Transports),
+		&i.Aaguid,
+		&i.SignCount,
+		&i.BackupEligible,
+		&i.BackupState,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createWebAuthnSession = `-- name: CreateWebAuthnSession :exec
+INSERT INTO webauthn_sessions (id, created_at, expires_at, user_id, ceremony, session_data)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+`
+
+type CreateWebAuthnSessionParams struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UserID      uuid.NullUUID
+	Ceremony    string
+	SessionData json.RawMessage
+}
+
+func (q *Queries) CreateWebAuthnSession(ctx context.Context, arg CreateWebAuthnSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnSession,
+		arg.ID,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.UserID,
+		arg.Ceremony,
+		arg.SessionData,
+	)
+	return err
+}
+
+const deleteExpiredWebAuthnSessions = `-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthn_sessions
+WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredWebAuthnSessions(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWebAuthnSessions, expiresAt)
+	return err
+}
+
+const getWebAuthnCredentialsByUserID = `-- name: GetWebAuthnCredentialsByUserID :many
+SELECT id, created_at, updated_at, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, last_used_at FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetWebAuthnCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]WebauthnCredential, error) {
+	rows, err := q.db.QueryContext(ctx, getWebAuthnCredentialsByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebauthnCredential
+	for rows.Next() {
+		var i WebauthnCredential
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.Name,
+			&i.PublicKey,
+			&i.AttestationType,
+			pq.Array(&i.Transports),
+			&i.Aaguid,
+			&i.SignCount,
+			&i.BackupEligible,
+			&i.BackupState,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateWebAuthnCredentialUsage = `-- name: UpdateWebAuthnCredentialUsage :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, backup_state = $3, last_used_at = $4, updated_at = $4
+WHERE id = $1
+`
+
+type UpdateWebAuthnCredentialUsageParams struct {
+	ID          []byte
+	SignCount   int64
+	BackupState bool
+	LastUsedAt  sql.NullTime
+}
+
+func (q *Queries) UpdateWebAuthnCredentialUsage(ctx context.Context, arg UpdateWebAuthnCredentialUsageParams) error {
+	_, err := q.db.ExecContext(ctx, updateWebAuthnCredentialUsage,
+		arg.ID,
+		arg.SignCount,
+		arg.BackupState,
+		arg.LastUsedAt,
+	)
+	return err
+}
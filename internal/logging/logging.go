@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Replaces secrets in log output
+const REDACTED = "[REDACTED]"
+
+// Attributes whose key ends with one of these are never logged, ex: "password", "refresh_token"
+var sensitiveKeySuffixes = []string{"password", "token", "secret", "authorization", "cookie", "api_key"}
+
+// Secrets that may show up inside messages, ex: an error that includes the token it rejected
+var secretPatterns = []*regexp.Regexp{
+	// Opaque tokens and secrets, see internal/auth/oauth.go
+	regexp.MustCompile(`chirpy_(at|rt|cs|pat|whsec)_[A-Za-z0-9_-]+`),
+	// JWTs
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// Hex-encoded refresh tokens, 32+ bytes
+	regexp.MustCompile(`\b[0-9a-fA-F]{64,}\b`),
+	// Authorization header values
+	regexp.MustCompile(`(?i)\b(Bearer|ApiKey)\s+\S+`),
+}
+
+// JSON logs with secrets redacted
+func NewHandler(w io.Writer, level slog.Leveler) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	})
+}
+
+// Parses "debug", "info", "warn" or "error", empty is "info"
+func ParseLevel(s string) (slog.Level, error) {
+	level := slog.LevelInfo
+	if s == "" {
+		return level, nil
+	}
+
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// Replaces every secret found in the string
+func Redact(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, REDACTED)
+	}
+	return s
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, REDACTED)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, Redact(err.Error()))
+		}
+	}
+
+	return attr
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range sensitiveKeySuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket: a key can make `Burst` requests at once, then `PerMinute` requests a minute
+// PerMinute must be greater than 0
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Tracks a bucket per key (ex: a user ID)
+// The limit is passed on each call, so keys with different limits (ex: on different plans) can share a Limiter
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	ops     int
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// Number of Allow() calls between sweeps of full buckets
+const pruneInterval = 1000
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Takes a token from the key's bucket if there is one
+// Otherwise returns false and how long until the next token
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ops++
+	if l.ops%pruneInterval == 0 {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / float64(limit.PerMinute) * float64(time.Minute)
+	return false, time.Duration(math.Ceil(wait))
+}
+
+// Adds the tokens earned since the last update, up to the burst
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.updated) {
+		b.tokens += now.Sub(b.updated).Minutes() * float64(b.limit.PerMinute)
+		b.updated = now
+	}
+	b.tokens = math.Min(b.tokens, float64(b.limit.Burst))
+}
+
+// Removes full buckets, which behave the same as new ones, caller must hold the lock
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testLimit = Limit{
+	PerMinute: 6,
+	Burst:     3,
+}
+
+func TestAllowBurstThenRefill(t *testing.T) {
+	limiter := New()
+	now := time.Now()
+
+	cases := []struct {
+		name          string
+		elapsed       time.Duration
+		expectedAllow bool
+		expectedWait  time.Duration
+	}{
+		{name: "burst 1", expectedAllow: true},
+		{name: "burst 2", expectedAllow: true},
+		{name: "burst 3", expectedAllow: true},
+		{name: "empty", expectedAllow: false, expectedWait: 10 * time.Second},
+		{name: "partly refilled", elapsed: 4 * time.Second, expectedAllow: false, expectedWait: 6 * time.Second},
+		{name: "refilled one token", elapsed: 6 * time.Second, expectedAllow: true},
+		{name: "empty again", expectedAllow: false, expectedWait: 10 * time.Second},
+		{name: "refill capped at burst", elapsed: time.Hour, expectedAllow: true},
+		{name: "after cap 2", expectedAllow: true},
+		{name: "after cap 3", expectedAllow: true},
+		{name: "after cap empty", expectedAllow: false, expectedWait: 10 * time.Second},
+	}
+
+	for _, c := range cases {
+		now = now.Add(c.elapsed)
+		allowed, wait := limiter.Allow("user", testLimit, now)
+		if allowed != c.expectedAllow || wait != c.expectedWait {
+			t.Error(formatTestError(c.name, fmt.Sprint(allowed, wait), fmt.Sprint(c.expectedAllow, c.expectedWait)))
+		}
+	}
+}
+
+func TestAllowSeparateKeysAndLimits(t *testing.T) {
+	limiter := New()
+	now := time.Now()
+
+	for range testLimit.Burst {
+		limiter.Allow("free user", testLimit, now)
+	}
+
+	allowed, _ := limiter.Allow("free user", testLimit, now)
+	if allowed {
+		t.Error(formatTestError("free user after burst", allowed, false))
+	}
+
+	allowed, _ = limiter.Allow("other user", testLimit, now)
+	if !allowed {
+		t.Error(formatTestError("other user", allowed, true))
+	}
+
+	// A higher limit takes effect immediately, ex: after upgrading
+	higherLimit := Limit{PerMinute: 60, Burst: 10}
+	allowed, _ = limiter.Allow("free user", higherLimit, now.Add(time.Second))
+	if !allowed {
+		t.Error(formatTestError("upgraded user", allowed, true))
+	}
+}
+
+func TestPruneRemovesFullBuckets(t *testing.T) {
+	limiter := New()
+	now := time.Now()
+
+	limiter.Allow("idle", testLimit, now)
+	limiter.Allow("busy", testLimit, now.Add(time.Minute))
+	limiter.Allow("busy", testLimit, now.Add(time.Minute))
+	limiter.Allow("busy", testLimit, now.Add(time.Minute))
+
+	limiter.prune(now.Add(time.Minute))
+
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error(formatTestError("idle bucket pruned", ok, false))
+	}
+	if _, ok := limiter.buckets["busy"]; !ok {
+		t.Error(formatTestError("busy bucket kept", ok, true))
+	}
+}
+
+func formatTestError(testname, actual, expected any) string {
+	return fmt.Sprintf("\nInput:\n\t%v\nActual:\n\t%v\nExpected:\n\t%v", testname, actual, expected)
+}
@@ -0,0 +1,394 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// In-memory storage, for tests that shouldn't need Postgres
+// Follows the schema's defaults and constraints: unique emails, chirps and refresh tokens must belong to a user
+// and are deleted with them (ON DELETE CASCADE)
+type Memory struct {
+	mu            sync.Mutex
+	users         map[uuid.UUID]database.User
+	chirps        map[uuid.UUID]database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	webhookEvents []database.EnqueueWebhookDeliveriesParams
+}
+
+var (
+	_ Users         = (*Memory)(nil)
+	_ Chirps        = (*Memory)(nil)
+	_ RefreshTokens = (*Memory)(nil)
+	_ WebhookOutbox = (*Memory)(nil)
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:         map[uuid.UUID]database.User{},
+		chirps:        map[uuid.UUID]database.Chirp{},
+		refreshTokens: map[string]database.RefreshToken{},
+	}
+}
+
+// Users
+
+func (m *Memory) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.users[arg.ID]; exists {
+		return database.User{}, fmt.Errorf("user %v already exists", arg.ID)
+	}
+	if m.emailTaken(arg.Email, uuid.Nil) {
+		return database.User{}, fmt.Errorf("%w: %v", ErrEmailTaken, arg.Email)
+	}
+
+	user := database.User{
+		ID:             arg.ID,
+		CreatedAt:      arg.CreatedAt,
+		UpdatedAt:      arg.UpdatedAt,
+		Email:          arg.Email,
+		HashedPassword: arg.HashedPassword,
+		Role:           "user",
+	}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	if m.emailTaken(arg.Email, arg.ID) {
+		return database.User{}, fmt.Errorf("%w: %v", ErrEmailTaken, arg.Email)
+	}
+
+	user.Email = arg.Email
+	user.HashedPassword = arg.HashedPassword
+	user.UpdatedAt = arg.UpdatedAt
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) GetUsers(ctx context.Context) ([]database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []database.User{}
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b database.User) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return users, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *Memory) GetUserRole(ctx context.Context, id uuid.UUID) (string, error) {
+	user, err := m.GetUserByID(ctx, id)
+	return user.Role, err
+}
+
+func (m *Memory) SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+
+	user.Role = arg.Role
+	user.UpdatedAt = arg.UpdatedAt
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) CountUsers(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return int64(len(m.users)), nil
+}
+
+func (m *Memory) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := int64(0)
+	for _, user := range m.users {
+		if user.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) RehashUserPassword(ctx context.Context, arg database.RehashUserPasswordParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[arg.ID]
+	if !ok || user.HashedPassword != arg.OldHashedPassword {
+		return 0, nil
+	}
+
+	user.HashedPassword = arg.NewHashedPassword
+	m.users[user.ID] = user
+	return 1, nil
+}
+
+func (m *Memory) DeleteUserByEmail(ctx context.Context, email string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, user := range m.users {
+		if user.Email == email {
+			m.deleteUser(id)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *Memory) DeleteUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range m.users {
+		m.deleteUser(id)
+	}
+	return nil
+}
+
+// Caller holds m.mu
+func (m *Memory) emailTaken(email string, exceptID uuid.UUID) bool {
+	for id, user := range m.users {
+		if user.Email == email && id != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// Caller holds m.mu
+func (m *Memory) deleteUser(id uuid.UUID) {
+	delete(m.users, id)
+	for chirpID, chirp := range m.chirps {
+		if chirp.UserID == id {
+			delete(m.chirps, chirpID)
+		}
+	}
+	for token, refreshToken := range m.refreshTokens {
+		if refreshToken.UserID == id {
+			delete(m.refreshTokens, token)
+		}
+	}
+}
+
+// Chirps
+
+func (m *Memory) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Chirp{}, fmt.Errorf("chirp author %v doesn't exist", arg.UserID)
+	}
+
+	chirp := database.Chirp(arg)
+	m.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (m *Memory) GetChirps(ctx context.Context) ([]database.Chirp, error) {
+	return m.filterChirps(func(chirp database.Chirp) bool { return true }), nil
+}
+
+func (m *Memory) GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error) {
+	return m.filterChirps(func(chirp database.Chirp) bool { return chirp.UserID == userID }), nil
+}
+
+func (m *Memory) GetChirpByID(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+func (m *Memory) UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[arg.ID]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	chirp.Body = arg.Body
+	chirp.UpdatedAt = arg.UpdatedAt
+	m.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (m *Memory) DeleteChirpByID(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+
+	delete(m.chirps, id)
+	return chirp, nil
+}
+
+// Oldest first, like the queries' ORDER BY created_at ASC
+func (m *Memory) filterChirps(keep func(database.Chirp) bool) []database.Chirp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chirps := []database.Chirp{}
+	for _, chirp := range m.chirps {
+		if keep(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b database.Chirp) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return chirps
+}
+
+// Refresh tokens
+
+func (m *Memory) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("refresh token user %v doesn't exist", arg.UserID)
+	}
+	if _, exists := m.refreshTokens[arg.Token]; exists {
+		return fmt.Errorf("refresh token already exists")
+	}
+
+	m.refreshTokens[arg.Token] = database.RefreshToken{
+		Token:     arg.Token,
+		CreatedAt: arg.CreatedAt,
+		UpdatedAt: arg.UpdatedAt,
+		UserID:    arg.UserID,
+		ExpiresAt: arg.ExpiresAt,
+	}
+	return nil
+}
+
+func (m *Memory) GetRefreshTokenInfo(ctx context.Context, token string) (database.GetRefreshTokenInfoRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[token]
+	if !ok {
+		return database.GetRefreshTokenInfoRow{}, sql.ErrNoRows
+	}
+
+	return database.GetRefreshTokenInfoRow{
+		Token:     refreshToken.Token,
+		UserID:    refreshToken.UserID,
+		CreatedAt: refreshToken.CreatedAt,
+		UpdatedAt: refreshToken.UpdatedAt,
+		ExpiresAt: refreshToken.ExpiresAt,
+		RevokedAt: refreshToken.RevokedAt,
+	}, nil
+}
+
+func (m *Memory) SetRefreshTokenRevokeAtTime(ctx context.Context, arg database.SetRefreshTokenRevokeAtTimeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	refreshToken, ok := m.refreshTokens[arg.Token]
+	if !ok {
+		return nil
+	}
+
+	refreshToken.RevokedAt = arg.RevokedAt
+	refreshToken.UpdatedAt = arg.UpdatedAt
+	m.refreshTokens[arg.Token] = refreshToken
+	return nil
+}
+
+func (m *Memory) RevokeAllRefreshTokens(ctx context.Context, revokedAt sql.NullTime) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := int64(0)
+	for token, refreshToken := range m.refreshTokens {
+		if refreshToken.RevokedAt.Valid {
+			continue
+		}
+
+		refreshToken.RevokedAt = revokedAt
+		refreshToken.UpdatedAt = revokedAt.Time
+		m.refreshTokens[token] = refreshToken
+		count++
+	}
+	return count, nil
+}
+
+// Webhooks
+
+// Records the event, there are no webhook endpoints to deliver it to
+func (m *Memory) EnqueueWebhookDeliveries(ctx context.Context, arg database.EnqueueWebhookDeliveriesParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.webhookEvents = append(m.webhookEvents, arg)
+	return 0, nil
+}
+
+// Every event passed to EnqueueWebhookDeliveries, oldest first
+func (m *Memory) WebhookEvents() []database.EnqueueWebhookDeliveriesParams {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.webhookEvents)
+}
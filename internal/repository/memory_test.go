@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestMemoryUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	user, err := m.CreateUser(ctx, newUserParams("user@email.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		actual   func() error
+		expected error
+	}{
+		{
+			name: "Duplicate email",
+			actual: func() error {
+				_, err := m.CreateUser(ctx, newUserParams("user@email.com"))
+				return err
+			},
+			expected: fmt.Errorf("%w: user@email.com", ErrEmailTaken),
+		},
+		{
+			name: "Unknown email",
+			actual: func() error {
+				_, err := m.GetUserByEmail(ctx, "missing@email.com")
+				return err
+			},
+			expected: sql.ErrNoRows,
+		},
+		{
+			name: "Unknown ID",
+			actual: func() error {
+				_, err := m.GetUserByID(ctx, uuid.New())
+				return err
+			},
+			expected: sql.ErrNoRows,
+		},
+		{
+			name: "Existing user",
+			actual: func() error {
+				_, err := m.GetUserByID(ctx, user.ID)
+				return err
+			},
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		actual := fmt.Sprint(c.actual())
+		if actual != fmt.Sprint(c.expected) {
+			t.Error(formatTestError(c.name, actual, c.expected))
+		}
+	}
+
+	// New users default to the "user" role, like the schema
+	if user.Role != "user" {
+		t.Error(formatTestError("Default role", user.Role, "user"))
+	}
+}
+
+func TestMemoryDeleteUserCascades(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	user, err := m.CreateUser(ctx, newUserParams("user@email.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chirp, err := m.CreateChirp(ctx, database.CreateChirpParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Body:      "Hello, world!",
+		UserID:    user.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     "refresh-token",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chirps need an existing author
+	_, err = m.CreateChirp(ctx, database.CreateChirpParams{ID: uuid.New(), UserID: uuid.New()})
+	if err == nil {
+		t.Error(formatTestError("Chirp without author", err, "error"))
+	}
+
+	deleted, err := m.DeleteUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Error(formatTestError("Deleted users", deleted, 1))
+	}
+
+	if _, err := m.GetChirpByID(ctx, chirp.ID); err != sql.ErrNoRows {
+		t.Error(formatTestError("Chirp after user deleted", err, sql.ErrNoRows))
+	}
+	if _, err := m.GetRefreshTokenInfo(ctx, "refresh-token"); err != sql.ErrNoRows {
+		t.Error(formatTestError("Refresh token after user deleted", err, sql.ErrNoRows))
+	}
+}
+
+func TestMemoryGetChirpsOldestFirst(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	user, err := m.CreateUser(ctx, newUserParams("user@email.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	bodies := []string{"third", "first", "second"}
+	offsets := []time.Duration{2 * time.Second, 0, time.Second}
+	for i, body := range bodies {
+		_, err := m.CreateChirp(ctx, database.CreateChirpParams{
+			ID:        uuid.New(),
+			CreatedAt: now.Add(offsets[i]),
+			UpdatedAt: now.Add(offsets[i]),
+			Body:      body,
+			UserID:    user.ID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chirps, err := m.GetChirps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first", "second", "third"}
+	for i, chirp := range chirps {
+		if chirp.Body != expected[i] {
+			t.Error(formatTestError(fmt.Sprintf("Chirp %v", i), chirp.Body, expected[i]))
+		}
+	}
+}
+
+func newUserParams(email string) database.CreateUserParams {
+	return database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		Email:          email,
+		HashedPassword: "hashed-password",
+	}
+}
+
+func formatTestError(testname, actual, expected any) string {
+	return fmt.Sprintf("\nInput:\n\t%v\nActual:\n\t%v\nExpected:\n\t%v", testname, actual, expected)
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Storage for users, chirps and refresh tokens, so handlers can run against Postgres or in memory (see Memory)
+// The methods match the sqlc queries, *database.Queries is the Postgres implementation
+// Lookups that find nothing return sql.ErrNoRows, like Postgres
+
+// Returned by Memory when a user is created or updated with another user's email
+// Postgres returns a unique violation (*pq.Error, code 23505) instead
+var ErrEmailTaken = errors.New("email already taken")
+
+type Users interface {
+	CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error)
+	UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error)
+	GetUsers(ctx context.Context) ([]database.User, error)
+	GetUserByEmail(ctx context.Context, email string) (database.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error)
+	GetUserRole(ctx context.Context, id uuid.UUID) (string, error)
+	SetUserRole(ctx context.Context, arg database.SetUserRoleParams) (database.User, error)
+	CountUsers(ctx context.Context) (int64, error)
+	CountUsersWithRole(ctx context.Context, role string) (int64, error)
+	RehashUserPassword(ctx context.Context, arg database.RehashUserPasswordParams) (int64, error)
+
+	// Deleting users also deletes their chirps, refresh tokens, etc.
+	DeleteUserByEmail(ctx context.Context, email string) (int64, error)
+	DeleteUsers(ctx context.Context) error
+}
+
+type Chirps interface {
+	CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error)
+	GetChirps(ctx context.Context) ([]database.Chirp, error) // Oldest first
+	GetChirpsByUserID(ctx context.Context, userID uuid.UUID) ([]database.Chirp, error)
+	GetChirpByID(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+	UpdateChirp(ctx context.Context, arg database.UpdateChirpParams) (database.Chirp, error)
+	DeleteChirpByID(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+}
+
+type RefreshTokens interface {
+	CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) error
+	GetRefreshTokenInfo(ctx context.Context, token string) (database.GetRefreshTokenInfoRow, error)
+	SetRefreshTokenRevokeAtTime(ctx context.Context, arg database.SetRefreshTokenRevokeAtTimeParams) error
+	RevokeAllRefreshTokens(ctx context.Context, revokedAt sql.NullTime) (int64, error)
+}
+
+// Queues outbound webhook events, returns how many deliveries were queued (one per subscribed endpoint)
+type WebhookOutbox interface {
+	EnqueueWebhookDeliveries(ctx context.Context, arg database.EnqueueWebhookDeliveriesParams) (int64, error)
+}
+
+var (
+	_ Users         = (*database.Queries)(nil)
+	_ Chirps        = (*database.Queries)(nil)
+	_ RefreshTokens = (*database.Queries)(nil)
+	_ WebhookOutbox = (*database.Queries)(nil)
+)
@@ -0,0 +1,162 @@
+package throttle
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Tracks failed attempts per key (ex: an email or IP address) and applies exponential backoff
+// After `FreeAttempts` failures, each further failure locks the key for BaseDelay * 2^(n - FreeAttempts), up to MaxDelay
+// Keys are forgotten `ResetAfter` their last failure
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	ResetAfter   time.Duration
+}
+
+type Throttler struct {
+	policy  Policy
+	mu      sync.Mutex
+	entries map[string]*entry
+	ops     int
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Number of Fail() calls between sweeps of expired keys
+const pruneInterval = 1000
+
+func New(policy Policy) *Throttler {
+	return &Throttler{
+		policy:  policy,
+		entries: map[string]*entry{},
+	}
+}
+
+// Returns how long the key must wait before its next attempt, 0 if it can try now
+func (t *Throttler) RetryAfter(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || t.expired(e, now) {
+		return 0
+	}
+
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+
+	return 0
+}
+
+// Records a failed attempt, returns how long the key is now locked for (0 if still within the free attempts)
+func (t *Throttler) Fail(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.fail(key, now)
+}
+
+// Caller must hold the lock
+func (t *Throttler) fail(key string, now time.Time) time.Duration {
+	t.ops++
+	if t.ops%pruneInterval == 0 {
+		t.prune(now)
+	}
+
+	e, ok := t.entries[key]
+	if !ok || t.expired(e, now) {
+		e = &entry{}
+		t.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	delay := t.delay(e.failures)
+	e.lockedUntil = now.Add(delay)
+
+	return delay
+}
+
+// Counts an attempt as failed before it's made, so concurrent attempts can't all pass RetryAfter before any of them fails
+// Returns how long the key must wait if it's locked, nothing is counted then
+// Otherwise `release` must be called with the attempt's outcome, a success takes the attempt back and a failure keeps it
+func (t *Throttler) Reserve(key string, now time.Time) (retryAfter time.Duration, release func(success bool)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if ok && !t.expired(e, now) && now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now), func(bool) {}
+	}
+
+	t.fail(key, now)
+	e = t.entries[key]
+
+	var once sync.Once
+	return 0, func(success bool) {
+		once.Do(func() {
+			if success {
+				t.cancel(key, e)
+			}
+		})
+	}
+}
+
+// Takes back a reserved attempt, unless the key was reset or forgotten since
+func (t *Throttler) cancel(key string, e *entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries[key] != e || e.failures == 0 {
+		return
+	}
+
+	e.failures--
+	e.lockedUntil = e.lastFailure.Add(t.delay(e.failures))
+}
+
+// Clears the key's failures, ex: after a successful login
+func (t *Throttler) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+// Lock duration after the given number of consecutive failures
+func (t *Throttler) delay(failures int) time.Duration {
+	if failures <= t.policy.FreeAttempts {
+		return 0
+	}
+
+	exponent := failures - t.policy.FreeAttempts - 1
+	delay := float64(t.policy.BaseDelay) * math.Pow(2, float64(exponent))
+	if delay > float64(t.policy.MaxDelay) {
+		return t.policy.MaxDelay
+	}
+
+	return time.Duration(delay)
+}
+
+// Entries are kept until they're unlocked and haven't failed for `ResetAfter`
+func (t *Throttler) expired(e *entry, now time.Time) bool {
+	return now.After(e.lockedUntil) && now.Sub(e.lastFailure) > t.policy.ResetAfter
+}
+
+// Removes expired entries so the map doesn't grow forever, caller must hold the lock
+func (t *Throttler) prune(now time.Time) {
+	for key, e := range t.entries {
+		if t.expired(e, now) {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package throttle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	ResetAfter:   time.Hour,
+}
+
+func TestFailAppliesExponentialBackoff(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	expectedDelays := []time.Duration{
+		0, 0, 0, // Free attempts
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second, // Capped at MaxDelay
+		10 * time.Second,
+	}
+
+	for i, expected := range expectedDelays {
+		actual := throttler.Fail("user@email.com", now)
+		if actual != expected {
+			t.Error(formatTestError(fmt.Sprintf("failure %v", i+1), actual, expected))
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	for range testPolicy.FreeAttempts {
+		throttler.Fail("user@email.com", now)
+	}
+
+	// Still allowed after the free attempts
+	if actual := throttler.RetryAfter("user@email.com", now); actual != 0 {
+		t.Error(formatTestError("after free attempts", actual, 0))
+	}
+
+	// Locked after the next failure
+	throttler.Fail("user@email.com", now)
+
+	if actual := throttler.RetryAfter("user@email.com", now); actual != time.Second {
+		t.Error(formatTestError("locked", actual, time.Second))
+	}
+
+	// Other keys aren't affected
+	if actual := throttler.RetryAfter("other@email.com", now); actual != 0 {
+		t.Error(formatTestError("other key", actual, 0))
+	}
+
+	// Unlocked once the delay passes
+	if actual := throttler.RetryAfter("user@email.com", now.Add(2*time.Second)); actual != 0 {
+		t.Error(formatTestError("after delay", actual, 0))
+	}
+}
+
+func TestResetClearsFailures(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	for range testPolicy.FreeAttempts + 2 {
+		throttler.Fail("user@email.com", now)
+	}
+
+	throttler.Reset("user@email.com")
+
+	if actual := throttler.RetryAfter("user@email.com", now); actual != 0 {
+		t.Error(formatTestError("after reset", actual, 0))
+	}
+
+	// Back to the free attempts
+	if actual := throttler.Fail("user@email.com", now); actual != 0 {
+		t.Error(formatTestError("first failure after reset", actual, 0))
+	}
+}
+
+func TestFailuresForgottenAfterResetAfter(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	for range testPolicy.FreeAttempts {
+		throttler.Fail("user@email.com", now)
+	}
+
+	// Next failure is much later, so it counts as the first
+	later := now.Add(testPolicy.ResetAfter + time.Minute)
+	if actual := throttler.Fail("user@email.com", later); actual != 0 {
+		t.Error(formatTestError("failure after ResetAfter", actual, 0))
+	}
+}
+
+func TestReserve(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	// Successful attempts are taken back
+	for range testPolicy.FreeAttempts + 2 {
+		retryAfter, release := throttler.Reserve("user@email.com", now)
+		if retryAfter != 0 {
+			t.Error(formatTestError("successful attempt", retryAfter, 0))
+		}
+		release(true)
+	}
+
+	// Failed attempts are kept, the one after the free attempts starts the backoff
+	for i := range testPolicy.FreeAttempts + 1 {
+		retryAfter, release := throttler.Reserve("user@email.com", now)
+		if retryAfter != 0 {
+			t.Error(formatTestError(fmt.Sprintf("failed attempt %v", i+1), retryAfter, 0))
+		}
+		release(false)
+	}
+
+	// Locked, nothing reserved
+	retryAfter, release := throttler.Reserve("user@email.com", now)
+	if retryAfter != time.Second {
+		t.Error(formatTestError("locked", retryAfter, time.Second))
+	}
+	release(true)
+
+	if actual := throttler.RetryAfter("user@email.com", now); actual != time.Second {
+		t.Error(formatTestError("after locked reservation", actual, time.Second))
+	}
+}
+
+func TestReserveCountsUnreleasedAttempts(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	// None of the reservations are released, like slow attempts that are still running
+	allowed := 0
+	for range 10 {
+		retryAfter, _ := throttler.Reserve("user@email.com", now)
+		if retryAfter == 0 {
+			allowed++
+		}
+	}
+
+	// The free attempts, and the one that starts the backoff
+	if allowed != testPolicy.FreeAttempts+1 {
+		t.Error(formatTestError("allowed attempts", allowed, testPolicy.FreeAttempts+1))
+	}
+}
+
+func TestReleaseAfterReset(t *testing.T) {
+	throttler := New(testPolicy)
+	now := time.Now()
+
+	_, release := throttler.Reserve("user@email.com", now)
+	throttler.Reset("user@email.com")
+	for range testPolicy.FreeAttempts + 1 {
+		throttler.Fail("user@email.com", now)
+	}
+
+	// Doesn't take back a failure recorded after the reset
+	release(true)
+	if actual := throttler.RetryAfter("user@email.com", now); actual != time.Second {
+		t.Error(formatTestError("release after reset", actual, time.Second))
+	}
+}
+
+func formatTestError(testname, actual, expected any) string {
+	return fmt.Sprintf("\nInput:\n\t%v\nActual:\n\t%v\nExpected:\n\t%v", testname, actual, expected)
+}
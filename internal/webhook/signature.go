@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed webhooks carry a header like `t=1700000000,v1=5257a869...`
+// v1 is the hex HMAC-SHA256 of "<t>.<raw body>", keyed with a shared secret
+// The sender may include several v1 values (one per active secret) while secrets are rotated
+const SIGNATURE_VERSION = "v1"
+
+// Largest allowed difference between the signed timestamp and the receiver's clock
+const DEFAULT_TOLERANCE = 5 * time.Minute
+
+var (
+	ErrMissingSignature  = errors.New("missing webhook signature")
+	ErrMalformedHeader   = errors.New("malformed webhook signature header")
+	ErrStaleTimestamp    = errors.New("webhook timestamp outside of tolerance")
+	ErrSignatureMismatch = errors.New("no webhook signature matches")
+)
+
+// Returns the signature header value for the body, signed with each secret
+func Sign(body []byte, timestamp time.Time, secrets ...string) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	parts := []string{"t=" + t}
+	for _, secret := range secrets {
+		parts = append(parts, SIGNATURE_VERSION+"="+hex.EncodeToString(computeSignature(secret, t, body)))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Returns nil if the header was signed within `tolerance` of `now` by any of the secrets
+func Verify(header string, body []byte, secrets []string, tolerance time.Duration, now time.Time) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	t, signatures, err := parseHeader(header)
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: timestamp %q", ErrMalformedHeader, t)
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	// Check every pair, so the time taken doesn't reveal which secret (if any) matched
+	matched := false
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := computeSignature(secret, t, body)
+		for _, signature := range signatures {
+			if hmac.Equal(expected, signature) {
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return ErrSignatureMismatch
+	}
+
+	return nil
+}
+
+// Returns the timestamp and decoded signatures of the current version, unknown versions are ignored
+func parseHeader(header string) (string, [][]byte, error) {
+	t := ""
+	signatures := [][]byte{}
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return "", nil, ErrMalformedHeader
+		}
+
+		switch key {
+		case "t":
+			t = value
+		case SIGNATURE_VERSION:
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("%w: %v", ErrMalformedHeader, err)
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if t == "" || len(signatures) == 0 {
+		return "", nil, ErrMalformedHeader
+	}
+
+	return t, signatures, nil
+}
+
+func computeSignature(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
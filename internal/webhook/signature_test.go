@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id": "evt_1", "event": "user.upgraded"}`)
+	now := time.Unix(1700000000, 0)
+
+	cases := []struct {
+		name     string
+		header   string
+		body     []byte
+		secrets  []string
+		expected error
+	}{
+		{
+			name:     "Valid signature",
+			header:   Sign(body, now, "secret"),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: nil,
+		},
+		{
+			name:     "Signed with the old secret during rotation",
+			header:   Sign(body, now, "old-secret"),
+			body:     body,
+			secrets:  []string{"new-secret", "old-secret"},
+			expected: nil,
+		},
+		{
+			name:     "Signed with both secrets during rotation",
+			header:   Sign(body, now, "new-secret", "old-secret"),
+			body:     body,
+			secrets:  []string{"new-secret"},
+			expected: nil,
+		},
+		{
+			name:     "Slight clock skew",
+			header:   Sign(body, now.Add(time.Minute), "secret"),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: nil,
+		},
+		{
+			name:     "Unknown signature versions ignored",
+			header:   Sign(body, now, "secret") + ",v0=abc",
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: nil,
+		},
+		{
+			name:     "Wrong secret",
+			header:   Sign(body, now, "wrong-secret"),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrSignatureMismatch,
+		},
+		{
+			name:     "Modified body",
+			header:   Sign(body, now, "secret"),
+			body:     []byte(`{"id": "evt_1", "event": "user.downgraded"}`),
+			secrets:  []string{"secret"},
+			expected: ErrSignatureMismatch,
+		},
+		{
+			name:     "Modified timestamp",
+			header:   strings.Replace(Sign(body, now, "secret"), "t=1700000000", "t=1700000001", 1),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrSignatureMismatch,
+		},
+		{
+			name:     "Empty secret never matches",
+			header:   Sign(body, now, ""),
+			body:     body,
+			secrets:  []string{""},
+			expected: ErrSignatureMismatch,
+		},
+		{
+			name:     "Stale timestamp",
+			header:   Sign(body, now.Add(-10*time.Minute), "secret"),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrStaleTimestamp,
+		},
+		{
+			name:     "Timestamp in the future",
+			header:   Sign(body, now.Add(10*time.Minute), "secret"),
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrStaleTimestamp,
+		},
+		{
+			name:     "Missing header",
+			header:   "",
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrMissingSignature,
+		},
+		{
+			name:     "Missing timestamp",
+			header:   "v1=abcd",
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrMalformedHeader,
+		},
+		{
+			name:     "Missing signature",
+			header:   "t=1700000000",
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrMalformedHeader,
+		},
+		{
+			name:     "Signature not hex",
+			header:   "t=1700000000,v1=xyz",
+			body:     body,
+			secrets:  []string{"secret"},
+			expected: ErrMalformedHeader,
+		},
+	}
+
+	for _, c := range cases {
+		actual := Verify(c.header, c.body, c.secrets, DEFAULT_TOLERANCE, now)
+		if !errors.Is(actual, c.expected) {
+			t.Error(formatTestError(c.name, actual, c.expected))
+		}
+	}
+}
+
+func formatTestError(testname, actual, expected any) string {
+	return fmt.Sprintf("\nInput:\n\t%v\nActual:\n\t%v\nExpected:\n\t%v", testname, actual, expected)
+}
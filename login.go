@@ -1,7 +1,9 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -18,6 +20,13 @@ type LoginResponse struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
+}
+
+// Returned by POST /api/login instead of LoginResponse when the user has two-factor authentication enabled
+type MFAChallengeResponse struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
 }
 
 func (cfg *apiConfig) handlerLogin() http.HandlerFunc {
@@ -27,85 +36,146 @@ func (cfg *apiConfig) handlerLogin() http.HandlerFunc {
 			Email    string `json:"email"`
 		}{}
 
-		// Decode request
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&req)
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
 
 		// Validate required fields
 		if req.Email == "" {
-			sendErrorJSONResponse(w, "Email must not be blank", http.StatusBadRequest, nil)
+			sendValidationErrorResponse(w, FieldError{Field: "email", Code: FIELD_REQUIRED, Message: "Email must not be blank"})
 			return
 		}
 
 		if req.Password == "" {
-			sendErrorJSONResponse(w, "Password required", http.StatusBadRequest, nil)
+			sendValidationErrorResponse(w, FieldError{Field: "password", Code: FIELD_REQUIRED, Message: "Password required"})
 			return
 		}
 
-		// Check user password
-		user, err := cfg.db.GetUserByEmail(r.Context(), req.Email)
-		if err != nil {
-			sendErrorJSONResponse(w, "incorrect email or password", http.StatusUnauthorized, err)
+		// Reject while the account or client IP is backing off from failed attempts
+		// Otherwise the attempt counts as failed until the password is correct
+		accountKey := accountThrottleKey(req.Email)
+		retryAfter, releaseAttempt := cfg.reserveLoginAttempt(r, accountKey)
+		if retryAfter > 0 {
+			recordLoginMetric(LOGIN_METHOD_PASSWORD, LOGIN_THROTTLED)
+			sendTooManyRequestsResponse(w, "Too many failed login attempts, try again later", retryAfter, fmt.Errorf("login throttled for %v from %v", accountKey, clientIP(r)))
 			return
 		}
 
-		err = auth.CheckPasswordHash(req.Password, user.HashedPassword)
-		if err != nil {
+		// Check user password
+		user, err := cfg.users.GetUserByEmail(r.Context(), req.Email)
+		if err == sql.ErrNoRows {
+			// Take as long as a real password check, so the response time doesn't reveal the email isn't registered
+			cfg.passwordHasher.SimulateCheck(req.Password)
+			recordLoginMetric(LOGIN_METHOD_PASSWORD, LOGIN_FAILURE)
 			sendErrorJSONResponse(w, "incorrect email or password", http.StatusUnauthorized, err)
 			return
 		}
-
-		// Check JWT hasn't expired
-		tokenDuration, err := time.ParseDuration(auth.JWT_TOKEN_DURATION)
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
-		token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, tokenDuration)
+		err = cfg.passwordHasher.Check(req.Password, user.HashedPassword)
 		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			recordLoginMetric(LOGIN_METHOD_PASSWORD, LOGIN_FAILURE)
+			sendErrorJSONResponse(w, "incorrect email or password", http.StatusUnauthorized, err)
 			return
 		}
 
-		// Create 60 day refresh token, save to database
-		refreshTokenDuration, err := time.ParseDuration(auth.REFRESH_TOKEN_DURATION)
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
-			return
+		// Upgrade legacy bcrypt hashes, or hashes made with old settings, while the plaintext password is available
+		if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+			cfg.rehashPassword(r.Context(), user, req.Password)
 		}
 
-		refreshToken, err := auth.MakeRefreshToken()
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		// Users with two-factor authentication must also pass the TOTP check at POST /api/login/2fa
+		if user.TotpEnabled {
+			releaseAttempt(true)
+			recordLoginMetric(LOGIN_METHOD_PASSWORD, LOGIN_MFA_REQUIRED)
+			cfg.sendMFAChallengeResponse(w, user.ID)
 			return
 		}
 
-		err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-			Token:     refreshToken,
-			UserID:    user.ID,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-			ExpiresAt: time.Now().Add(refreshTokenDuration),
-		})
+		// Failures are only cleared once the login is complete, otherwise a correct password would reset the 2FA code guesses
+		releaseAttempt(true)
+		cfg.recordLoginSuccess(accountKey)
+		recordLoginMetric(LOGIN_METHOD_PASSWORD, LOGIN_SUCCESS)
+
+		// Response
+		loginResponse, err := cfg.createLoginResponse(r.Context(), user)
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
-		// Response
-		SendJSONResponse(w, 200, LoginResponse{
-			ID:           user.ID,
-			Email:        user.Email,
-			CreatedAt:    user.CreatedAt,
-			UpdatedAt:    user.UpdatedAt,
-			IsChirpyRed:  user.IsChirpyRed,
-			Token:        token,
-			RefreshToken: refreshToken,
-		})
+		SendJSONResponse(w, 200, loginResponse)
+	}
+}
+
+// Saves a new hash of the user's password with the current hasher settings
+// Failures are only logged, the login itself already succeeded
+func (cfg *apiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+	newHashedPassword, err := cfg.passwordHasher.Hash(password)
+	if err != nil {
+		requestLogger(ctx).Error("Failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	// Only replaces the hash that was just verified, in case the password changed in the meantime
+	_, err = cfg.users.RehashUserPassword(ctx, database.RehashUserPasswordParams{
+		ID:                user.ID,
+		OldHashedPassword: user.HashedPassword,
+		NewHashedPassword: newHashedPassword,
+	})
+	if err != nil {
+		requestLogger(ctx).Error("Failed to save rehashed password", "user_id", user.ID, "error", err)
+	}
+}
+
+// Creates the JWT and refresh token for the user, who has already been authenticated
+func (cfg *apiConfig) createLoginResponse(ctx context.Context, user database.User) (LoginResponse, error) {
+	setRequestUserID(ctx, user.ID)
+
+	// Check JWT hasn't expired
+	tokenDuration, err := time.ParseDuration(auth.JWT_TOKEN_DURATION)
+	if err != nil {
+		return LoginResponse{}, err
 	}
+
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, tokenDuration)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	// Create 60 day refresh token, save to database
+	refreshTokenDuration, err := time.ParseDuration(auth.REFRESH_TOKEN_DURATION)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	err = cfg.refreshTokens.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		Token:     refreshToken,
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(refreshTokenDuration),
+	})
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{
+		ID:           user.ID,
+		Email:        user.Email,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		IsChirpyRed:  user.IsChirpyRed,
+		Role:         user.Role,
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
 }
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/LamontBanks/Chirpy/internal/database"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestLogin(t *testing.T) {
-	setup()
-	defer tearDown()
+	t.Parallel()
 
-	cfg := initApiConfig()
+	cfg := newMemoryApiConfig()
 
 	// Create single user
 	users, passwords, err := createTestUsers(cfg, 1)
@@ -85,3 +90,139 @@ func loginUser(cfg *apiConfig, email, password string) (LoginResponse, error) {
 
 	return *loggedInUser, nil
 }
+
+func TestLoginThrottling(t *testing.T) {
+	t.Parallel()
+
+	cfg := newMemoryApiConfig()
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	cases := []struct {
+		name  string
+		email string
+	}{
+		{
+			name:  "Registered email",
+			email: users[0].Email,
+		},
+		{
+			name:  "Unregistered email",
+			email: "not_registered@email.com",
+		},
+	}
+
+	for _, c := range cases {
+		// Failures up to, and including, the one that starts the lockout return 401
+		for i := range accountLoginPolicy.FreeAttempts + 1 {
+			w := attemptLogin(cfg, c.email, "wrong_password", "192.0.2.1:1234")
+			assertEquals(w.Result().StatusCode, http.StatusUnauthorized, fmt.Sprintf("%v: attempt %v", c.name, i+1), t)
+		}
+
+		// Locked, even with the correct password
+		w := attemptLogin(cfg, c.email, passwords[0], "192.0.2.1:1234")
+		assertEquals(w.Result().StatusCode, http.StatusTooManyRequests, c.name+": locked", t)
+
+		if w.Result().Header.Get("Retry-After") == "" {
+			t.Error(formatTestError(c.name, w.Result().Header, "Retry-After header"))
+		}
+	}
+
+	// Other accounts from a different IP aren't affected
+	cfg.accountThrottle.Reset(accountThrottleKey(users[0].Email))
+	w := attemptLogin(cfg, users[0].Email, passwords[0], "198.51.100.1:1234")
+	assertEquals(w.Result().StatusCode, http.StatusOK, "after reset", t)
+}
+
+func TestConcurrentLoginThrottling(t *testing.T) {
+	t.Parallel()
+
+	cfg := newMemoryApiConfig()
+
+	users, _, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Every guess is sent before any of them fails
+	attempts := accountLoginPolicy.FreeAttempts * 4
+	statusCodes := make(chan int, attempts)
+	wg := sync.WaitGroup{}
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := attemptLogin(cfg, users[0].Email, "wrong_password", "192.0.2.1:1234")
+			statusCodes <- w.Result().StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statusCodes)
+
+	// Only the guesses that got past the throttle reach the password hasher and return 401
+	// Same as one at a time: the free attempts, and the one that starts the lockout
+	checked := 0
+	for statusCode := range statusCodes {
+		if statusCode == http.StatusUnauthorized {
+			checked++
+		}
+	}
+	assertEquals(checked, accountLoginPolicy.FreeAttempts+1, "password checks", t)
+}
+
+func attemptLogin(cfg *apiConfig, email, password, remoteAddr string) *httptest.ResponseRecorder {
+	loginUserBody := fmt.Sprintf(`{"email": "%v","password": "%v"}`, email, password)
+	request := httptest.NewRequest("POST", "/api/login", strings.NewReader(loginUserBody))
+	request.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+
+	cfg.handlerLogin()(w, request)
+
+	return w
+}
+
+func TestLoginRehashesLegacyPassword(t *testing.T) {
+	t.Parallel()
+
+	cfg := newMemoryApiConfig()
+
+	// User saved before argon2id, with a bcrypt hash
+	password := "legacy-bcrypt-password"
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	user, err := cfg.users.CreateUser(context.Background(), database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		Email:          "legacy@email.com",
+		HashedPassword: string(legacyHash),
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	w := attemptLogin(cfg, user.Email, password, "192.0.2.1:1234")
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login with bcrypt hash", t)
+
+	// Hash upgraded to the configured algorithm, password still works
+	rehashedUser, err := cfg.users.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	assertEquals(cfg.passwordHasher.NeedsRehash(rehashedUser.HashedPassword), false, rehashedUser.HashedPassword, t)
+
+	w = attemptLogin(cfg, user.Email, password, "192.0.2.1:1234")
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login with rehashed password", t)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/ratelimit"
+	"github.com/LamontBanks/Chirpy/internal/throttle"
+)
+
+// Failed login backoff
+// Per-account: slows down guessing a single user's password
+// Per-IP: slows down one client guessing across many accounts, more lenient since IPs can be shared (NAT, offices, etc.)
+// A lockout is the backoff reaching MaxDelay
+var (
+	accountLoginPolicy = throttle.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+
+	ipLoginPolicy = throttle.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+
+	// Passkey logins started per client IP, each one saves a session until it's finished or expires
+	passkeyLoginLimit = ratelimit.Limit{PerMinute: 10, Burst: 20}
+)
+
+func accountThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// Reserves a login attempt for both the account and the client IP before the password or code is checked, see throttle.Throttler.Reserve
+// Returns how long the client must wait if either is locked
+// Otherwise the attempt counts as failed until `release(true)` is called, so concurrent guesses can't all get past the backoff
+func (cfg *apiConfig) reserveLoginAttempt(r *http.Request, accountKey string) (time.Duration, func(success bool)) {
+	now := time.Now()
+
+	retryAfter, releaseAccount := cfg.accountThrottle.Reserve(accountKey, now)
+	if retryAfter > 0 {
+		return retryAfter, releaseAccount
+	}
+
+	retryAfter, releaseIP := cfg.ipThrottle.Reserve(ipThrottleKey(r), now)
+	if retryAfter > 0 {
+		releaseAccount(true)
+		return retryAfter, releaseIP
+	}
+
+	return 0, func(success bool) {
+		releaseAccount(success)
+		releaseIP(success)
+	}
+}
+
+// Returns how long the client must wait before starting another passkey login, 0 if allowed
+// IPs locked out by failed logins must also wait
+func (cfg *apiConfig) passkeyLoginRetryAfter(r *http.Request) time.Duration {
+	now := time.Now()
+	_, wait := cfg.ipRateLimiter.Allow("passkey-login:"+clientIP(r), passkeyLoginLimit, now)
+	return max(wait, cfg.ipThrottle.RetryAfter(ipThrottleKey(r), now))
+}
+
+// Clears the account's failures after a successful login
+// The IP's failures are kept - otherwise an attacker could reset them by logging in to their own account
+func (cfg *apiConfig) recordLoginSuccess(accountKey string) {
+	cfg.accountThrottle.Reset(accountKey)
+}
+
+// 429 response with the `Retry-After` header, in whole seconds
+func sendTooManyRequestsResponse(w http.ResponseWriter, msg string, retryAfter time.Duration, errorToLog error) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	sendErrorJSONResponse(w, msg, http.StatusTooManyRequests, errorToLog)
+}
+
+// Returns the IP address of the client
+// `X-Forwarded-For` is ignored since any client can set it, Chirpy is expected to be reached directly
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
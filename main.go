@@ -1,38 +1,190 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/config"
 	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/LamontBanks/Chirpy/internal/logging"
+	"github.com/LamontBanks/Chirpy/internal/ratelimit"
+	"github.com/LamontBanks/Chirpy/internal/repository"
+	"github.com/LamontBanks/Chirpy/internal/throttle"
 
-	"github.com/joho/godotenv"
+	"github.com/go-webauthn/webauthn/webauthn"
 
 	_ "github.com/lib/pq"
 )
 
 // Store stateful data between API calls
 type apiConfig struct {
-	fileServerHits atomic.Int32
-	db             *database.Queries
-	platform       string
-	jwtSecret      string
-	polkaAPIKey    string
+	fileServerHits      atomic.Int32
+	db                  *database.Queries
+	sqlDB               *sql.DB // The connection pool behind db
+	platform            string
+	jwtSecret           string
+	totpEncryptionKey   []byte   // Encrypts TOTP secrets at rest, see two_factor.go
+	polkaWebhookSecrets []string // Any of these may sign Polka webhooks, more than one while rotating
+	webAuthn            *webauthn.WebAuthn
+	oidcProviders       map[string]*oidcProvider // By name, ex: "google"
+	passwordPolicy      auth.PasswordPolicy
+	passwordHasher      *auth.PasswordHasher
+	webhookClient       *http.Client // Sends outbound webhooks, see outbound_webhooks.go
+
+	// Postgres (db) in production, repository.Memory in tests, see internal/repository
+	users         repository.Users
+	chirps        repository.Chirps
+	refreshTokens repository.RefreshTokens
+	webhookOutbox repository.WebhookOutbox
+
+	// Per-user limits from the user's plan, see entitlements.go
+	chirpRateLimiter *ratelimit.Limiter
+	// Per client IP, for endpoints that don't need a login, see login_throttle.go
+	ipRateLimiter *ratelimit.Limiter
+
+	// Failed login tracking, see login_throttle.go
+	accountThrottle *throttle.Throttler
+	ipThrottle      *throttle.Throttler
 }
 
 func main() {
-	cfg := initApiConfig()
+	// Flags come before the command, ex: `chirpy --addr :9090` or `chirpy --log-level debug bootstrap-admin <email>`
+	conf, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	// Printed before validating, to help fix whatever is invalid
+	if len(args) == 2 && args[0] == "config" && args[1] == "print" {
+		printConfig(conf)
+		return
+	}
+
+	// One-off commands, ex: `go run . migrate up`, see cli.go
+	// Without one (or with `serve`), start the server
+	command := len(args) > 0 && !slices.Equal(args, []string{"serve"})
+
+	if command {
+		err = conf.ValidateCommand()
+	} else {
+		err = conf.Validate()
+	}
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	cfg := newApiConfig(conf)
+
+	shutdownTracing, err := initTracing(context.Background(), conf.OTelTracesExporter)
+	if err != nil {
+		fatal("Error configuring tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if command {
+		err := runCommand(context.Background(), cfg, args, os.Stdin, os.Stdout)
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, CLI_USAGE)
+			os.Exit(2)
+		}
+		if err != nil {
+			fatal("Command failed", "command", strings.Join(args, " "), "error", err)
+		}
+		return
+	}
+
+	// Stops on Ctrl-C, or SIGTERM from `docker stop`, Kubernetes, etc.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Endpoints, requests to /api are checked against the OpenAPI spec first, see openapi.go
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		fatal("Error loading OpenAPI spec", "error", err)
+	}
+	mux := cfg.routes(ctx)
+
+	// Background jobs, stop after their current run once ctx is cancelled
+	jobs := sync.WaitGroup{}
+	jobs.Add(3)
+	go func() {
+		defer jobs.Done()
+		cfg.runSubscriptionExpiry(ctx, SUBSCRIPTION_EXPIRY_INTERVAL)
+	}()
+	go func() {
+		defer jobs.Done()
+		cfg.runWebhookDeliveries(ctx, WEBHOOK_DELIVERY_INTERVAL)
+	}()
+	go func() {
+		defer jobs.Done()
+		cfg.runInboundWebhookCleanup(ctx, INBOUND_WEBHOOK_CLEANUP_INTERVAL)
+	}()
+
+	// Start server
+	handler := middlewareTracing(middlewareRequestLog(middlewareMetrics(middlewareRecover(middlewareMaxBodyBytes(middlewareValidateRequest(spec, mux))))))
+	server := newServer(conf.Addr, handler)
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		fatal("Error listening", "addr", server.Addr, "error", err)
+	}
+	slog.Info("Listening", "addr", server.Addr)
+
+	err = serveUntilDone(ctx, server, listener, conf.ShutdownDrainDelay)
+	if err != nil {
+		slog.Error("Server stopped with an error", "error", err)
+	}
+
+	// Let background jobs finish their current run, then release the database
+	jobs.Wait()
+	cfg.sqlDB.Close()
+	slog.Info("Shut down")
+}
 
-	// Endpoints
+// Every endpoint, `ctx` is cancelled when the server starts shutting down
+func (cfg *apiConfig) routes(ctx context.Context) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.Handle("/app/", cfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
-	mux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
-	mux.HandleFunc("POST /admin/reset", cfg.deleteUsersHandler())
 	mux.HandleFunc("GET /api/healthz", healthHandler)
+	mux.HandleFunc("GET /api/livez", livezHandler)
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /api/readyz", cfg.readyzHandler(ctx))
+	mux.Handle("GET /metrics", prometheusHandler())
+
+	// Admin endpoints
+	// Every /admin/ route requires at least a moderator, some routes are further limited to admins
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /admin/metrics", cfg.metricsHandler)
+	adminMux.Handle("POST /admin/reset", cfg.middlewareRequireRole(RoleAdmin, cfg.deleteUsersHandler()))
+	adminMux.Handle("PUT /admin/users/{userID}/role", cfg.middlewareRequireRole(RoleAdmin, cfg.setUserRoleHandler()))
+	adminMux.Handle("GET /admin/webhooks", cfg.middlewareRequireRole(RoleAdmin, cfg.getInboundWebhooksHandler()))
+	adminMux.Handle("POST /admin/webhooks/{webhookID}/replay", cfg.middlewareRequireRole(RoleAdmin, cfg.replayInboundWebhookHandler()))
+	adminMux.Handle("POST /admin/webhook-endpoints", cfg.middlewareRequireRole(RoleAdmin, cfg.createWebhookEndpointHandler()))
+	adminMux.Handle("GET /admin/webhook-endpoints", cfg.middlewareRequireRole(RoleAdmin, cfg.getWebhookEndpointsHandler()))
+	adminMux.Handle("DELETE /admin/webhook-endpoints/{endpointID}", cfg.middlewareRequireRole(RoleAdmin, cfg.deleteWebhookEndpointHandler()))
+	adminMux.Handle("GET /admin/webhook-endpoints/{endpointID}/attempts", cfg.middlewareRequireRole(RoleAdmin, cfg.getWebhookDeliveryAttemptsHandler()))
+	adminMux.Handle("POST /admin/webhook-deliveries/{deliveryID}/requeue", cfg.middlewareRequireRole(RoleAdmin, cfg.requeueWebhookDeliveryHandler()))
+
+	mux.Handle("/admin/", cfg.middlewareRequireRole(RoleModerator, adminMux))
 
 	mux.HandleFunc("POST /api/users", cfg.createUserHandler())
 	mux.HandleFunc("PUT /api/users", cfg.updateUserHandler())
@@ -41,24 +193,43 @@ func main() {
 	mux.HandleFunc("GET /api/chirps", cfg.getChirps())
 	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpByID())
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler())
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.editChirpHandler())
 	mux.HandleFunc("POST /api/chirps", cfg.postChirpHandler())
 
 	mux.HandleFunc("POST /api/validate_chirp", validateChirpHandler)
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin())
+	mux.HandleFunc("POST /api/login/2fa", cfg.handlerLoginTOTP())
+	mux.HandleFunc("POST /api/2fa/enroll", cfg.enrollTOTPHandler())
+	mux.HandleFunc("POST /api/2fa/confirm", cfg.confirmTOTPHandler())
+
+	mux.HandleFunc("POST /api/passkeys/register/begin", cfg.beginPasskeyRegistrationHandler())
+	mux.HandleFunc("POST /api/passkeys/register/finish", cfg.finishPasskeyRegistrationHandler())
+	mux.HandleFunc("POST /api/passkeys/login/begin", cfg.beginPasskeyLoginHandler())
+	mux.HandleFunc("POST /api/passkeys/login/finish", cfg.finishPasskeyLoginHandler())
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.oidcLoginHandler())
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.oidcCallbackHandler())
+	mux.HandleFunc("POST /api/oidc/{provider}/link", cfg.oidcLinkHandler())
+	// Third-party apps, see oauth.go
+	mux.HandleFunc("POST /api/apps", cfg.createOAuthAppHandler())
+	mux.HandleFunc("GET /api/apps", cfg.getAuthorizedAppsHandler())
+	mux.HandleFunc("DELETE /api/apps/{clientID}/authorization", cfg.revokeAppAuthorizationHandler())
+	mux.HandleFunc("GET /api/oauth/authorize", cfg.getOAuthConsentHandler())
+	mux.HandleFunc("POST /api/oauth/authorize", cfg.postOAuthConsentHandler())
+	mux.HandleFunc("POST /api/oauth/token", cfg.oauthTokenHandler())
+
+	mux.HandleFunc("POST /api/tokens", cfg.createPersonalAccessTokenHandler())
+	mux.HandleFunc("GET /api/tokens", cfg.getPersonalAccessTokensHandler())
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", cfg.revokePersonalAccessTokenHandler())
+
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh())
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke())
 
 	// Webhooks ("Polka" is a imaginary payment process)
-	mux.HandleFunc("POST /api/polka/webhooks", cfg.handlerUserUpgraded())
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.polkaWebhookHandler())
+	mux.HandleFunc("GET /api/subscription", cfg.getSubscriptionHandler())
 
-	// Start server
-	server := &http.Server{
-		Handler: mux,
-		Addr:    ":8080",
-	}
-
-	server.ListenAndServe()
+	return mux
 }
 
 // Increments the number of hits to the server for the given endpoint handler
@@ -87,31 +258,120 @@ func (cfg *apiConfig) resetMetricsHandler(w http.ResponseWriter, r *http.Request
 	w.Write([]byte(http.StatusText(http.StatusOK)))
 }
 
-func initApiConfig() *apiConfig {
-	godotenv.Load() // .env at root
+// Writes the config with secrets redacted, then any validation errors
+func printConfig(conf *config.Config) {
+	err := conf.Print(os.Stdout)
+	if err != nil {
+		fatal("Error printing configuration", "error", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+}
+
+func newApiConfig(conf *config.Config) *apiConfig {
+	// JSON logs, already checked by conf.Validate
+	logLevel, _ := logging.ParseLevel(conf.LogLevel)
+	slog.SetDefault(slog.New(logging.NewHandler(os.Stderr, logLevel)))
+
+	// Also checked by conf.Validate, empty for commands
+	totpEncryptionKey, _ := base64.StdEncoding.DecodeString(conf.TOTPEncryptionKey)
 
 	// Initialize database
-	dbURL := os.Getenv("DB_URL")
-	db, err := sql.Open("postgres", dbURL)
+	db, err := sql.Open("postgres", conf.DBURL)
 	if err != nil {
 		panic("Error connecting to the database")
 	}
-	dbQueries := database.New(db)
+	dbQueries := database.New(instrumentedDB{db: db})
 
-	// Other variables
-	platform := os.Getenv("PLATFORM")
-	jwtSecret := os.Getenv("JWT_SECRET")
-	polkaAPIKey := os.Getenv("POLKA_API_KEY")
+	db.SetMaxOpenConns(conf.DBMaxOpenConns)
+	db.SetMaxIdleConns(conf.DBMaxIdleConns)
+	db.SetConnMaxLifetime(conf.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(conf.DBConnMaxIdleTime)
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          conf.WebAuthnRPID,
+		RPDisplayName: "Chirpy",
+		RPOrigins:     conf.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Error configuring WebAuthn: %v", err))
+	}
+
+	oidcProviders := map[string]*oidcProvider{}
+	for _, provider := range conf.OIDCProviders {
+		oidcProviders[provider.Name] = &oidcProvider{
+			name:         provider.Name,
+			issuer:       provider.Issuer,
+			clientID:     provider.ClientID,
+			clientSecret: provider.ClientSecret,
+			redirectURL:  strings.TrimSuffix(conf.OIDCRedirectBaseURL, "/") + "/api/oidc/" + provider.Name + "/callback",
+		}
+	}
+
+	// New password rules, the breached password list is optional since it's a large download
+	passwordPolicy := auth.DefaultPasswordPolicy()
+	passwordPolicy.MinLength = conf.PasswordMinLength
+	if conf.BreachedPasswordsFile != "" {
+		breachedPasswords, err := auth.OpenBreachedPasswordFile(conf.BreachedPasswordsFile)
+		if err != nil {
+			panic(fmt.Sprintf("Error loading BREACHED_PASSWORDS_FILE: %v", err))
+		}
+		passwordPolicy.Breached = breachedPasswords
+	}
+
+	// Password hashing, existing hashes are upgraded to these settings when their users log in
+	passwordHasher, err := auth.NewPasswordHasher(
+		conf.PasswordHashAlgorithm,
+		auth.Argon2idParams{
+			Memory:      uint32(conf.Argon2MemoryKiB),
+			Iterations:  uint32(conf.Argon2Iterations),
+			Parallelism: uint8(conf.Argon2Parallelism),
+			SaltLength:  auth.DefaultArgon2idParams.SaltLength,
+			KeyLength:   auth.DefaultArgon2idParams.KeyLength,
+		},
+		conf.BcryptCost,
+	)
+	if err != nil {
+		panic(fmt.Sprintf("Error configuring password hashing: %v", err))
+	}
 
 	// Set values into config
 	cfg := &apiConfig{
-		db:          dbQueries,
-		platform:    platform,
-		jwtSecret:   jwtSecret,
-		polkaAPIKey: polkaAPIKey,
+		db:                  dbQueries,
+		sqlDB:               db,
+		users:               dbQueries,
+		chirps:              dbQueries,
+		refreshTokens:       dbQueries,
+		webhookOutbox:       dbQueries,
+		platform:            conf.Platform,
+		jwtSecret:           conf.JWTSecret,
+		totpEncryptionKey:   totpEncryptionKey,
+		polkaWebhookSecrets: conf.PolkaWebhookSecrets,
+		webAuthn:            webAuthn,
+		oidcProviders:       oidcProviders,
+
+		passwordPolicy: passwordPolicy,
+		passwordHasher: passwordHasher,
+
+		webhookClient: &http.Client{Timeout: WEBHOOK_DELIVERY_TIMEOUT},
+
+		accountThrottle: throttle.New(accountLoginPolicy),
+		ipThrottle:      throttle.New(ipLoginPolicy),
+
+		chirpRateLimiter: ratelimit.New(),
+		ipRateLimiter:    ratelimit.New(),
 	}
 
 	cfg.fileServerHits.Store(0)
 
 	return cfg
 }
+
+// Logs the error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, served at GET /metrics
+// Kept in their own registry so tests can create many apiConfigs without registering metrics twice
+var (
+	metricsRegistry = prometheus.NewRegistry()
+	newMetric       = promauto.With(metricsRegistry)
+
+	httpRequestsTotal = newMetric.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_http_requests_total",
+		Help: "HTTP requests by route pattern, method, and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = newMetric.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chirpy_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern, method, and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpRequestsInFlight = newMetric.NewGauge(prometheus.GaugeOpts{
+		Name: "chirpy_http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	dbQueryDuration = newMetric.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chirpy_db_query_duration_seconds",
+		Help:    "Database query latency by sqlc query name.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+
+	loginsTotal = newMetric.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_logins_total",
+		Help: "Login attempts by method (password, totp, passkey, oidc) and result (success, failure, mfa_required, throttled).",
+	}, []string{"method", "result"})
+
+	inboundWebhooksTotal = newMetric.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_inbound_webhooks_total",
+		Help: "Inbound webhook deliveries by final status, see inbound_webhooks.go.",
+	}, []string{"status"})
+
+	outboundWebhookAttemptsTotal = newMetric.NewCounterVec(prometheus.CounterOpts{
+		Name: "chirpy_outbound_webhook_attempts_total",
+		Help: "Outbound webhook delivery attempts by event type and the delivery's next status (delivered, pending for a retry, dead).",
+	}, []string{"event_type", "status"})
+)
+
+// Login methods and results for chirpy_logins_total
+const (
+	LOGIN_METHOD_PASSWORD = "password"
+	LOGIN_METHOD_TOTP     = "totp"
+	LOGIN_METHOD_PASSKEY  = "passkey"
+	LOGIN_METHOD_OIDC     = "oidc"
+
+	LOGIN_SUCCESS      = "success"
+	LOGIN_FAILURE      = "failure"
+	LOGIN_MFA_REQUIRED = "mfa_required"
+	LOGIN_THROTTLED    = "throttled"
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Serves the metrics in the Prometheus text format
+func prometheusHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Counts and times every request by the mux pattern it matched, ex: "GET /api/chirps/{chirpID}"
+// Patterns rather than paths keep the number of label values small
+func middlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// Set by the mux (and nested muxes, ex: the admin mux) while serving the request
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(recorder.status)}
+		httpRequestsTotal.With(labels).Inc()
+		httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+func recordLoginMetric(method, result string) {
+	loginsTotal.WithLabelValues(method, result).Inc()
+}
+
+// Remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+
+	// Request context, for logs written by the response helpers, set by middlewareRequestLog, see responseContext
+	ctx context.Context
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Writing without WriteHeader sends a 200
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Lets http.ResponseController reach the underlying writer, ex: to flush
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Times and traces each sqlc query by its name, see tracing.go
+type instrumentedDB struct {
+	db database.DBTX
+}
+
+func (i instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	ctx, endSpan := startQuerySpan(ctx, query)
+
+	result, err := i.db.ExecContext(ctx, query, args...)
+	endSpan(err)
+	return result, err
+}
+
+func (i instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+func (i instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	ctx, endSpan := startQuerySpan(ctx, query)
+
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	endSpan(err)
+	return rows, err
+}
+
+func (i instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	ctx, endSpan := startQuerySpan(ctx, query)
+
+	row := i.db.QueryRowContext(ctx, query, args...)
+	endSpan(row.Err())
+	return row
+}
+
+func observeQuery(query string, start time.Time) {
+	dbQueryDuration.WithLabelValues(queryName(query)).Observe(time.Since(start).Seconds())
+}
+
+// Returns the name from sqlc's leading "-- name: GetUserByID :one" comment
+func queryName(query string) string {
+	rest, found := strings.CutPrefix(query, "-- name: ")
+	if !found {
+		return "unknown"
+	}
+
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
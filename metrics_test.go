@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryName(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "-- name: GetUserByID :one\nSELECT * FROM users WHERE id = $1",
+			expected: "GetUserByID",
+		},
+		{
+			query:    "-- name: DeleteUsers :exec\nDELETE FROM users",
+			expected: "DeleteUsers",
+		},
+		{
+			query:    "SELECT 1",
+			expected: "unknown",
+		},
+	}
+
+	for _, c := range cases {
+		actual := queryName(c.query)
+		if actual != c.expected {
+			t.Error(formatTestError(c.query, actual, c.expected))
+		}
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	// Same nesting as main, admin routes are served by a second mux
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("POST /admin/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	mux.Handle("/admin/", adminMux)
+	mux.Handle("GET /metrics", prometheusHandler())
+
+	handler := middlewareMetrics(mux)
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		expectedRoute  string
+		expectedStatus string
+	}{
+		{
+			name:           "Route pattern, not the path",
+			method:         http.MethodGet,
+			target:         "/api/things/123",
+			expectedRoute:  "GET /api/things/{thingID}",
+			expectedStatus: "200",
+		},
+		{
+			name:           "Nested mux pattern",
+			method:         http.MethodPost,
+			target:         "/admin/things/123",
+			expectedRoute:  "POST /admin/things/{thingID}",
+			expectedStatus: "409",
+		},
+		{
+			name:           "No matching route",
+			method:         http.MethodGet,
+			target:         "/nope",
+			expectedRoute:  "unmatched",
+			expectedStatus: "404",
+		},
+	}
+
+	for _, c := range cases {
+		counter := httpRequestsTotal.WithLabelValues(c.expectedRoute, c.method, c.expectedStatus)
+		before := testutil.ToFloat64(counter)
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(c.method, c.target, nil))
+
+		actual := testutil.ToFloat64(counter) - before
+		if actual != 1 {
+			t.Error(formatTestError(c.name, actual, 1))
+		}
+	}
+
+	// Exposed in the Prometheus text format
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := recorder.Body.String()
+	for _, expected := range []string{
+		`chirpy_http_requests_total{method="GET",route="GET /api/things/{thingID}",status="200"}`,
+		"chirpy_http_request_duration_seconds_bucket",
+		"chirpy_http_requests_in_flight 1",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Error(formatTestError("GET /metrics", body, expected))
+		}
+	}
+}
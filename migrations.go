@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Goose migrations, built into the binary
+//
+//go:embed sql/schema/*.sql
+var migrationsFS embed.FS
+
+const MIGRATIONS_DIR = "sql/schema"
+
+// Applies the embedded migrations, see `chirpy migrate`
+// Tracks versions in goose_db_version, the same table as the goose CLI, so databases it migrated carry on where they left off
+func (cfg *apiConfig) migrationProvider() (*goose.Provider, error) {
+	migrations, err := fs.Sub(migrationsFS, MIGRATIONS_DIR)
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, cfg.sqlDB, migrations)
+}
+
+// Version of the newest migration, from its file name, ex: 15 for 015_outbound_webhooks.sql
+func latestMigrationVersion() (int64, error) {
+	entries, err := fs.ReadDir(migrationsFS, MIGRATIONS_DIR)
+	if err != nil {
+		return 0, err
+	}
+
+	latest := int64(0)
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			return 0, fmt.Errorf("migration %v has no version prefix", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("migration %v has an invalid version: %w", entry.Name(), err)
+		}
+		latest = max(latest, version)
+	}
+
+	return latest, nil
+}
+
+// Version the database was last migrated to, from goose's own table
+func (cfg *apiConfig) appliedMigrationVersion(ctx context.Context) (int64, error) {
+	version := int64(0)
+	err := cfg.sqlDB.QueryRowContext(ctx, "SELECT COALESCE(MAX(version_id), 0) FROM goose_db_version WHERE is_applied").Scan(&version)
+	return version, err
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Chirpy as an OAuth 2.0 authorization server, so third-party apps can act for users without their password
+// Authorization code flow with PKCE: https://datatracker.ietf.org/doc/html/rfc6749#section-4.1
+//  1. The app sends the user to Chirpy's consent screen, which loads GET /api/oauth/authorize
+//  2. The user approves at POST /api/oauth/authorize, and is redirected back to the app with a code
+//  3. The app exchanges the code at POST /api/oauth/token for scoped access and refresh tokens
+const (
+	OAUTH_CODE_DURATION          = 5 * time.Minute
+	OAUTH_ACCESS_TOKEN_DURATION  = time.Hour
+	OAUTH_REFRESH_TOKEN_DURATION = 60 * 24 * time.Hour
+
+	OAUTH_TOKEN_KIND_ACCESS  = "access"
+	OAUTH_TOKEN_KIND_REFRESH = "refresh"
+)
+
+// Registered app, the client secret is only included when the app is created
+type OAuthApp struct {
+	ClientID     uuid.UUID `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// App the user has granted access to
+type AuthorizedApp struct {
+	ClientID     uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	AuthorizedAt time.Time `json:"authorized_at"`
+}
+
+type OAuthScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// What the consent screen shows the user
+type OAuthConsentPrompt struct {
+	ClientID             uuid.UUID    `json:"client_id"`
+	AppName              string       `json:"app_name"`
+	Scopes               []OAuthScope `json:"scopes"`
+	PreviouslyAuthorized bool         `json:"previously_authorized"` // The user already granted all of these scopes
+}
+
+// Where the consent screen sends the browser next
+type OAuthAuthorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Authorization request parameters, from the query string (GET) or JSON body (POST)
+type oauthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// Error response from the OAuth endpoints: https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Registers a third-party app owned by the logged-in user
+// Public apps (mobile, single-page) can't keep a secret, so they don't get one and rely on PKCE alone
+func (cfg *apiConfig) createOAuthAppHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirect_uris"`
+			Public       bool     `json:"public"`
+		}{}
+
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		// Validate required fields
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "name", Code: FIELD_REQUIRED, Message: "Name required"})
+			return
+		}
+		if len(req.RedirectURIs) == 0 {
+			sendValidationErrorResponse(w, FieldError{Field: "redirect_uris", Code: FIELD_REQUIRED, Message: "At least one redirect URI required"})
+			return
+		}
+		for _, redirectURI := range req.RedirectURIs {
+			if err := validateRedirectURI(redirectURI); err != nil {
+				sendValidationErrorResponse(w, FieldError{Field: "redirect_uris", Code: FIELD_INVALID, Message: err.Error()})
+				return
+			}
+		}
+
+		clientSecret := ""
+		clientSecretHash := sql.NullString{}
+		if !req.Public {
+			clientSecret, err = auth.MakeOpaqueToken(auth.OAUTH_CLIENT_SECRET_PREFIX)
+			if err != nil {
+				sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+				return
+			}
+			clientSecretHash = sql.NullString{String: auth.HashToken(clientSecret), Valid: true}
+		}
+
+		app, err := cfg.db.CreateOAuthApp(r.Context(), database.CreateOAuthAppParams{
+			ID:               uuid.New(),
+			CreatedAt:        time.Now(),
+			UpdatedAt:        time.Now(),
+			OwnerID:          userID,
+			Name:             req.Name,
+			ClientSecretHash: clientSecretHash,
+			RedirectUris:     req.RedirectURIs,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusCreated, OAuthApp{
+			ClientID:     app.ID,
+			ClientSecret: clientSecret,
+			Name:         app.Name,
+			RedirectURIs: app.RedirectUris,
+			CreatedAt:    app.CreatedAt,
+		})
+	}
+}
+
+// Lists the apps the logged-in user has authorized
+func (cfg *apiConfig) getAuthorizedAppsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		rows, err := cfg.db.GetAuthorizedOAuthApps(r.Context(), userID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		apps := []AuthorizedApp{}
+		for _, row := range rows {
+			apps = append(apps, AuthorizedApp{
+				ClientID:     row.ID,
+				Name:         row.Name,
+				Scopes:       row.Scopes,
+				AuthorizedAt: row.AuthorizedAt,
+			})
+		}
+
+		SendJSONResponse(w, http.StatusOK, apps)
+	}
+}
+
+// Revokes the app's access to the logged-in user's account, including every token it was issued
+func (cfg *apiConfig) revokeAppAuthorizationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		clientID, err := uuid.Parse(r.PathValue("clientID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "App not found", http.StatusNotFound, err)
+			return
+		}
+
+		rowsDeleted, err := cfg.db.DeleteOAuthAuthorization(r.Context(), database.DeleteOAuthAuthorizationParams{
+			UserID: userID,
+			AppID:  clientID,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		if rowsDeleted == 0 {
+			sendErrorJSONResponse(w, "App not found", http.StatusNotFound, nil)
+			return
+		}
+
+		sendResponse(w, http.StatusNoContent, fmt.Sprintf("user %v revoked app %v", userID, clientID))
+	}
+}
+
+// Checks an authorization request, and returns what the consent screen should show
+func (cfg *apiConfig) getOAuthConsentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		query := r.URL.Query()
+		req := oauthAuthorizeRequest{
+			ResponseType:        query.Get("response_type"),
+			ClientID:            query.Get("client_id"),
+			RedirectURI:         query.Get("redirect_uri"),
+			Scope:               query.Get("scope"),
+			State:               query.Get("state"),
+			CodeChallenge:       query.Get("code_challenge"),
+			CodeChallengeMethod: query.Get("code_challenge_method"),
+		}
+
+		app, scopes, err := cfg.validateAuthorizeRequest(r.Context(), req)
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+
+		// Skip the prompt on the consent screen if nothing new is being asked for
+		previouslyAuthorized := false
+		authorization, err := cfg.db.GetOAuthAuthorization(r.Context(), database.GetOAuthAuthorizationParams{
+			UserID: userID,
+			AppID:  app.ID,
+		})
+		if err == nil {
+			previouslyAuthorized = true
+			for _, scope := range scopes {
+				if !slices.Contains(authorization.Scopes, scope) {
+					previouslyAuthorized = false
+				}
+			}
+		} else if err != sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		scopeDescriptions := []OAuthScope{}
+		for _, scope := range scopes {
+			scopeDescriptions = append(scopeDescriptions, OAuthScope{Name: scope, Description: tokenScopes[scope]})
+		}
+
+		SendJSONResponse(w, http.StatusOK, OAuthConsentPrompt{
+			ClientID:             app.ID,
+			AppName:              app.Name,
+			Scopes:               scopeDescriptions,
+			PreviouslyAuthorized: previouslyAuthorized,
+		})
+	}
+}
+
+// Records the user's approval or denial, and returns the app's redirect URI to send the browser to
+func (cfg *apiConfig) postOAuthConsentHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			oauthAuthorizeRequest
+			Approve bool `json:"approve"`
+		}{}
+
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		app, scopes, err := cfg.validateAuthorizeRequest(r.Context(), req.oauthAuthorizeRequest)
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+
+		// Redirect URI was checked against the app's, so the result can be sent there
+		redirectURL, err := url.Parse(req.RedirectURI)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		redirectQuery := redirectURL.Query()
+		if req.State != "" {
+			redirectQuery.Set("state", req.State)
+		}
+
+		if !req.Approve {
+			redirectQuery.Set("error", "access_denied")
+			redirectURL.RawQuery = redirectQuery.Encode()
+			SendJSONResponse(w, http.StatusOK, OAuthAuthorizeResponse{RedirectTo: redirectURL.String()})
+			return
+		}
+
+		authorization, err := cfg.db.UpsertOAuthAuthorization(r.Context(), database.UpsertOAuthAuthorizationParams{
+			ID:        uuid.New(),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+			UserID:    userID,
+			AppID:     app.ID,
+			Scopes:    scopes,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Save the code until the app exchanges it, clearing out unused ones
+		err = cfg.db.DeleteExpiredOAuthCodes(r.Context(), time.Now())
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		code, err := auth.MakeOpaqueToken("")
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		err = cfg.db.CreateOAuthCode(r.Context(), database.CreateOAuthCodeParams{
+			CodeHash:        auth.HashToken(code),
+			CreatedAt:       time.Now(),
+			ExpiresAt:       time.Now().Add(OAUTH_CODE_DURATION),
+			AuthorizationID: authorization.ID,
+			RedirectUri:     req.RedirectURI,
+			Scopes:          scopes,
+			CodeChallenge:   req.CodeChallenge,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		redirectQuery.Set("code", code)
+		redirectURL.RawQuery = redirectQuery.Encode()
+		SendJSONResponse(w, http.StatusOK, OAuthAuthorizeResponse{RedirectTo: redirectURL.String()})
+	}
+}
+
+// Token endpoint, called by the app (not the browser) with a form-encoded body
+// Supports the `authorization_code` and `refresh_token` grants
+func (cfg *apiConfig) oauthTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			sendOAuthErrorResponse(w, &oauthError{Code: "invalid_request", Description: "Body must be form-encoded"})
+			return
+		}
+
+		app, err := cfg.authenticateOAuthClient(r)
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+
+		// The code or refresh token is only used up once it's valid, so a wrong request can't burn the app's
+		tx, err := cfg.sqlDB.BeginTx(r.Context(), nil)
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+		defer tx.Rollback()
+		q := cfg.db.WithTx(tx)
+
+		var authorizationID uuid.UUID
+		var scopes []string
+
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			code, err := q.GetOAuthCodeForUpdate(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+			if err == sql.ErrNoRows {
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Invalid or used code"})
+				return
+			}
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			// A reused code may have been stolen, so the tokens issued with it are revoked (RFC 6749 4.1.2)
+			if code.UsedAt.Valid {
+				err = q.DeleteOAuthAuthorizationByID(r.Context(), code.AuthorizationID)
+				if err != nil {
+					sendOAuthErrorResponse(w, err)
+					return
+				}
+				err = tx.Commit()
+				if err != nil {
+					sendOAuthErrorResponse(w, err)
+					return
+				}
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Invalid or used code"})
+				return
+			}
+
+			authorization, err := q.GetOAuthAuthorizationByID(r.Context(), code.AuthorizationID)
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			switch {
+			case authorization.AppID != app.ID:
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Code was issued to a different client"})
+				return
+			case code.ExpiresAt.Before(time.Now()):
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Code expired"})
+				return
+			case code.RedirectUri != r.PostForm.Get("redirect_uri"):
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "redirect_uri does not match the authorization request"})
+				return
+			case !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge):
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Invalid code_verifier"})
+				return
+			}
+
+			err = q.UseOAuthCode(r.Context(), database.UseOAuthCodeParams{
+				CodeHash: code.CodeHash,
+				UsedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+			})
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			authorizationID = authorization.ID
+			scopes = code.Scopes
+		case "refresh_token":
+			refreshToken, err := q.GetOAuthRefreshTokenForUpdate(r.Context(), auth.HashToken(r.PostForm.Get("refresh_token")))
+			if err == sql.ErrNoRows {
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Invalid, used, or revoked refresh token"})
+				return
+			}
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			authorization, err := q.GetOAuthAuthorizationByID(r.Context(), refreshToken.AuthorizationID)
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			if authorization.AppID != app.ID {
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Refresh token was issued to a different client"})
+				return
+			}
+			if refreshToken.ExpiresAt.Before(time.Now()) {
+				sendOAuthErrorResponse(w, &oauthError{Code: "invalid_grant", Description: "Refresh token expired"})
+				return
+			}
+
+			err = q.DeleteOAuthToken(r.Context(), refreshToken.TokenHash)
+			if err != nil {
+				sendOAuthErrorResponse(w, err)
+				return
+			}
+
+			authorizationID = authorization.ID
+			scopes = refreshToken.Scopes
+		default:
+			sendOAuthErrorResponse(w, &oauthError{Code: "unsupported_grant_type", Description: "grant_type must be authorization_code or refresh_token"})
+			return
+		}
+
+		tokenResponse, err := issueOAuthTokens(r.Context(), q, authorizationID, scopes)
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			sendOAuthErrorResponse(w, err)
+			return
+		}
+
+		// Response
+		w.Header().Set("Cache-Control", "no-store")
+		SendJSONResponse(w, http.StatusOK, tokenResponse)
+	}
+}
+
+// Returns the app, and its requested scopes, if the authorization request is valid
+// Errors are *oauthError, except database failures
+func (cfg *apiConfig) validateAuthorizeRequest(ctx context.Context, req oauthAuthorizeRequest) (database.OauthApp, []string, error) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return database.OauthApp{}, nil, &oauthError{Code: "invalid_request", Description: "Unknown client_id"}
+	}
+
+	app, err := cfg.db.GetOAuthApp(ctx, clientID)
+	if err == sql.ErrNoRows {
+		return database.OauthApp{}, nil, &oauthError{Code: "invalid_request", Description: "Unknown client_id"}
+	}
+	if err != nil {
+		return database.OauthApp{}, nil, err
+	}
+
+	// Exact match only, otherwise codes could be sent to an attacker's page
+	if !slices.Contains(app.RedirectUris, req.RedirectURI) {
+		return database.OauthApp{}, nil, &oauthError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+
+	if req.ResponseType != "code" {
+		return database.OauthApp{}, nil, &oauthError{Code: "unsupported_response_type", Description: "response_type must be code"}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return database.OauthApp{}, nil, &oauthError{Code: "invalid_request", Description: "PKCE required, with code_challenge_method S256"}
+	}
+
+	scopes, err := parseOAuthScopes(req.Scope)
+	if err != nil {
+		return database.OauthApp{}, nil, err
+	}
+
+	return app, scopes, nil
+}
+
+// Returns the app, if the client ID (and secret, for confidential apps) in the request are correct
+// Credentials can be sent with HTTP Basic auth or in the form body
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthApp, error) {
+	clientIDParam, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientIDParam = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	invalidClient := &oauthError{Code: "invalid_client", Description: "Client authentication failed"}
+
+	clientID, err := uuid.Parse(clientIDParam)
+	if err != nil {
+		return database.OauthApp{}, invalidClient
+	}
+
+	app, err := cfg.db.GetOAuthApp(r.Context(), clientID)
+	if err == sql.ErrNoRows {
+		return database.OauthApp{}, invalidClient
+	}
+	if err != nil {
+		return database.OauthApp{}, err
+	}
+
+	if app.ClientSecretHash.Valid && subtle.ConstantTimeCompare([]byte(auth.HashToken(clientSecret)), []byte(app.ClientSecretHash.String)) != 1 {
+		return database.OauthApp{}, invalidClient
+	}
+
+	return app, nil
+}
+
+// Issues a new access and refresh token pair for the authorization, through q so it's part of the token request's transaction
+func issueOAuthTokens(ctx context.Context, q *database.Queries, authorizationID uuid.UUID, scopes []string) (OAuthTokenResponse, error) {
+	err := q.DeleteExpiredOAuthTokens(ctx, time.Now())
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	accessToken, err := auth.MakeOpaqueToken(auth.OAUTH_ACCESS_TOKEN_PREFIX)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	refreshToken, err := auth.MakeOpaqueToken(auth.OAUTH_REFRESH_TOKEN_PREFIX)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	tokens := []struct {
+		token    string
+		kind     string
+		duration time.Duration
+	}{
+		{token: accessToken, kind: OAUTH_TOKEN_KIND_ACCESS, duration: OAUTH_ACCESS_TOKEN_DURATION},
+		{token: refreshToken, kind: OAUTH_TOKEN_KIND_REFRESH, duration: OAUTH_REFRESH_TOKEN_DURATION},
+	}
+
+	for _, t := range tokens {
+		err = q.CreateOAuthToken(ctx, database.CreateOAuthTokenParams{
+			TokenHash:       auth.HashToken(t.token),
+			CreatedAt:       time.Now(),
+			ExpiresAt:       time.Now().Add(t.duration),
+			AuthorizationID: authorizationID,
+			Kind:            t.kind,
+			Scopes:          scopes,
+		})
+		if err != nil {
+			return OAuthTokenResponse{}, err
+		}
+	}
+
+	return OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(OAUTH_ACCESS_TOKEN_DURATION.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// Parses the space-separated scope parameter, rejecting unknown scopes
+func parseOAuthScopes(scope string) ([]string, error) {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if _, ok := tokenScopes[s]; !ok {
+			return nil, &oauthError{Code: "invalid_scope", Description: fmt.Sprintf("Unknown scope %q", s)}
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, &oauthError{Code: "invalid_scope", Description: "At least one scope required"}
+	}
+
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// Redirect URIs must be absolute, without a fragment, and use HTTPS (HTTP is allowed for loopback, for local development)
+// https://datatracker.ietf.org/doc/html/rfc6749#section-3.1.2
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("Redirect URI must be an absolute URL: %v", redirectURI)
+	}
+
+	if u.Fragment != "" {
+		return fmt.Errorf("Redirect URI must not have a fragment: %v", redirectURI)
+	}
+
+	isLoopback := u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1" || u.Hostname() == "::1"
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback) {
+		return fmt.Errorf("Redirect URI must use HTTPS: %v", redirectURI)
+	}
+
+	return nil
+}
+
+// Responds with the OAuth error format, or 500 for other errors
+func sendOAuthErrorResponse(w http.ResponseWriter, err error) {
+	oauthErr := &oauthError{}
+	if !errors.As(err, &oauthErr) {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	statusCode := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		statusCode = http.StatusUnauthorized
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	SendJSONResponse(w, statusCode, oauthErr)
+}
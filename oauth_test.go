@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParseOAuthScopes(t *testing.T) {
+	cases := []struct {
+		name        string
+		scope       string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "Single scope",
+			scope:    "chirps:write",
+			expected: []string{SCOPE_CHIRPS_WRITE},
+		},
+		{
+			name:     "Duplicates and extra spaces removed",
+			scope:    " chirps:write  chirps:write ",
+			expected: []string{SCOPE_CHIRPS_WRITE},
+		},
+		{
+			name:        "Unknown scope",
+			scope:       "chirps:write admin",
+			expectError: true,
+		},
+		{
+			name:        "No scopes",
+			scope:       "",
+			expectError: true,
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := parseOAuthScopes(c.scope)
+		if c.expectError {
+			if err == nil {
+				t.Error(formatTestError(c.name, actual, "error"))
+			}
+			continue
+		}
+
+		if err != nil || !slices.Equal(actual, c.expected) {
+			t.Error(formatTestError(c.name, actual, c.expected))
+		}
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	cases := []struct {
+		redirectURI string
+		valid       bool
+	}{
+		{redirectURI: "https://app.example.com/callback", valid: true},
+		{redirectURI: "http://localhost:3000/callback", valid: true},
+		{redirectURI: "http://127.0.0.1/callback", valid: true},
+		{redirectURI: "http://app.example.com/callback", valid: false},
+		{redirectURI: "https://app.example.com/callback#token", valid: false},
+		{redirectURI: "/callback", valid: false},
+		{redirectURI: "javascript:alert(1)", valid: false},
+	}
+
+	for _, c := range cases {
+		err := validateRedirectURI(c.redirectURI)
+		assertEquals(err == nil, c.valid, c.redirectURI, t)
+	}
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Register app
+	w := sendBearerRequest(cfg.createOAuthAppHandler(), "POST", "/api/apps", loggedInUser.Token, `{"name": "Chirp Scheduler", "redirect_uris": ["https://scheduler.example.com/callback"]}`)
+	assertEquals(w.Result().StatusCode, http.StatusCreated, "register app", t)
+
+	app := OAuthApp{}
+	json.NewDecoder(w.Result().Body).Decode(&app)
+	if app.ClientSecret == "" {
+		t.Error(formatTestError("register app", app, "client secret"))
+	}
+
+	// Consent screen
+	verifier := oauth2.GenerateVerifier()
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {app.ClientID.String()},
+		"redirect_uri":          {"https://scheduler.example.com/callback"},
+		"scope":                 {SCOPE_CHIRPS_WRITE},
+		"state":                 {"xyz"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	w = sendBearerRequest(cfg.getOAuthConsentHandler(), "GET", "/api/oauth/authorize?"+authorizeParams.Encode(), loggedInUser.Token, "")
+	assertEquals(w.Result().StatusCode, http.StatusOK, "consent prompt", t)
+
+	prompt := OAuthConsentPrompt{}
+	json.NewDecoder(w.Result().Body).Decode(&prompt)
+	assertEquals(prompt.AppName, "Chirp Scheduler", "consent prompt app", t)
+	assertEquals(prompt.PreviouslyAuthorized, false, "consent prompt first time", t)
+
+	// Unregistered redirect URI is rejected before anything is sent there
+	badParams := url.Values{}
+	for k, v := range authorizeParams {
+		badParams[k] = v
+	}
+	badParams.Set("redirect_uri", "https://attacker.example.com/callback")
+	w = sendBearerRequest(cfg.getOAuthConsentHandler(), "GET", "/api/oauth/authorize?"+badParams.Encode(), loggedInUser.Token, "")
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "unregistered redirect URI", t)
+
+	// Deny
+	redirectTo := approveOAuthConsent(cfg, loggedInUser.Token, authorizeParams, false, t)
+	assertEquals(redirectTo.Query().Get("error"), "access_denied", "denied consent", t)
+
+	// Approve, wrong verifier doesn't use up the code, so someone who stole it can't stop the app from exchanging it
+	redirectTo = approveOAuthConsent(cfg, loggedInUser.Token, authorizeParams, true, t)
+	assertEquals(redirectTo.Query().Get("state"), "xyz", "state returned", t)
+
+	w = sendOAuthTokenRequest(cfg, app, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectTo.Query().Get("code")},
+		"redirect_uri":  {"https://scheduler.example.com/callback"},
+		"code_verifier": {oauth2.GenerateVerifier()},
+	})
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "wrong code verifier", t)
+
+	// Exchange code
+	w = sendOAuthTokenRequest(cfg, app, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectTo.Query().Get("code")},
+		"redirect_uri":  {"https://scheduler.example.com/callback"},
+		"code_verifier": {verifier},
+	})
+	assertEquals(w.Result().StatusCode, http.StatusOK, "code exchange", t)
+
+	tokens := OAuthTokenResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&tokens)
+	assertEquals(tokens.Scope, SCOPE_CHIRPS_WRITE, "token scope", t)
+
+	// Access token works where its scope allows, and nowhere else
+	_, err = postChirp(cfg, tokens.AccessToken, "Posted by an app")
+	if err != nil {
+		t.Error(formatTestError("post chirp with app token", err, nil))
+	}
+
+	w = sendBearerRequest(cfg.updateUserHandler(), "PUT", "/api/users", tokens.AccessToken, `{"email": "stolen@email.com", "password": "stolen-password"}`)
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "update user with app token", t)
+
+	// Listed as authorized
+	w = sendBearerRequest(cfg.getAuthorizedAppsHandler(), "GET", "/api/apps", loggedInUser.Token, "")
+	authorizedApps := []AuthorizedApp{}
+	json.NewDecoder(w.Result().Body).Decode(&authorizedApps)
+	if len(authorizedApps) != 1 || authorizedApps[0].ClientID != app.ClientID {
+		t.Error(formatTestError("authorized apps", authorizedApps, app.ClientID))
+	}
+
+	// Another app can't use up the refresh token
+	w = sendBearerRequest(cfg.createOAuthAppHandler(), "POST", "/api/apps", loggedInUser.Token, `{"name": "Other App", "redirect_uris": ["https://other.example.com/callback"]}`)
+	otherApp := OAuthApp{}
+	json.NewDecoder(w.Result().Body).Decode(&otherApp)
+
+	refreshParams := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+	}
+	w = sendOAuthTokenRequest(cfg, otherApp, refreshParams)
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "refresh token of another app", t)
+
+	// Refresh tokens are single-use
+	w = sendOAuthTokenRequest(cfg, app, refreshParams)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "refresh", t)
+
+	refreshedTokens := OAuthTokenResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&refreshedTokens)
+
+	w = sendOAuthTokenRequest(cfg, app, refreshParams)
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "reused refresh token", t)
+
+	// Wrong client secret
+	wrongSecretApp := app
+	wrongSecretApp.ClientSecret = "chirpy_cs_wrong"
+	w = sendOAuthTokenRequest(cfg, wrongSecretApp, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshedTokens.RefreshToken},
+	})
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "wrong client secret", t)
+
+	// Revoking the app revokes its tokens
+	request := httptest.NewRequest("DELETE", "/api/apps/"+app.ClientID.String()+"/authorization", nil)
+	request.SetPathValue("clientID", app.ClientID.String())
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w = httptest.NewRecorder()
+	cfg.revokeAppAuthorizationHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "revoke app", t)
+
+	_, err = postChirp(cfg, refreshedTokens.AccessToken, "Posted by a revoked app")
+	if err == nil {
+		t.Error(formatTestError("post chirp with revoked app token", err, "error"))
+	}
+
+	w = sendOAuthTokenRequest(cfg, app, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshedTokens.RefreshToken},
+	})
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "refresh after revoke", t)
+
+	// Reusing a code revokes the tokens issued with it
+	redirectTo = approveOAuthConsent(cfg, loggedInUser.Token, authorizeParams, true, t)
+	codeParams := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectTo.Query().Get("code")},
+		"redirect_uri":  {"https://scheduler.example.com/callback"},
+		"code_verifier": {verifier},
+	}
+	w = sendOAuthTokenRequest(cfg, app, codeParams)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "code exchange before reuse", t)
+
+	tokens = OAuthTokenResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&tokens)
+
+	w = sendOAuthTokenRequest(cfg, app, codeParams)
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "reused code", t)
+
+	_, err = postChirp(cfg, tokens.AccessToken, "Posted with a token from a reused code")
+	if err == nil {
+		t.Error(formatTestError("post chirp with token from reused code", err, "error"))
+	}
+}
+
+// Sends the request with the bearer token, and a JSON body if not empty
+func sendBearerRequest(handler http.HandlerFunc, method, target, bearerToken, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Add("Authorization", "Bearer "+bearerToken)
+	w := httptest.NewRecorder()
+	handler(w, request)
+	return w
+}
+
+// Approves or denies the authorization request, returns the URL the browser is sent back to
+func approveOAuthConsent(cfg *apiConfig, userToken string, params url.Values, approve bool, t *testing.T) *url.URL {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"response_type": %q, "client_id": %q, "redirect_uri": %q, "scope": %q, "state": %q, "code_challenge": %q, "code_challenge_method": %q, "approve": %v}`,
+		params.Get("response_type"), params.Get("client_id"), params.Get("redirect_uri"), params.Get("scope"),
+		params.Get("state"), params.Get("code_challenge"), params.Get("code_challenge_method"), approve)
+
+	w := sendBearerRequest(cfg.postOAuthConsentHandler(), "POST", "/api/oauth/authorize", userToken, body)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "consent", t)
+
+	response := OAuthAuthorizeResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&response)
+
+	redirectTo, err := url.Parse(response.RedirectTo)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	return redirectTo
+}
+
+// Calls the token endpoint as the app, authenticating with HTTP Basic auth
+func sendOAuthTokenRequest(cfg *apiConfig, app OAuthApp, params url.Values) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/api/oauth/token", strings.NewReader(params.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(app.ClientID.String(), app.ClientSecret)
+	w := httptest.NewRecorder()
+	cfg.oauthTokenHandler()(w, request)
+	return w
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+// "Sign in with" external OpenID Connect providers
+// Authorization code flow with PKCE: https://openid.net/specs/openid-connect-core-1_0.html#CodeFlowAuth
+//  1. GET /api/oidc/{provider}/login redirects the browser to the provider
+//  2. The provider redirects back to GET /api/oidc/{provider}/callback with a code,
+//     exchanged for an ID token that identifies the user
+//
+// Logged-in users link a provider the same way, starting with POST /api/oidc/{provider}/link
+const (
+	OIDC_LOGIN_STATE_DURATION = 10 * time.Minute
+
+	// Ties the callback to the browser that started the login
+	OIDC_STATE_COOKIE = "chirpy_oidc_state"
+)
+
+// hashed_password of users created by a provider login, never matches a password so POST /api/login can't be used
+const PASSWORD_UNSET = "unset"
+
+var (
+	errOIDCEmailRequired  = errors.New("provider did not return an email address")
+	errOIDCEmailInUse     = errors.New("email belongs to an existing account that must link the provider while logged in")
+	errOIDCIdentityLinked = errors.New("provider account is linked to another user")
+)
+
+// Returned by POST /api/oidc/{provider}/link, the browser is sent to the provider to finish linking
+type OIDCLinkResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// Settings for one provider, from OIDC_PROVIDERS
+// The provider's endpoints and signing keys are discovered on first use from <issuer>/.well-known/openid-configuration
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// ID token claims used to find or create the Chirpy user
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Redirects to the provider's login page
+func (cfg *apiConfig) oidcLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+		if !ok {
+			sendErrorJSONResponse(w, "Unknown login provider", http.StatusNotFound, nil)
+			return
+		}
+
+		authURL, ok := cfg.startOIDCFlow(w, r, provider, uuid.NullUUID{})
+		if !ok {
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// Starts linking a provider to the logged-in user, returns the provider's login page to send the browser to
+// Logins only link to existing users without a password, otherwise anyone who registered the email first would share the account
+func (cfg *apiConfig) oidcLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+		if !ok {
+			sendErrorJSONResponse(w, "Unknown login provider", http.StatusNotFound, nil)
+			return
+		}
+
+		authURL, ok := cfg.startOIDCFlow(w, r, provider, uuid.NullUUID{UUID: userID, Valid: true})
+		if !ok {
+			return
+		}
+
+		SendJSONResponse(w, http.StatusOK, OIDCLinkResponse{RedirectTo: authURL})
+	}
+}
+
+// Saves the flow until the callback and sets the state cookie, returns the provider's authorization URL
+// `linkUserID` is set when a logged-in user is linking the provider
+// Sends the error response and returns false if the flow can't be started
+func (cfg *apiConfig) startOIDCFlow(w http.ResponseWriter, r *http.Request, provider *oidcProvider, linkUserID uuid.NullUUID) (string, bool) {
+	state, err := auth.MakeOpaqueToken("")
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return "", false
+	}
+
+	nonce, err := auth.MakeOpaqueToken("")
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return "", false
+	}
+
+	codeVerifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.authCodeURL(r.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		sendErrorJSONResponse(w, "Login provider unavailable", http.StatusBadGateway, err)
+		return "", false
+	}
+
+	// Save the flow until the callback, clearing out abandoned ones
+	err = cfg.db.DeleteExpiredOIDCLoginStates(r.Context(), time.Now())
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return "", false
+	}
+
+	err = cfg.db.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		State:        state,
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(OIDC_LOGIN_STATE_DURATION),
+		Provider:     provider.name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       linkUserID,
+	})
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return "", false
+	}
+
+	// Lax, so the cookie is sent on the provider's top-level redirect back
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(OIDC_LOGIN_STATE_DURATION.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return authURL, true
+}
+
+// Finishes the provider login, responds like POST /api/login
+func (cfg *apiConfig) oidcCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+		if !ok {
+			sendErrorJSONResponse(w, "Unknown login provider", http.StatusNotFound, nil)
+			return
+		}
+
+		// User cancelled, or the provider refused
+		query := r.URL.Query()
+		if providerErr := query.Get("error"); providerErr != "" {
+			sendErrorJSONResponse(w, "Login was not completed", http.StatusUnauthorized, fmt.Errorf("%v login error: %v: %v", provider.name, providerErr, query.Get("error_description")))
+			return
+		}
+
+		state := query.Get("state")
+		code := query.Get("code")
+		if state == "" || code == "" {
+			sendErrorJSONResponse(w, "State and code required", http.StatusBadRequest, nil)
+			return
+		}
+
+		// State must match the browser's cookie, so an attacker can't log a victim into the attacker's account
+		stateCookie, err := r.Cookie(OIDC_STATE_COOKIE)
+		if err != nil || stateCookie.Value != state {
+			sendErrorJSONResponse(w, "Invalid login state", http.StatusUnauthorized, fmt.Errorf("%v login state does not match cookie", provider.name))
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     OIDC_STATE_COOKIE,
+			Path:     "/api/oidc/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		loginState, err := cfg.db.ConsumeOIDCLoginState(r.Context(), database.ConsumeOIDCLoginStateParams{
+			State:    state,
+			Provider: provider.name,
+		})
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Invalid login state", http.StatusUnauthorized, fmt.Errorf("%v login state not found", provider.name))
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		if loginState.ExpiresAt.Before(time.Now()) {
+			sendErrorJSONResponse(w, "Login expired, try again", http.StatusUnauthorized, fmt.Errorf("%v login state expired at %v", provider.name, loginState.ExpiresAt))
+			return
+		}
+
+		// Exchange code for the ID token
+		claims, err := provider.exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+		if err != nil {
+			recordLoginMetric(LOGIN_METHOD_OIDC, LOGIN_FAILURE)
+			sendErrorJSONResponse(w, "Login failed", http.StatusUnauthorized, err)
+			return
+		}
+
+		var user database.User
+		if loginState.UserID.Valid {
+			user, err = cfg.linkOIDCIdentity(r.Context(), provider.name, claims, loginState.UserID.UUID)
+		} else {
+			user, err = cfg.findOrCreateOIDCUser(r.Context(), provider.name, claims)
+		}
+		if errors.Is(err, errOIDCEmailRequired) {
+			sendErrorJSONResponse(w, "Login provider did not share an email address", http.StatusBadRequest, err)
+			return
+		}
+		if errors.Is(err, errOIDCEmailInUse) {
+			sendErrorJSONResponse(w, "An account with this email already exists, log in with your password and link this provider", http.StatusConflict, err)
+			return
+		}
+		if errors.Is(err, errOIDCIdentityLinked) {
+			sendErrorJSONResponse(w, "This provider account is linked to another user", http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Two-factor authentication still applies
+		if user.TotpEnabled {
+			recordLoginMetric(LOGIN_METHOD_OIDC, LOGIN_MFA_REQUIRED)
+			cfg.sendMFAChallengeResponse(w, user.ID)
+			return
+		}
+
+		// Response
+		recordLoginMetric(LOGIN_METHOD_OIDC, LOGIN_SUCCESS)
+		loginResponse, err := cfg.createLoginResponse(r.Context(), user)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		SendJSONResponse(w, http.StatusOK, loginResponse)
+	}
+}
+
+// Returns the user linked to the provider account
+// First logins are linked to the existing user with the same email only if the provider verified it and the user has no password,
+// since Chirpy never verified the email of users with a password (ex: someone else registered it first)
+// Otherwise a new user without a password is created
+func (cfg *apiConfig) findOrCreateOIDCUser(ctx context.Context, providerName string, claims oidcClaims) (database.User, error) {
+	user, err := cfg.db.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Provider: providerName,
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return database.User{}, err
+	}
+
+	if claims.Email == "" {
+		return database.User{}, errOIDCEmailRequired
+	}
+
+	created := false
+	user, err = cfg.users.GetUserByEmail(ctx, claims.Email)
+	if err == sql.ErrNoRows {
+		created = true
+		user, err = cfg.users.CreateUser(ctx, database.CreateUserParams{
+			ID:             uuid.New(),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+			Email:          claims.Email,
+			HashedPassword: PASSWORD_UNSET,
+		})
+	} else if err == nil && (!claims.EmailVerified || user.HashedPassword != PASSWORD_UNSET) {
+		return database.User{}, errOIDCEmailInUse
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserID:    user.ID,
+		Provider:  providerName,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if created {
+		cfg.publishWebhookEvent(ctx, WEBHOOK_EVENT_USER_CREATED, User{
+			ID:          user.ID,
+			Email:       user.Email,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+			IsChirpyRed: user.IsChirpyRed,
+			Role:        user.Role,
+		})
+	}
+
+	return user, nil
+}
+
+// Links the provider account to the logged-in user who started the flow, returns the user
+// Returns errOIDCIdentityLinked if the provider account is already linked to someone else
+func (cfg *apiConfig) linkOIDCIdentity(ctx context.Context, providerName string, claims oidcClaims, userID uuid.UUID) (database.User, error) {
+	linkedUser, err := cfg.db.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Provider: providerName,
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		if linkedUser.ID != userID {
+			return database.User{}, errOIDCIdentityLinked
+		}
+		return linkedUser, nil
+	}
+	if err != sql.ErrNoRows {
+		return database.User{}, err
+	}
+
+	user, err := cfg.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = cfg.db.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		UserID:    user.ID,
+		Provider:  providerName,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, nil
+}
+
+// Returns the discovered provider, retrying discovery on later calls if it failed
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%v discovery failed: %w", p.name, err)
+	}
+
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *oidcProvider) oauth2Config(provider *oidc.Provider) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       []string{oidc.ScopeOpenID, "email"},
+	}
+}
+
+// Returns the provider's authorization URL, with the PKCE challenge for the verifier
+func (p *oidcProvider) authCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	config := p.oauth2Config(provider)
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchanges the authorization code for an ID token, and returns its claims once verified:
+// signed by a key in the provider's JWKS, issued by the provider, for this client, unexpired, with the login's nonce
+func (p *oidcProvider) exchange(ctx context.Context, code, codeVerifier, nonce string) (oidcClaims, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	config := p.oauth2Config(provider)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return oidcClaims{}, fmt.Errorf("%v code exchange failed: %w", p.name, err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return oidcClaims{}, fmt.Errorf("%v token response missing id_token", p.name)
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.clientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return oidcClaims{}, fmt.Errorf("%v id_token invalid: %w", p.name, err)
+	}
+
+	if idToken.Nonce != nonce {
+		return oidcClaims{}, fmt.Errorf("%v id_token nonce does not match", p.name)
+	}
+
+	claims := oidcClaims{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+
+	return claims, nil
+}
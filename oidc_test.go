@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func TestOIDCExchangeWithFakeProvider(t *testing.T) {
+	fake := newFakeOIDCProvider(t, "chirpy-client")
+	defer fake.server.Close()
+	fake.setUser("subject-1", "someone@email.com", true)
+
+	provider := fake.chirpyProvider("chirpy-client")
+
+	// Successful login
+	code, err := fake.authorize(provider, "state-1", "nonce-1", "verifier-1-verifier-1-verifier-1-verifier-1-xx")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	claims, err := provider.exchange(context.Background(), code, "verifier-1-verifier-1-verifier-1-verifier-1-xx", "nonce-1")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	assertEquals(claims, oidcClaims{Subject: "subject-1", Email: "someone@email.com", EmailVerified: true}, "id_token claims", t)
+
+	cases := []struct {
+		name             string
+		provider         *oidcProvider
+		verifier         string
+		exchangeVerifier string
+		nonce            string
+		exchangeNonce    string
+		rotateKey        bool
+	}{
+		{
+			name:             "Wrong PKCE verifier",
+			provider:         provider,
+			verifier:         oauth2.GenerateVerifier(),
+			exchangeVerifier: oauth2.GenerateVerifier(),
+			nonce:            "nonce-2",
+			exchangeNonce:    "nonce-2",
+		},
+		{
+			name:             "Wrong nonce",
+			provider:         provider,
+			verifier:         "verifier-3-verifier-3-verifier-3-verifier-3-xx",
+			exchangeVerifier: "verifier-3-verifier-3-verifier-3-verifier-3-xx",
+			nonce:            "nonce-3",
+			exchangeNonce:    "other-nonce",
+		},
+		{
+			name:             "ID token for a different client",
+			provider:         fake.chirpyProvider("other-client"),
+			verifier:         "verifier-4-verifier-4-verifier-4-verifier-4-xx",
+			exchangeVerifier: "verifier-4-verifier-4-verifier-4-verifier-4-xx",
+			nonce:            "nonce-4",
+			exchangeNonce:    "nonce-4",
+		},
+		{
+			name:             "ID token signed by a key not in the JWKS",
+			provider:         provider,
+			verifier:         "verifier-5-verifier-5-verifier-5-verifier-5-xx",
+			exchangeVerifier: "verifier-5-verifier-5-verifier-5-verifier-5-xx",
+			nonce:            "nonce-5",
+			exchangeNonce:    "nonce-5",
+			rotateKey:        true,
+		},
+	}
+
+	for _, c := range cases {
+		code, err := fake.authorize(c.provider, "state", c.nonce, c.verifier)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if c.rotateKey {
+			fake.signWithUnpublishedKey(t)
+		}
+
+		_, err = c.provider.exchange(context.Background(), code, c.exchangeVerifier, c.exchangeNonce)
+		if err == nil {
+			t.Error(formatTestError(c.name, err, "error"))
+		}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	fake := newFakeOIDCProvider(t, "chirpy-client")
+	defer fake.server.Close()
+	cfg.oidcProviders = map[string]*oidcProvider{"fake": fake.chirpyProvider("chirpy-client")}
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// New user
+	fake.setUser("new-subject", "new_user@email.com", true)
+	w := completeOIDCLogin(t, cfg, fake, true)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "new user", t)
+
+	newUser := LoginResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&newUser)
+	assertEquals(newUser.Email, "new_user@email.com", "new user email", t)
+	if newUser.Token == "" || newUser.RefreshToken == "" {
+		t.Error(formatTestError("new user tokens", newUser, "token and refresh token"))
+	}
+
+	// Same provider account logs into the same user, even if its email changed
+	fake.setUser("new-subject", "changed@email.com", true)
+	w = completeOIDCLogin(t, cfg, fake, true)
+	returningUser := LoginResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&returningUser)
+	assertEquals(returningUser.ID, newUser.ID, "returning user", t)
+
+	// OIDC-only users can't log in with a password
+	w = attemptLogin(cfg, "new_user@email.com", "unset", "192.0.2.1:1234")
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "password login for OIDC-only user", t)
+
+	// Unverified email matching an existing user isn't linked
+	fake.setUser("unverified-subject", users[0].Email, false)
+	w = completeOIDCLogin(t, cfg, fake, true)
+	assertEquals(w.Result().StatusCode, http.StatusConflict, "unverified email of existing user", t)
+
+	// Verified email isn't linked to an existing user with a password either, they may not own the email
+	fake.setUser("verified-subject", users[0].Email, true)
+	w = completeOIDCLogin(t, cfg, fake, true)
+	assertEquals(w.Result().StatusCode, http.StatusConflict, "verified email of existing user with a password", t)
+
+	// Verified email is linked to an existing user without a password, whose email another provider verified
+	fake.setUser("second-provider-subject", "new_user@email.com", true)
+	w = completeOIDCLogin(t, cfg, fake, true)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "verified email of existing user without a password", t)
+
+	linkedUser := LoginResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&linkedUser)
+	assertEquals(linkedUser.ID, newUser.ID, "linked user without a password", t)
+
+	// Users with a password link the provider while logged in
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	fake.setUser("verified-subject", users[0].Email, true)
+	w = completeOIDCLink(t, cfg, fake, loggedInUser.Token)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "link", t)
+
+	linkedUser = LoginResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&linkedUser)
+	assertEquals(linkedUser.ID, users[0].ID, "linked user", t)
+
+	w = completeOIDCLogin(t, cfg, fake, true)
+	linkedUser = LoginResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&linkedUser)
+	assertEquals(linkedUser.ID, users[0].ID, "login after link", t)
+
+	// Provider accounts can only be linked to one user
+	fake.setUser("new-subject", "changed@email.com", true)
+	w = completeOIDCLink(t, cfg, fake, loggedInUser.Token)
+	assertEquals(w.Result().StatusCode, http.StatusConflict, "link provider account of another user", t)
+
+	// Linking requires a login
+	request := httptest.NewRequest("POST", "/api/oidc/fake/link", nil)
+	request.SetPathValue("provider", "fake")
+	w = httptest.NewRecorder()
+	cfg.oidcLinkHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "link without login", t)
+
+	// Callback from a different browser than the one that started the login
+	w = completeOIDCLogin(t, cfg, fake, false)
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "missing state cookie", t)
+
+	// Unknown provider
+	request = httptest.NewRequest("GET", "/api/oidc/unknown/login", nil)
+	request.SetPathValue("provider", "unknown")
+	w = httptest.NewRecorder()
+	cfg.oidcLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusNotFound, "unknown provider", t)
+}
+
+// Runs GET /api/oidc/fake/login, the provider's login page, then GET /api/oidc/fake/callback
+func completeOIDCLogin(t *testing.T, cfg *apiConfig, fake *fakeOIDCProvider, sendCookie bool) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest("GET", "/api/oidc/fake/login", nil)
+	request.SetPathValue("provider", "fake")
+	w := httptest.NewRecorder()
+	cfg.oidcLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusFound, "login redirect", t)
+
+	cookies := []*http.Cookie{}
+	if sendCookie {
+		cookies = w.Result().Cookies()
+	}
+	return finishOIDCFlow(t, cfg, fake, w.Result().Header.Get("Location"), cookies)
+}
+
+// Runs POST /api/oidc/fake/link for the logged-in user, the provider's login page, then GET /api/oidc/fake/callback
+func completeOIDCLink(t *testing.T, cfg *apiConfig, fake *fakeOIDCProvider, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest("POST", "/api/oidc/fake/link", nil)
+	request.SetPathValue("provider", "fake")
+	request.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.oidcLinkHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "link start", t)
+
+	link := OIDCLinkResponse{}
+	json.NewDecoder(w.Result().Body).Decode(&link)
+	return finishOIDCFlow(t, cfg, fake, link.RedirectTo, w.Result().Cookies())
+}
+
+// Follows the provider's login page back to GET /api/oidc/fake/callback, with the cookies from the start of the flow
+func finishOIDCFlow(t *testing.T, cfg *apiConfig, fake *fakeOIDCProvider, authURL string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	callbackURL, err := fake.followAuthorizeRedirect(authURL)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	request := httptest.NewRequest("GET", callbackURL, nil)
+	request.SetPathValue("provider", "fake")
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	cfg.oidcCallbackHandler()(w, request)
+
+	return w
+}
+
+// Minimal OpenID Connect provider: discovery, authorization (auto-approves), token and JWKS endpoints
+type fakeOIDCProvider struct {
+	server   *httptest.Server
+	clientID string // ID tokens are always issued for this client
+
+	mu            sync.Mutex
+	key           *rsa.PrivateKey // Published in the JWKS
+	signingKey    *rsa.PrivateKey // Signs ID tokens, normally the same as key
+	subject       string
+	email         string
+	emailVerified bool
+	codes         map[string]fakeOIDCCode
+}
+
+type fakeOIDCCode struct {
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+func newFakeOIDCProvider(t *testing.T, clientID string) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	fake := &fakeOIDCProvider{
+		clientID:   clientID,
+		key:        key,
+		signingKey: key,
+		codes:      map[string]fakeOIDCCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", fake.discoveryHandler)
+	mux.HandleFunc("GET /authorize", fake.authorizeHandler)
+	mux.HandleFunc("POST /token", fake.tokenHandler)
+	mux.HandleFunc("GET /jwks", fake.jwksHandler)
+	fake.server = httptest.NewServer(mux)
+
+	return fake
+}
+
+// Chirpy's settings for the fake provider
+func (f *fakeOIDCProvider) chirpyProvider(clientID string) *oidcProvider {
+	return &oidcProvider{
+		name:         "fake",
+		issuer:       f.server.URL,
+		clientID:     clientID,
+		clientSecret: "client-secret",
+		redirectURL:  "http://localhost:8080/api/oidc/fake/callback",
+	}
+}
+
+// The account that logs in at the provider
+func (f *fakeOIDCProvider) setUser(subject, email string, emailVerified bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subject = subject
+	f.email = email
+	f.emailVerified = emailVerified
+}
+
+func (f *fakeOIDCProvider) signWithUnpublishedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signingKey = key
+}
+
+// Returns an authorization code, as if the browser went to the provider's login page
+func (f *fakeOIDCProvider) authorize(provider *oidcProvider, state, nonce, codeVerifier string) (string, error) {
+	authURL, err := provider.authCodeURL(context.Background(), state, nonce, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	callbackURL, err := f.followAuthorizeRedirect(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	parsedURL, err := url.Parse(callbackURL)
+	if err != nil {
+		return "", err
+	}
+
+	return parsedURL.Query().Get("code"), nil
+}
+
+// Requests the authorization URL, returns the callback URL it redirects to
+func (f *fakeOIDCProvider) followAuthorizeRedirect(authURL string) (string, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		return "", fmt.Errorf("authorize returned %v", res.StatusCode)
+	}
+
+	return res.Header.Get("Location"), nil
+}
+
+func (f *fakeOIDCProvider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	SendJSONResponse(w, http.StatusOK, map[string]any{
+		"issuer":                                f.server.URL,
+		"authorization_endpoint":                f.server.URL + "/authorize",
+		"token_endpoint":                        f.server.URL + "/token",
+		"jwks_uri":                              f.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (f *fakeOIDCProvider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	code := fmt.Sprintf("code-%v", len(f.codes))
+	f.codes[code] = fakeOIDCCode{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		subject:       f.subject,
+		email:         f.email,
+		emailVerified: f.emailVerified,
+	}
+	f.mu.Unlock()
+
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirectQuery := redirectURL.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURL.RawQuery = redirectQuery.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (f *fakeOIDCProvider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	f.mu.Lock()
+	code, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	signingKey := f.signingKey
+	f.mu.Unlock()
+
+	if !ok {
+		SendJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	// PKCE: https://datatracker.ietf.org/doc/html/rfc7636#section-4.6
+	verifierHash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifierHash[:]) != code.codeChallenge {
+		SendJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            f.clientID,
+		"sub":            code.subject,
+		"email":          code.email,
+		"email_verified": code.emailVerified,
+		"nonce":          code.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	idToken.Header["kid"] = "fake-key"
+
+	signedIDToken, err := idToken.SignedString(signingKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	SendJSONResponse(w, http.StatusOK, map[string]any{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signedIDToken,
+	})
+}
+
+func (f *fakeOIDCProvider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	SendJSONResponse(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "fake-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			},
+		},
+	})
+}
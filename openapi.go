@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// OpenAPI 3.1 description of the /api endpoints, served at GET /api/openapi.json
+// Kept in sync with the handlers by openapi_test.go, which checks every route is described and responses match it
+//
+//go:embed api/openapi.json
+var openAPIJSON []byte
+
+// Base for the spec's JSON Schema locations, nothing is fetched from it
+const OPENAPI_SCHEMA_URL = "urn:chirpy:openapi.json"
+
+// Operations that opt out of request validation, ex: signed webhooks, whose handler checks the signature over the raw body first
+const OPENAPI_SKIP_VALIDATION = "x-skip-request-validation"
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "patch"}
+
+// The spec's operations, by ServeMux pattern, ex: "GET /api/chirps/{chirpID}"
+type openAPISpec struct {
+	operations map[string]*openAPIOperation
+}
+
+type openAPIOperation struct {
+	parameters      []openAPIParameter
+	requestBody     *jsonschema.Schema // JSON request bodies only, nil otherwise
+	skipValidation  bool
+	responseSchemas map[string]map[string]*jsonschema.Schema // Status code (or "default"), then content type
+}
+
+type openAPIParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"` // "path" or "query"
+	Required bool   `json:"required"`
+	schema   *jsonschema.Schema
+}
+
+// Parses the embedded spec and compiles its schemas, so a broken spec fails at startup
+func loadOpenAPISpec() (*openAPISpec, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(openAPIJSON))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI spec: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	err = compiler.AddResource(OPENAPI_SCHEMA_URL, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Same document, decoded for walking the paths and resolving $refs to parameters and responses
+	raw := map[string]any{}
+	err = json.Unmarshal(openAPIJSON, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, _ := raw["paths"].(map[string]any)
+	spec := &openAPISpec{operations: map[string]*openAPIOperation{}}
+	for path, pathItem := range paths {
+		pathItem, _ := pathItem.(map[string]any)
+		for _, method := range openAPIMethods {
+			operation, ok := pathItem[method].(map[string]any)
+			if !ok {
+				continue
+			}
+
+			pointer := "#/paths/" + escapeJSONPointer(path) + "/" + method
+			compiled, err := compileOpenAPIOperation(compiler, raw, pointer, operation)
+			if err != nil {
+				return nil, fmt.Errorf("%v %v: %w", strings.ToUpper(method), path, err)
+			}
+			spec.operations[strings.ToUpper(method)+" "+path] = compiled
+		}
+	}
+
+	return spec, nil
+}
+
+func compileOpenAPIOperation(compiler *jsonschema.Compiler, raw map[string]any, pointer string, operation map[string]any) (*openAPIOperation, error) {
+	compiled := &openAPIOperation{responseSchemas: map[string]map[string]*jsonschema.Schema{}}
+	compiled.skipValidation, _ = operation[OPENAPI_SKIP_VALIDATION].(bool)
+
+	parameters, _ := operation["parameters"].([]any)
+	for i, parameter := range parameters {
+		parameterPointer, parameter := resolveOpenAPIRef(raw, fmt.Sprintf("%v/parameters/%v", pointer, i), parameter)
+
+		compiledParameter := openAPIParameter{}
+		data, _ := json.Marshal(parameter)
+		err := json.Unmarshal(data, &compiledParameter)
+		if err != nil {
+			return nil, err
+		}
+
+		compiledParameter.schema, err = compiler.Compile(OPENAPI_SCHEMA_URL + parameterPointer + "/schema")
+		if err != nil {
+			return nil, err
+		}
+		compiled.parameters = append(compiled.parameters, compiledParameter)
+	}
+
+	requestBodyPointer, requestBody := resolveOpenAPIRef(raw, pointer+"/requestBody", operation["requestBody"])
+	if content, ok := requestBody["content"].(map[string]any); ok {
+		if _, ok := content["application/json"]; ok {
+			schema, err := compiler.Compile(OPENAPI_SCHEMA_URL + requestBodyPointer + "/content/application~1json/schema")
+			if err != nil {
+				return nil, err
+			}
+			compiled.requestBody = schema
+		}
+	}
+
+	responses, _ := operation["responses"].(map[string]any)
+	for status, response := range responses {
+		responsePointer, response := resolveOpenAPIRef(raw, pointer+"/responses/"+status, response)
+
+		compiled.responseSchemas[status] = map[string]*jsonschema.Schema{}
+		content, _ := response["content"].(map[string]any)
+		for contentType := range content {
+			schema, err := compiler.Compile(OPENAPI_SCHEMA_URL + responsePointer + "/content/" + escapeJSONPointer(contentType) + "/schema")
+			if err != nil {
+				return nil, err
+			}
+			compiled.responseSchemas[status][contentType] = schema
+		}
+	}
+
+	return compiled, nil
+}
+
+// Follows a local $ref, ex: {"$ref": "#/components/parameters/ChirpID"}, returns where the object is and the object
+func resolveOpenAPIRef(raw map[string]any, pointer string, value any) (string, map[string]any) {
+	object, _ := value.(map[string]any)
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return pointer, object
+	}
+
+	var target any = raw
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		targetObject, _ := target.(map[string]any)
+		target = targetObject[strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")]
+	}
+	return resolveOpenAPIRef(raw, ref, target)
+}
+
+// https://datatracker.ietf.org/doc/html/rfc6901#section-3
+func escapeJSONPointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIJSON)
+}
+
+// Rejects requests that don't match the spec before they reach the handler:
+// invalid path parameters are 404 (nothing has that ID), invalid query parameters 400,
+// and JSON bodies are checked like decodeJSONBody, 400 for malformed JSON or wrong types, 422 for missing or invalid fields
+// Routes the spec doesn't describe, ex: /admin/, are passed through
+func middlewareValidateRequest(spec *openAPISpec, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		// Set here too, since rejected requests never reach the mux, for the metrics, log and span route
+		r.Pattern = pattern
+		operation, ok := spec.operations[pattern]
+		if !ok || operation.skipValidation {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		pathValues := matchPathPattern(pattern, r.URL.EscapedPath())
+		query := r.URL.Query()
+		for _, parameter := range operation.parameters {
+			value, present := pathValues[parameter.Name], true
+			if parameter.In == "query" {
+				value, present = query.Get(parameter.Name), query.Has(parameter.Name)
+			}
+
+			if !present {
+				if parameter.Required {
+					sendErrorJSONResponse(w, parameter.Name+" is required", http.StatusBadRequest, nil)
+					return
+				}
+				continue
+			}
+
+			err := parameter.schema.Validate(value)
+			if err != nil && parameter.In == "path" {
+				sendErrorJSONResponse(w, "Not found", http.StatusNotFound, fmt.Errorf("invalid %v %q: %w", parameter.Name, value, err))
+				return
+			}
+			if err != nil {
+				msg := fieldErrorsFromSchema(parameter.Name, err)[0].Message
+				sendErrorJSONResponse(w, msg, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if operation.requestBody != nil && !validateJSONBody(w, r, operation.requestBody) {
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Path parameter values, by name, from a request path that matched the pattern
+func matchPathPattern(pattern, escapedPath string) map[string]string {
+	_, patternPath, _ := strings.Cut(pattern, " ")
+	patternSegments := strings.Split(patternPath, "/")
+	pathSegments := strings.Split(escapedPath, "/")
+
+	values := map[string]string{}
+	for i, segment := range patternSegments {
+		if i >= len(pathSegments) || !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		value, err := url.PathUnescape(pathSegments[i])
+		if err != nil {
+			value = pathSegments[i]
+		}
+		values[strings.Trim(segment, "{}")] = value
+	}
+	return values
+}
+
+// Checks the body against the schema, and puts it back for the handler
+// Otherwise sends a problem response and returns false
+func validateJSONBody(w http.ResponseWriter, r *http.Request, schema *jsonschema.Schema) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_JSON_BODY_BYTES))
+	maxBytesErr := &http.MaxBytesError{}
+	if errors.As(err, &maxBytesErr) {
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_PAYLOAD_TOO_LARGE,
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("Request body must be %v bytes or smaller", maxBytesErr.Limit),
+		}, nil)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Only the first JSON value, like decodeJSONBody
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	err = decoder.Decode(&value)
+	if err == nil {
+		err = schema.Validate(value)
+	}
+	if err == nil {
+		return true
+	}
+
+	validationErr := &jsonschema.ValidationError{}
+	if !errors.As(err, &validationErr) {
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_INVALID_JSON,
+			Status: http.StatusBadRequest,
+			Detail: "Request body must be a JSON object",
+		}, err)
+		return false
+	}
+
+	// Wrong JSON types are malformed requests, the rest are invalid fields
+	if typeErr := findSchemaTypeError(validationErr); typeErr != nil {
+		fieldErrors := fieldErrorsFromSchema("", typeErr)
+		if fieldErrors[0].Field == "" {
+			sendProblemResponse(w, Problem{
+				Type:   PROBLEM_INVALID_JSON,
+				Status: http.StatusBadRequest,
+				Detail: "Request body must be a JSON object",
+			}, err)
+			return false
+		}
+
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_INVALID_JSON,
+			Status: http.StatusBadRequest,
+			Detail: fieldErrors[0].Message,
+			Errors: fieldErrors,
+		}, err)
+		return false
+	}
+
+	sendValidationErrorResponse(w, fieldErrorsFromSchema("", validationErr)...)
+	return false
+}
+
+// First failure caused by a value of the wrong JSON type, nil if there isn't one
+func findSchemaTypeError(validationErr *jsonschema.ValidationError) *jsonschema.ValidationError {
+	if _, ok := validationErr.ErrorKind.(*kind.Type); ok {
+		return validationErr
+	}
+	for _, cause := range validationErr.Causes {
+		if typeErr := findSchemaTypeError(cause); typeErr != nil {
+			return typeErr
+		}
+	}
+	return nil
+}
+
+// One field error per failed schema keyword, ex: a missing required property
+// `field` prefixes the error locations, for parameters
+func fieldErrorsFromSchema(field string, err error) []FieldError {
+	validationErr := &jsonschema.ValidationError{}
+	if !errors.As(err, &validationErr) {
+		return []FieldError{{Field: field, Code: FIELD_INVALID, Message: fmt.Sprintf("%v is invalid", field)}}
+	}
+
+	if len(validationErr.Causes) > 0 {
+		fieldErrors := []FieldError{}
+		for _, cause := range validationErr.Causes {
+			fieldErrors = append(fieldErrors, fieldErrorsFromSchema(field, cause)...)
+		}
+		return fieldErrors
+	}
+
+	location := append([]string{}, validationErr.InstanceLocation...)
+	if field != "" {
+		location = append([]string{field}, location...)
+	}
+	name := strings.Join(location, ".")
+
+	switch errorKind := validationErr.ErrorKind.(type) {
+	case *kind.Required:
+		fieldErrors := []FieldError{}
+		for _, missing := range errorKind.Missing {
+			missingName := strings.Join(append(location, missing), ".")
+			fieldErrors = append(fieldErrors, FieldError{Field: missingName, Code: FIELD_REQUIRED, Message: missingName + " is required"})
+		}
+		return fieldErrors
+	case *kind.Type:
+		return []FieldError{{Field: name, Code: FIELD_INVALID, Message: fmt.Sprintf("%v has the wrong type, got a JSON %v", name, errorKind.Got)}}
+	case *kind.Enum:
+		return []FieldError{{Field: name, Code: FIELD_UNKNOWN, Message: fmt.Sprintf("%v must be one of %v", name, errorKind.Want)}}
+	case *kind.Format:
+		return []FieldError{{Field: name, Code: FIELD_INVALID, Message: fmt.Sprintf("%v must be a valid %v", name, errorKind.Want)}}
+	default:
+		return []FieldError{{Field: name, Code: FIELD_INVALID, Message: fmt.Sprintf("%v is invalid", name)}}
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+func TestOpenAPISpecDescribesEveryRoute(t *testing.T) {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newMemoryApiConfig().routes(context.Background())
+
+	// Every operation in the spec is routed to a handler with the same pattern
+	for pattern := range spec.operations {
+		method, path, _ := strings.Cut(pattern, " ")
+		path = strings.NewReplacer("{chirpID}", uuid.NewString(), "{clientID}", uuid.NewString(), "{tokenID}", uuid.NewString(), "{provider}", "google").Replace(path)
+
+		_, routed := mux.Handler(httptest.NewRequest(method, path, nil))
+		assertEquals(routed, pattern, pattern, t)
+	}
+
+	// Every /api route registered in routes() is in the spec
+	registered := registeredAPIRoutes(t)
+	assertEquals(len(registered) > 0, true, "Registered /api routes", t)
+	for _, pattern := range registered {
+		if _, ok := spec.operations[pattern]; !ok {
+			t.Error(formatTestError(pattern, "missing from api/openapi.json", "described"))
+		}
+	}
+}
+
+// Patterns passed to mux.Handle and mux.HandleFunc in main.go, ex: "GET /api/chirps/{chirpID}"
+func registeredAPIRoutes(t *testing.T) []string {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patterns := []string{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (selector.Sel.Name != "Handle" && selector.Sel.Name != "HandleFunc") {
+			return true
+		}
+		if receiver, ok := selector.X.(*ast.Ident); !ok || receiver.Name != "mux" {
+			return true
+		}
+		literal, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			return true
+		}
+
+		pattern, err := strconv.Unquote(literal.Value)
+		if err == nil && strings.Contains(pattern, " /api/") {
+			patterns = append(patterns, pattern)
+		}
+		return true
+	})
+	return patterns
+}
+
+// Drift test: responses from the real handlers, for the endpoints that don't need Postgres, match the spec
+func TestOpenAPIResponsesMatchSpec(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newMemoryApiConfig()
+	handler := middlewareValidateRequest(spec, cfg.routes(context.Background()))
+
+	user, password := newTestUser(t, cfg)
+	tokens := newTestTokens(t, cfg, user.Email, password)
+	chirp := newTestChirp(t, cfg, tokens.Token, "Hello, world!")
+	otherUser, otherPassword := newTestUser(t, cfg)
+	otherTokens := newTestTokens(t, cfg, otherUser.Email, otherPassword)
+
+	cases := []struct {
+		pattern string
+		target  string
+		token   string
+		body    string
+	}{
+		{pattern: "GET /api/openapi.json"},
+		{pattern: "GET /api/healthz"},
+		{pattern: "GET /api/livez"},
+		{pattern: "POST /api/users", body: `{"email": "new_user@example.com", "password": "abc123-password"}`},
+		{pattern: "POST /api/users", body: `{"email": "new_user_2@example.com", "password": "short"}`},
+		{pattern: "POST /api/users", body: `{"email": "new_user_3@example.com"}`},
+		{pattern: "POST /api/users", body: `{"email": 1, "password": "abc123-password"}`},
+		{pattern: "PUT /api/users", token: tokens.Token, body: fmt.Sprintf(`{"email": "%v", "password": "%v"}`, user.Email, password)},
+		{pattern: "PUT /api/users", body: `{"email": "a@example.com", "password": "abc123-password"}`},
+		{pattern: "POST /api/users", body: fmt.Sprintf(`{"email": "%v", "password": "abc123-password"}`, user.Email)},
+		{pattern: "PUT /api/users", token: otherTokens.Token, body: fmt.Sprintf(`{"email": "%v", "password": "abc123-password"}`, user.Email)},
+		{pattern: "GET /api/users"},
+		{pattern: "POST /api/login", body: fmt.Sprintf(`{"email": "%v", "password": "%v"}`, user.Email, password)},
+		{pattern: "POST /api/login", body: fmt.Sprintf(`{"email": "%v", "password": "wrong-password"}`, user.Email)},
+		{pattern: "POST /api/chirps", token: tokens.Token, body: `{"body": "Another chirp"}`},
+		{pattern: "POST /api/chirps", body: `{"body": "Not logged in"}`},
+		{pattern: "GET /api/chirps"},
+		{pattern: "GET /api/chirps", target: "/api/chirps?sort=desc&author_id=" + user.ID.String()},
+		{pattern: "GET /api/chirps", target: "/api/chirps?author_id=not-a-uuid"},
+		{pattern: "GET /api/chirps/{chirpID}", target: "/api/chirps/" + chirp.ID.String()},
+		{pattern: "GET /api/chirps/{chirpID}", target: "/api/chirps/not-a-uuid"},
+		{pattern: "PUT /api/chirps/{chirpID}", target: "/api/chirps/" + chirp.ID.String(), token: tokens.Token, body: `{"body": "Free plans can't edit"}`},
+		{pattern: "DELETE /api/chirps/{chirpID}", target: "/api/chirps/" + chirp.ID.String(), token: tokens.Token},
+		{pattern: "POST /api/validate_chirp", body: `{"body": "This is a kerfuffle"}`},
+		{pattern: "POST /api/validate_chirp", body: fmt.Sprintf(`{"body": "%v"}`, strings.Repeat("a", 141))},
+		{pattern: "POST /api/refresh", token: tokens.RefreshToken},
+		{pattern: "POST /api/revoke", token: tokens.RefreshToken},
+		{pattern: "POST /api/refresh", token: tokens.RefreshToken},
+	}
+
+	for _, c := range cases {
+		method, target, _ := strings.Cut(c.pattern, " ")
+		if c.target != "" {
+			target = c.target
+		}
+
+		request := httptest.NewRequest(method, target, strings.NewReader(c.body))
+		if c.token != "" {
+			request.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		assertResponseMatchesSpec(spec, c.pattern, w, t)
+	}
+}
+
+// Drift test for the endpoints that need Postgres, ex: tokens, apps, passkeys, 2FA, subscriptions
+// Each step's response is checked against the spec, and the next steps use it (ex: the app's client ID)
+func TestOpenAPIResponsesMatchSpecPostgres(t *testing.T) {
+	t.Parallel()
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+	handler := middlewareValidateRequest(spec, cfg.routes(context.Background()))
+
+	user, password := newTestUser(t, cfg)
+	tokens := newTestTokens(t, cfg, user.Email, password)
+
+	// Sends the request through the routes, checks the response against the pattern's operation
+	send := func(pattern, target, token, body string, modify func(r *http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		method, path, _ := strings.Cut(pattern, " ")
+		if target == "" {
+			target = path
+		}
+
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		if modify != nil {
+			modify(request)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		assertResponseMatchesSpec(spec, pattern, w, t)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		err := json.Unmarshal(w.Body.Bytes(), v)
+		if err != nil {
+			t.Fatal(formatTestError("decode response", err, nil))
+		}
+	}
+
+	send("GET /api/readyz", "", "", "", nil)
+
+	// Personal access tokens
+	w := send("POST /api/tokens", "", tokens.Token, `{"name": "script", "scopes": ["chirps:write"]}`, nil)
+	personalAccessToken := PersonalAccessToken{}
+	decode(w, &personalAccessToken)
+	send("POST /api/tokens", "", tokens.Token, `{"name": "script", "scopes": ["admin"]}`, nil)
+	send("POST /api/tokens", "", "", `{"name": "script", "scopes": ["chirps:write"]}`, nil)
+	send("GET /api/tokens", "", tokens.Token, "", nil)
+	send("GET /api/tokens", "", "", "", nil)
+	send("DELETE /api/tokens/{tokenID}", "/api/tokens/"+personalAccessToken.ID.String(), tokens.Token, "", nil)
+	send("DELETE /api/tokens/{tokenID}", "/api/tokens/"+personalAccessToken.ID.String(), tokens.Token, "", nil)
+
+	// Apps and the OAuth authorization code flow
+	w = send("POST /api/apps", "", tokens.Token, `{"name": "Chirp Scheduler", "redirect_uris": ["https://scheduler.example.com/callback"]}`, nil)
+	app := OAuthApp{}
+	decode(w, &app)
+	send("POST /api/apps", "", tokens.Token, `{"name": "Chirp Scheduler"}`, nil)
+	send("POST /api/apps", "", "", `{"name": "Chirp Scheduler", "redirect_uris": ["https://scheduler.example.com/callback"]}`, nil)
+
+	verifier := oauth2.GenerateVerifier()
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {app.ClientID.String()},
+		"redirect_uri":          {"https://scheduler.example.com/callback"},
+		"scope":                 {SCOPE_CHIRPS_WRITE},
+		"state":                 {"xyz"},
+		"code_challenge":        {oauth2.S256ChallengeFromVerifier(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	send("GET /api/oauth/authorize", "/api/oauth/authorize?"+authorizeParams.Encode(), tokens.Token, "", nil)
+	send("GET /api/oauth/authorize", "/api/oauth/authorize?client_id="+app.ClientID.String(), tokens.Token, "", nil)
+
+	consentBody := fmt.Sprintf(`{"response_type": "code", "client_id": %q, "redirect_uri": %q, "scope": %q, "state": "xyz", "code_challenge": %q, "code_challenge_method": "S256", "approve": true}`,
+		app.ClientID, authorizeParams.Get("redirect_uri"), SCOPE_CHIRPS_WRITE, authorizeParams.Get("code_challenge"))
+	w = send("POST /api/oauth/authorize", "", tokens.Token, consentBody, nil)
+	authorizeResponse := OAuthAuthorizeResponse{}
+	decode(w, &authorizeResponse)
+	redirectTo, err := url.Parse(authorizeResponse.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("POST /api/oauth/authorize", "", "", consentBody, nil)
+
+	asApp := func(clientSecret string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth(app.ClientID.String(), clientSecret)
+		}
+	}
+	codeExchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {redirectTo.Query().Get("code")},
+		"redirect_uri":  {authorizeParams.Get("redirect_uri")},
+		"code_verifier": {verifier},
+	}
+	send("POST /api/oauth/token", "", "", codeExchange.Encode(), asApp(app.ClientSecret))
+	send("POST /api/oauth/token", "", "", codeExchange.Encode(), asApp(app.ClientSecret))
+	send("POST /api/oauth/token", "", "", codeExchange.Encode(), asApp("chirpy_cs_wrong"))
+
+	send("GET /api/apps", "", tokens.Token, "", nil)
+	send("GET /api/apps", "", "", "", nil)
+	send("DELETE /api/apps/{clientID}/authorization", "/api/apps/"+app.ClientID.String()+"/authorization", tokens.Token, "", nil)
+	send("DELETE /api/apps/{clientID}/authorization", "/api/apps/"+app.ClientID.String()+"/authorization", tokens.Token, "", nil)
+
+	// Passkeys, with a software authenticator
+	authenticator, err := newSoftwareAuthenticator(cfg.webAuthn.Config.RPID, cfg.webAuthn.Config.RPOrigins[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = send("POST /api/passkeys/register/begin", "", tokens.Token, "", nil)
+	registration := struct {
+		SessionID uuid.UUID                   `json:"session_id"`
+		Options   protocol.CredentialCreation `json:"options"`
+	}{}
+	decode(w, &registration)
+	send("POST /api/passkeys/register/begin", "", "", "", nil)
+
+	attestation, err := authenticator.create(registration.Options.Response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrationFinish, _ := json.Marshal(passkeyFinishRequest{SessionID: registration.SessionID, Name: "Test authenticator", Credential: attestation})
+	send("POST /api/passkeys/register/finish", "", tokens.Token, string(registrationFinish), nil)
+	send("POST /api/passkeys/register/finish", "", tokens.Token, string(registrationFinish), nil)
+
+	w = send("POST /api/passkeys/login/begin", "", "", "", nil)
+	login := struct {
+		SessionID uuid.UUID                    `json:"session_id"`
+		Options   protocol.CredentialAssertion `json:"options"`
+	}{}
+	decode(w, &login)
+
+	assertion, err := authenticator.get(login.Options.Response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginFinish, _ := json.Marshal(passkeyFinishRequest{SessionID: login.SessionID, Credential: assertion})
+	send("POST /api/passkeys/login/finish", "", "", string(loginFinish), nil)
+	send("POST /api/passkeys/login/finish", "", "", string(loginFinish), nil)
+
+	// OIDC, no providers configured
+	send("GET /api/oidc/{provider}/login", "/api/oidc/google/login", "", "", nil)
+	send("GET /api/oidc/{provider}/callback", "/api/oidc/google/callback?state=xyz&code=abc", "", "", nil)
+	send("POST /api/oidc/{provider}/link", "/api/oidc/google/link", tokens.Token, "", nil)
+	send("POST /api/oidc/{provider}/link", "/api/oidc/google/link", "", "", nil)
+
+	// Two-factor authentication, for another user so the tokens above stay valid
+	twoFactorUser, twoFactorPassword := newTestUser(t, cfg)
+	twoFactorTokens := newTestTokens(t, cfg, twoFactorUser.Email, twoFactorPassword)
+
+	w = send("POST /api/2fa/enroll", "", twoFactorTokens.Token, "", nil)
+	enrollment := TOTPEnrollmentResponse{}
+	decode(w, &enrollment)
+	send("POST /api/2fa/enroll", "", "", "", nil)
+
+	confirmCode, err := auth.TOTPCode(enrollment.Secret, auth.TOTPStep(time.Now())-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("POST /api/2fa/confirm", "", twoFactorTokens.Token, `{"code": "000000"}`, nil)
+	send("POST /api/2fa/confirm", "", twoFactorTokens.Token, `{}`, nil)
+	send("POST /api/2fa/confirm", "", twoFactorTokens.Token, fmt.Sprintf(`{"code": %q}`, confirmCode), nil)
+
+	w = send("POST /api/login", "", "", fmt.Sprintf(`{"email": %q, "password": %q}`, twoFactorUser.Email, twoFactorPassword), nil)
+	challenge := MFAChallengeResponse{}
+	decode(w, &challenge)
+
+	loginCode, err := auth.TOTPCode(enrollment.Secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("POST /api/login/2fa", "", "", fmt.Sprintf(`{"challenge_token": %q, "code": "000000"}`, challenge.ChallengeToken), nil)
+	send("POST /api/login/2fa", "", "", `{"code": "000000"}`, nil)
+	send("POST /api/login/2fa", "", "", fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge.ChallengeToken, loginCode), nil)
+
+	// Subscriptions, updated by Polka
+	send("GET /api/subscription", "", tokens.Token, "", nil)
+	send("GET /api/subscription", "", "", "", nil)
+
+	polkaBody := fmt.Sprintf(`{"id": %q, "event": "user.upgraded", "data": {"user_id": %q}}`, uuid.NewString(), user.ID)
+	signed := func(signature string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set(POLKA_SIGNATURE_HEADER, signature) }
+	}
+	send("POST /api/polka/webhooks", "", "", polkaBody, signed("t=1,v1=abcd"))
+	send("POST /api/polka/webhooks", "", "", polkaBody, signed(webhook.Sign([]byte(polkaBody), time.Now(), "secret")))
+	send("GET /api/subscription", "", tokens.Token, "", nil)
+}
+
+// Fails the test if the status code, content type or body isn't described by the operation's responses
+func assertResponseMatchesSpec(spec *openAPISpec, pattern string, w *httptest.ResponseRecorder, t *testing.T) {
+	t.Helper()
+	name := fmt.Sprintf("%v (%v)", pattern, w.Code)
+
+	operation, ok := spec.operations[pattern]
+	if !ok {
+		t.Error(formatTestError(name, "no operation", "operation in api/openapi.json"))
+		return
+	}
+
+	schemas, ok := operation.responseSchemas[strconv.Itoa(w.Code)]
+	if !ok {
+		schemas, ok = operation.responseSchemas["default"]
+	}
+	if !ok {
+		t.Error(formatTestError(name, "undocumented status code", "documented response"))
+		return
+	}
+
+	if len(schemas) == 0 {
+		assertEquals(w.Body.Len(), 0, name+": body of a response without content", t)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Error(formatTestError(name, w.Header().Get("Content-Type"), "a content type"))
+		return
+	}
+	schema, ok := schemas[mediaType]
+	if !ok {
+		t.Error(formatTestError(name, mediaType, "documented content type"))
+		return
+	}
+
+	var body any = w.Body.String()
+	if strings.HasSuffix(mediaType, "json") {
+		decoder := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+		decoder.UseNumber()
+		err = decoder.Decode(&body)
+		if err != nil {
+			t.Error(formatTestError(name, err, "JSON body"))
+			return
+		}
+	}
+
+	if err := schema.Validate(body); err != nil {
+		t.Error(formatTestError(name, err, "body matching the spec"))
+	}
+}
+
+func TestMiddlewareValidateRequest(t *testing.T) {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Echoes the body, to check it's still readable after validation
+	mux := http.NewServeMux()
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, r.Body)
+	}
+	for _, pattern := range []string{"POST /api/users", "GET /api/chirps", "GET /api/chirps/{chirpID}", "POST /api/tokens", "POST /api/login/2fa", "POST /api/polka/webhooks"} {
+		mux.HandleFunc(pattern, echo)
+	}
+	handler := middlewareValidateRequest(spec, mux)
+
+	cases := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedType   string       // Problem type, for rejected requests
+		expectedErrors []FieldError // Problem field errors, Message isn't compared
+	}{
+		{
+			name:           "Valid body",
+			method:         "POST",
+			target:         "/api/users",
+			body:           `{"email": "user@example.com", "password": "abc123-password", "unknown": true}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing fields",
+			method:         "POST",
+			target:         "/api/users",
+			body:           `{}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedType:   PROBLEM_VALIDATION_FAILED,
+			expectedErrors: []FieldError{{Field: "email", Code: FIELD_REQUIRED}, {Field: "password", Code: FIELD_REQUIRED}},
+		},
+		{
+			name:           "Wrong type",
+			method:         "POST",
+			target:         "/api/users",
+			body:           `{"email": 1, "password": "abc123-password"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   PROBLEM_INVALID_JSON,
+			expectedErrors: []FieldError{{Field: "email", Code: FIELD_INVALID}},
+		},
+		{
+			name:           "Not an object",
+			method:         "POST",
+			target:         "/api/users",
+			body:           `["user@example.com"]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   PROBLEM_INVALID_JSON,
+		},
+		{
+			name:           "Malformed JSON",
+			method:         "POST",
+			target:         "/api/users",
+			body:           `{"email": `,
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   PROBLEM_INVALID_JSON,
+		},
+		{
+			name:           "Unknown enum value",
+			method:         "POST",
+			target:         "/api/tokens",
+			body:           `{"name": "script", "scopes": ["chirps:delete_everything"]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedType:   PROBLEM_VALIDATION_FAILED,
+			expectedErrors: []FieldError{{Field: "scopes.0", Code: FIELD_UNKNOWN}},
+		},
+		{
+			name:           "Invalid format",
+			method:         "POST",
+			target:         "/api/tokens",
+			body:           `{"name": "script", "scopes": ["chirps:write"], "expires_at": "tomorrow"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedType:   PROBLEM_VALIDATION_FAILED,
+			expectedErrors: []FieldError{{Field: "expires_at", Code: FIELD_INVALID}},
+		},
+		{
+			name:           "Optional fields",
+			method:         "POST",
+			target:         "/api/login/2fa",
+			body:           `{"challenge_token": "token", "recovery_code": "code"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid path parameter",
+			method:         "GET",
+			target:         "/api/chirps/not-a-uuid",
+			expectedStatus: http.StatusNotFound,
+			expectedType:   PROBLEM_NOT_FOUND,
+		},
+		{
+			name:           "Valid path parameter",
+			method:         "GET",
+			target:         "/api/chirps/" + uuid.NewString(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Invalid query parameter",
+			method:         "GET",
+			target:         "/api/chirps?author_id=not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+			expectedType:   PROBLEM_BAD_REQUEST,
+		},
+		{
+			name:           "Valid query parameters",
+			method:         "GET",
+			target:         "/api/chirps?sort=DESC&author_id=" + uuid.NewString(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Operation skips validation",
+			method:         "POST",
+			target:         "/api/polka/webhooks",
+			body:           `not JSON, logged by the handler`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		_, expectedPattern := mux.Handler(request)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		assertEquals(w.Code, c.expectedStatus, c.name, t)
+		// Rejected requests are still labelled with their route
+		assertEquals(request.Pattern, expectedPattern, c.name+": pattern", t)
+		if c.expectedStatus == http.StatusOK {
+			assertEquals(w.Body.String(), c.body, c.name+": body passed to the handler", t)
+			continue
+		}
+
+		problem := Problem{}
+		err := json.NewDecoder(w.Body).Decode(&problem)
+		if err != nil {
+			t.Error(formatTestError(c.name, err, "problem response"))
+			continue
+		}
+		assertEquals(problem.Type, c.expectedType, c.name, t)
+
+		actualErrors := []FieldError{}
+		for _, fieldError := range problem.Errors {
+			actualErrors = append(actualErrors, FieldError{Field: fieldError.Field, Code: fieldError.Code})
+		}
+		if c.expectedErrors == nil {
+			c.expectedErrors = []FieldError{}
+		}
+		assertEquals(fmt.Sprint(actualErrors), fmt.Sprint(c.expectedErrors), c.name+": field errors", t)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Events sent to webhook endpoints
+const (
+	WEBHOOK_EVENT_CHIRP_CREATED = "chirp.created"
+	WEBHOOK_EVENT_CHIRP_DELETED = "chirp.deleted"
+	WEBHOOK_EVENT_USER_CREATED  = "user.created"
+)
+
+var webhookEventTypes = []string{WEBHOOK_EVENT_CHIRP_CREATED, WEBHOOK_EVENT_CHIRP_DELETED, WEBHOOK_EVENT_USER_CREATED}
+
+// Delivery statuses
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_DEAD      = "dead" // Gave up after MAX_WEBHOOK_ATTEMPTS, can be requeued by an admin
+)
+
+// Headers sent with each delivery, the signature uses the same scheme as Polka's, see internal/webhook
+const (
+	CHIRPY_SIGNATURE_HEADER  = "Chirpy-Signature"
+	CHIRPY_EVENT_ID_HEADER   = "Chirpy-Event-Id"
+	CHIRPY_EVENT_TYPE_HEADER = "Chirpy-Event-Type"
+)
+
+// Retries back off exponentially: 30s, 1m, 2m, ... up to 6h between attempts
+const (
+	MAX_WEBHOOK_ATTEMPTS      = 10
+	WEBHOOK_RETRY_BASE_DELAY  = 30 * time.Second
+	WEBHOOK_RETRY_MAX_DELAY   = 6 * time.Hour
+	WEBHOOK_DELIVERY_TIMEOUT  = 10 * time.Second
+	WEBHOOK_DELIVERY_LEASE    = time.Minute // Longer than WEBHOOK_DELIVERY_TIMEOUT
+	WEBHOOK_DELIVERY_INTERVAL = 5 * time.Second
+	WEBHOOK_DELIVERY_BATCH    = 50
+)
+
+// Most delivery attempts listed at once
+const MAX_LISTED_DELIVERY_ATTEMPTS = 100
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+	Secret     string    `json:"secret,omitempty"` // Only returned when the endpoint is created
+}
+
+// Body of every delivery
+type OutboundWebhookEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type WebhookDeliveryAttempt struct {
+	DeliveryID     uuid.UUID `json:"delivery_id"`
+	DeliveryStatus string    `json:"delivery_status"`
+	EventID        uuid.UUID `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	AttemptedAt    time.Time `json:"attempted_at"`
+	StatusCode     *int32    `json:"status_code"` // null if there was no response
+	Error          string    `json:"error"`
+	DurationMs     int32     `json:"duration_ms"`
+}
+
+// Queues the event for every endpoint subscribed to it
+// Errors are logged rather than returned, since the change the event describes has already been made
+func (cfg *apiConfig) publishWebhookEvent(ctx context.Context, eventType string, data any) {
+	event := OutboundWebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		requestLogger(ctx).Error("Error encoding webhook event", "event_type", eventType, "error", err)
+		return
+	}
+
+	_, err = cfg.webhookOutbox.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		Now:       event.CreatedAt,
+		EventID:   event.ID,
+		EventType: eventType,
+		Payload:   payload,
+	})
+	if err != nil {
+		requestLogger(ctx).Error("Error queueing webhook event", "event_type", eventType, "event_id", event.ID, "error", err)
+	}
+}
+
+// Sends every due delivery, returns how many were attempted
+func (cfg *apiConfig) deliverDueWebhooks(ctx context.Context, now time.Time) (int, error) {
+	deliveries, err := cfg.db.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil:    now.Add(WEBHOOK_DELIVERY_LEASE),
+		Now:           now,
+		MaxDeliveries: WEBHOOK_DELIVERY_BATCH,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Send in parallel, so one slow endpoint doesn't hold up the others
+	wg := sync.WaitGroup{}
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.attemptWebhookDelivery(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// Sends the delivery once, then records the attempt and when (or if) to try again
+func (cfg *apiConfig) attemptWebhookDelivery(ctx context.Context, delivery database.ClaimDueWebhookDeliveriesRow) {
+	attemptedAt := time.Now()
+	statusCode, err := cfg.sendWebhook(ctx, delivery, attemptedAt)
+	duration := time.Since(attemptedAt)
+
+	attempt := database.CreateWebhookDeliveryAttemptParams{
+		ID:          uuid.New(),
+		AttemptedAt: attemptedAt,
+		DeliveryID:  delivery.ID,
+		DurationMs:  int32(duration.Milliseconds()),
+	}
+	if statusCode != 0 {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+	}
+	if err != nil {
+		attempt.Error = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	dbErr := cfg.db.CreateWebhookDeliveryAttempt(ctx, attempt)
+	if dbErr != nil {
+		slog.Error("Error recording webhook delivery attempt", "delivery_id", delivery.ID, "error", dbErr)
+	}
+
+	// Next status
+	attempts := delivery.Attempts + 1
+	result := database.SetWebhookDeliveryResultParams{
+		ID:            delivery.ID,
+		Status:        DELIVERY_DELIVERED,
+		Attempts:      attempts,
+		NextAttemptAt: attemptedAt,
+		UpdatedAt:     time.Now(),
+	}
+	if err != nil {
+		if attempts >= MAX_WEBHOOK_ATTEMPTS {
+			result.Status = DELIVERY_DEAD
+			slog.Warn("Webhook delivery failed, giving up", "delivery_id", delivery.ID, "url", delivery.Url, "attempts", attempts, "error", err)
+		} else {
+			result.Status = DELIVERY_PENDING
+			result.NextAttemptAt = attemptedAt.Add(webhookRetryDelay(attempts))
+		}
+	}
+
+	outboundWebhookAttemptsTotal.WithLabelValues(delivery.EventType, result.Status).Inc()
+
+	dbErr = cfg.db.SetWebhookDeliveryResult(ctx, result)
+	if dbErr != nil {
+		slog.Error("Error saving webhook delivery result", "delivery_id", delivery.ID, "error", dbErr)
+	}
+}
+
+// POSTs the signed payload, returns the response status code (0 if there was no response)
+// Any status other than 2xx is an error
+func (cfg *apiConfig) sendWebhook(ctx context.Context, delivery database.ClaimDueWebhookDeliveriesRow, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_DELIVERY_TIMEOUT)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, "POST", delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Chirpy-Webhooks")
+	request.Header.Set(CHIRPY_SIGNATURE_HEADER, webhook.Sign(delivery.Payload, now, delivery.Secret))
+	request.Header.Set(CHIRPY_EVENT_ID_HEADER, delivery.EventID.String())
+	request.Header.Set(CHIRPY_EVENT_TYPE_HEADER, delivery.EventType)
+
+	response, err := cfg.webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16)) // Lets the connection be reused
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint responded %v", response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// Delay before the next attempt, after `attempts` failed attempts
+func webhookRetryDelay(attempts int32) time.Duration {
+	delay := WEBHOOK_RETRY_BASE_DELAY
+	for i := int32(1); i < attempts && delay < WEBHOOK_RETRY_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	return min(delay, WEBHOOK_RETRY_MAX_DELAY)
+}
+
+// Runs deliverDueWebhooks every interval until the context is canceled
+// A run in progress isn't canceled with it, so deliveries aren't cut off mid-request (each is bounded by WEBHOOK_DELIVERY_TIMEOUT)
+func (cfg *apiConfig) runWebhookDeliveries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := cfg.deliverDueWebhooks(context.WithoutCancel(ctx), time.Now())
+		if err != nil {
+			slog.Error("Error delivering webhooks", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Subscribes a URL to events, returns the endpoint with the secret its deliveries are signed with
+func (cfg *apiConfig) createWebhookEndpointHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			URL        string   `json:"url"`
+			EventTypes []string `json:"event_types"`
+		}{}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		// Validate
+		endpointURL, err := url.Parse(req.URL)
+		if err != nil || (endpointURL.Scheme != "https" && endpointURL.Scheme != "http") || endpointURL.Host == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "url", Code: FIELD_INVALID, Message: "url must be an absolute http or https URL"})
+			return
+		}
+
+		if len(req.EventTypes) == 0 {
+			sendValidationErrorResponse(w, FieldError{Field: "event_types", Code: FIELD_REQUIRED, Message: "At least one event type required"})
+			return
+		}
+		eventTypes := []string{}
+		for _, eventType := range req.EventTypes {
+			if !slices.Contains(webhookEventTypes, eventType) {
+				sendValidationErrorResponse(w, FieldError{Field: "event_types", Code: FIELD_UNKNOWN, Message: fmt.Sprintf("Unknown event type %q", eventType)})
+				return
+			}
+			if !slices.Contains(eventTypes, eventType) {
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+		sort.Strings(eventTypes)
+
+		// Save
+		secret, err := auth.MakeOpaqueToken(auth.WEBHOOK_SECRET_PREFIX)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		savedEndpoint, err := cfg.db.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+			ID:         uuid.New(),
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+			Url:        endpointURL.String(),
+			Secret:     secret,
+			EventTypes: eventTypes,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		endpoint := toWebhookEndpoint(savedEndpoint)
+		endpoint.Secret = savedEndpoint.Secret
+
+		SendJSONResponse(w, http.StatusCreated, endpoint)
+	}
+}
+
+// Lists webhook endpoints, without their secrets
+func (cfg *apiConfig) getWebhookEndpointsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		savedEndpoints, err := cfg.db.GetWebhookEndpoints(r.Context())
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		endpoints := []WebhookEndpoint{}
+		for _, savedEndpoint := range savedEndpoints {
+			endpoints = append(endpoints, toWebhookEndpoint(savedEndpoint))
+		}
+
+		SendJSONResponse(w, http.StatusOK, endpoints)
+	}
+}
+
+// Deletes the endpoint along with its queued deliveries
+func (cfg *apiConfig) deleteWebhookEndpointHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "Webhook endpoint not found", http.StatusNotFound, err)
+			return
+		}
+
+		rowsDeleted, err := cfg.db.DeleteWebhookEndpoint(r.Context(), endpointID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		if rowsDeleted == 0 {
+			sendErrorJSONResponse(w, "Webhook endpoint not found", http.StatusNotFound, nil)
+			return
+		}
+
+		sendResponse(w, http.StatusNoContent, fmt.Sprintf("webhook endpoint %v deleted", endpointID))
+	}
+}
+
+// Lists the most recent attempts to deliver to the endpoint, newest first
+func (cfg *apiConfig) getWebhookDeliveryAttemptsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "Webhook endpoint not found", http.StatusNotFound, err)
+			return
+		}
+
+		savedAttempts, err := cfg.db.GetWebhookDeliveryAttempts(r.Context(), database.GetWebhookDeliveryAttemptsParams{
+			EndpointID: endpointID,
+			Limit:      MAX_LISTED_DELIVERY_ATTEMPTS,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		attempts := []WebhookDeliveryAttempt{}
+		for _, savedAttempt := range savedAttempts {
+			attempt := WebhookDeliveryAttempt{
+				DeliveryID:     savedAttempt.DeliveryID,
+				DeliveryStatus: savedAttempt.DeliveryStatus,
+				EventID:        savedAttempt.EventID,
+				EventType:      savedAttempt.EventType,
+				AttemptedAt:    savedAttempt.AttemptedAt,
+				Error:          savedAttempt.Error.String,
+				DurationMs:     savedAttempt.DurationMs,
+			}
+			if savedAttempt.StatusCode.Valid {
+				attempt.StatusCode = &savedAttempt.StatusCode.Int32
+			}
+			attempts = append(attempts, attempt)
+		}
+
+		SendJSONResponse(w, http.StatusOK, attempts)
+	}
+}
+
+// Puts a dead-lettered delivery back on the queue, with a fresh set of attempts
+func (cfg *apiConfig) requeueWebhookDeliveryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "Dead webhook delivery not found", http.StatusNotFound, err)
+			return
+		}
+
+		rowsUpdated, err := cfg.db.RequeueWebhookDelivery(r.Context(), database.RequeueWebhookDeliveryParams{
+			Now: time.Now(),
+			ID:  deliveryID,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		if rowsUpdated == 0 {
+			sendErrorJSONResponse(w, "Dead webhook delivery not found", http.StatusNotFound, nil)
+			return
+		}
+
+		sendResponse(w, http.StatusNoContent, fmt.Sprintf("webhook delivery %v requeued", deliveryID))
+	}
+}
+
+func toWebhookEndpoint(savedEndpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:         savedEndpoint.ID,
+		URL:        savedEndpoint.Url,
+		EventTypes: savedEndpoint.EventTypes,
+		CreatedAt:  savedEndpoint.CreatedAt,
+	}
+}
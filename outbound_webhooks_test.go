@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	cases := []struct {
+		attempts int32
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 30 * time.Second},
+		{attempts: 2, expected: time.Minute},
+		{attempts: 3, expected: 2 * time.Minute},
+		{attempts: 10, expected: 256 * time.Minute},
+		{attempts: 11, expected: WEBHOOK_RETRY_MAX_DELAY},
+		{attempts: 100, expected: WEBHOOK_RETRY_MAX_DELAY},
+	}
+
+	for _, c := range cases {
+		actual := webhookRetryDelay(c.attempts)
+		assertEquals(actual, c.expected, c.attempts, t)
+	}
+}
+
+func TestOutboundWebhooks(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	// Receivers
+	healthy := newWebhookReceiver(http.StatusOK)
+	defer healthy.Close()
+	failing := newWebhookReceiver(http.StatusInternalServerError)
+	defer failing.Close()
+
+	// Invalid subscriptions
+	cases := []struct {
+		name string
+		body string
+	}{
+		{name: "Missing URL", body: `{"event_types": ["chirp.created"]}`},
+		{name: "Relative URL", body: `{"url": "/hooks", "event_types": ["chirp.created"]}`},
+		{name: "Unsupported scheme", body: `{"url": "ftp://example.com", "event_types": ["chirp.created"]}`},
+		{name: "No event types", body: `{"url": "https://example.com"}`},
+		{name: "Unknown event type", body: `{"url": "https://example.com", "event_types": ["chirp.liked"]}`},
+	}
+
+	for _, c := range cases {
+		w := sendAdminRequest(cfg.createWebhookEndpointHandler(), "POST", "/admin/webhook-endpoints", c.body, nil)
+		assertEquals(w.Result().StatusCode, http.StatusUnprocessableEntity, c.name, t)
+	}
+
+	// Subscribe
+	healthyEndpoint := createWebhookEndpoint(cfg, `{"url": "`+healthy.URL+`", "event_types": ["chirp.created", "user.created"]}`, t)
+	defer deleteWebhookEndpoint(cfg, healthyEndpoint.ID.String())
+	healthy.secret = healthyEndpoint.Secret
+
+	failingEndpoint := createWebhookEndpoint(cfg, `{"url": "`+failing.URL+`", "event_types": ["chirp.deleted"]}`, t)
+	defer deleteWebhookEndpoint(cfg, failingEndpoint.ID.String())
+	failing.secret = failingEndpoint.Secret
+
+	w := sendAdminRequest(cfg.getWebhookEndpointsHandler(), "GET", "/admin/webhook-endpoints", "", nil)
+	endpoints := []WebhookEndpoint{}
+	json.NewDecoder(w.Result().Body).Decode(&endpoints)
+	for _, endpoint := range endpoints {
+		assertEquals(endpoint.Secret, "", "listed secret hidden", t)
+	}
+
+	// Events are queued, then sent signed
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	chirp, err := postChirp(cfg, loggedInUser.Token, "Hello, webhooks")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	_, err = cfg.deliverDueWebhooks(context.Background(), time.Now())
+	if err != nil {
+		t.Error(err)
+	}
+
+	receivedTypes := []string{}
+	for _, event := range healthy.events() {
+		receivedTypes = append(receivedTypes, event.Type)
+	}
+	slices.Sort(receivedTypes) // Sent in parallel
+	assertEquals(strings.Join(receivedTypes, ","), "chirp.created,user.created", "received events", t)
+	assertEquals(healthy.invalidSignatures, 0, "signatures", t)
+
+	// Sent once
+	_, err = cfg.deliverDueWebhooks(context.Background(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	assertEquals(len(healthy.events()), 2, "delivered events not resent", t)
+
+	// Failing deliveries are retried, then dead-lettered
+	request := httptest.NewRequest("DELETE", "/api/chirps/"+chirp.ID.String(), nil)
+	request.SetPathValue("chirpID", chirp.ID.String())
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w = httptest.NewRecorder()
+	cfg.deleteChirpHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "delete chirp", t)
+
+	for i := range MAX_WEBHOOK_ATTEMPTS + 2 {
+		// Later than the longest retry delay each time
+		_, err = cfg.deliverDueWebhooks(context.Background(), time.Now().Add(time.Duration(i)*(WEBHOOK_RETRY_MAX_DELAY+time.Hour)))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	assertEquals(len(failing.events()), MAX_WEBHOOK_ATTEMPTS, "attempts before dead-lettering", t)
+
+	// Attempts can be inspected
+	w = sendAdminRequest(cfg.getWebhookDeliveryAttemptsHandler(), "GET", "/admin/webhook-endpoints/"+failingEndpoint.ID.String()+"/attempts", "", map[string]string{"endpointID": failingEndpoint.ID.String()})
+	attempts := []WebhookDeliveryAttempt{}
+	json.NewDecoder(w.Result().Body).Decode(&attempts)
+
+	if len(attempts) != MAX_WEBHOOK_ATTEMPTS {
+		t.Error(formatTestError("listed attempts", len(attempts), MAX_WEBHOOK_ATTEMPTS))
+		t.FailNow()
+	}
+	assertEquals(attempts[0].DeliveryStatus, DELIVERY_DEAD, "dead-lettered", t)
+	assertEquals(attempts[0].EventType, WEBHOOK_EVENT_CHIRP_DELETED, "attempt event type", t)
+	if attempts[0].StatusCode == nil || *attempts[0].StatusCode != http.StatusInternalServerError {
+		t.Error(formatTestError("attempt status code", attempts[0].StatusCode, http.StatusInternalServerError))
+	}
+
+	// Requeued dead letters are sent again
+	deliveryID := attempts[0].DeliveryID.String()
+	for _, expectedStatus := range []int{http.StatusNoContent, http.StatusNotFound} {
+		w = sendAdminRequest(cfg.requeueWebhookDeliveryHandler(), "POST", "/admin/webhook-deliveries/"+deliveryID+"/requeue", "", map[string]string{"deliveryID": deliveryID})
+		assertEquals(w.Result().StatusCode, expectedStatus, "requeue", t)
+	}
+
+	_, err = cfg.deliverDueWebhooks(context.Background(), time.Now())
+	if err != nil {
+		t.Error(err)
+	}
+	assertEquals(len(failing.events()), MAX_WEBHOOK_ATTEMPTS+1, "requeued delivery sent", t)
+}
+
+// Records the events POSTed to it, and responds with `statusCode`
+type webhookReceiver struct {
+	*httptest.Server
+	statusCode        int
+	secret            string
+	mu                sync.Mutex
+	received          []OutboundWebhookEvent
+	invalidSignatures int
+}
+
+func newWebhookReceiver(statusCode int) *webhookReceiver {
+	receiver := &webhookReceiver{statusCode: statusCode}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+
+		err := webhook.Verify(r.Header.Get(CHIRPY_SIGNATURE_HEADER), body, []string{receiver.secret}, webhook.DEFAULT_TOLERANCE, time.Now())
+		if err != nil {
+			receiver.invalidSignatures++
+		}
+
+		event := OutboundWebhookEvent{}
+		json.Unmarshal(body, &event)
+		receiver.received = append(receiver.received, event)
+
+		w.WriteHeader(receiver.statusCode)
+	}))
+	return receiver
+}
+
+func (receiver *webhookReceiver) events() []OutboundWebhookEvent {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	return append([]OutboundWebhookEvent{}, receiver.received...)
+}
+
+func createWebhookEndpoint(cfg *apiConfig, body string, t *testing.T) WebhookEndpoint {
+	t.Helper()
+
+	w := sendAdminRequest(cfg.createWebhookEndpointHandler(), "POST", "/admin/webhook-endpoints", body, nil)
+	assertEquals(w.Result().StatusCode, http.StatusCreated, "create webhook endpoint", t)
+
+	endpoint := WebhookEndpoint{}
+	json.NewDecoder(w.Result().Body).Decode(&endpoint)
+	if endpoint.Secret == "" {
+		t.Error(formatTestError("create webhook endpoint", endpoint, "secret"))
+	}
+	return endpoint
+}
+
+func deleteWebhookEndpoint(cfg *apiConfig, endpointID string) {
+	sendAdminRequest(cfg.deleteWebhookEndpointHandler(), "DELETE", "/admin/webhook-endpoints/"+endpointID, "", map[string]string{"endpointID": endpointID})
+}
+
+// Calls the admin handler directly, the role check is done by middlewareRequireRole
+func sendAdminRequest(handler http.HandlerFunc, method, target, body string, pathValues map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range pathValues {
+		request.SetPathValue(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler(w, request)
+	return w
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// Passkey (WebAuthn) ceremonies
+// Each ceremony is 2 requests: `begin` returns options for the browser's navigator.credentials.create()/get(),
+// `finish` receives the authenticator's response
+// Spec: https://www.w3.org/TR/webauthn-3/
+const (
+	CEREMONY_REGISTRATION = "registration"
+	CEREMONY_LOGIN        = "login"
+
+	PASSKEY_SESSION_DURATION = 5 * time.Minute
+)
+
+type PasskeyCeremonyResponse struct {
+	SessionID uuid.UUID `json:"session_id"`
+	Options   any       `json:"options"`
+}
+
+type Passkey struct {
+	ID         protocol.URLEncodedBase64 `json:"id"`
+	Name       string                    `json:"name"`
+	CreatedAt  time.Time                 `json:"created_at"`
+	LastUsedAt *time.Time                `json:"last_used_at"`
+}
+
+// Sent to the `finish` endpoints
+type passkeyFinishRequest struct {
+	SessionID  uuid.UUID       `json:"session_id"`
+	Name       string          `json:"name"`       // Registration only, ex: "Work laptop"
+	Credential json.RawMessage `json:"credential"` // PublicKeyCredential from the browser, as JSON
+}
+
+// Adapts a Chirpy user and their saved passkeys to the webauthn.User interface
+type passkeyUser struct {
+	user        database.User
+	credentials []webauthn.Credential
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// Starts registering a new passkey for the user in the auth token
+func (cfg *apiConfig) beginPasskeyRegistrationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Read userID from the auth token
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+
+		userID, err := auth.ValidateToken(token, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+		setRequestUserID(r.Context(), userID)
+
+		user, err := cfg.getPasskeyUser(r.Context(), userID)
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("invalid user %v", userID))
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Require a discoverable credential (a "passkey") so it can be used to log in without an email
+		// Exclude the user's existing passkeys so the same authenticator isn't registered twice
+		creation, session, err := cfg.webAuthn.BeginRegistration(user,
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+			webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		sessionID, err := cfg.savePasskeySession(r.Context(), CEREMONY_REGISTRATION, uuid.NullUUID{UUID: userID, Valid: true}, session)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusOK, PasskeyCeremonyResponse{
+			SessionID: sessionID,
+			Options:   creation,
+		})
+	}
+}
+
+// Verifies the authenticator's attestation and saves the new passkey
+func (cfg *apiConfig) finishPasskeyRegistrationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := passkeyFinishRequest{}
+
+		// Read userID from the auth token
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+
+		userID, err := auth.ValidateToken(token, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+		setRequestUserID(r.Context(), userID)
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		if req.Name == "" {
+			req.Name = "Passkey"
+		}
+
+		// Session must have been started by the same user
+		session, err := cfg.consumePasskeySession(r.Context(), req.SessionID, CEREMONY_REGISTRATION)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid or expired session", http.StatusBadRequest, err)
+			return
+		}
+		if !session.UserID.Valid || session.UserID.UUID != userID {
+			sendErrorJSONResponse(w, "Invalid or expired session", http.StatusBadRequest, fmt.Errorf("user %v tried to finish registration session %v for user %v", userID, session.ID, session.UserID))
+			return
+		}
+
+		user, err := cfg.getPasskeyUser(r.Context(), userID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Verify attestation
+		sessionData, err := passkeySessionData(session)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		parsedCredential, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid credential", http.StatusBadRequest, err)
+			return
+		}
+
+		credential, err := cfg.webAuthn.CreateCredential(user, sessionData, parsedCredential)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid credential", http.StatusBadRequest, err)
+			return
+		}
+
+		// Save passkey
+		transports := []string{}
+		for _, transport := range credential.Transport {
+			transports = append(transports, string(transport))
+		}
+
+		savedPasskey, err := cfg.db.CreateWebAuthnCredential(r.Context(), database.CreateWebAuthnCredentialParams{
+			ID:              credential.ID,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			UserID:          userID,
+			Name:            req.Name,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transports:      transports,
+			Aaguid:          credential.Authenticator.AAGUID,
+			SignCount:       int64(credential.Authenticator.SignCount),
+			BackupEligible:  credential.Flags.BackupEligible,
+			BackupState:     credential.Flags.BackupState,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Unable to save passkey", http.StatusConflict, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusCreated, Passkey{
+			ID:        savedPasskey.ID,
+			Name:      savedPasskey.Name,
+			CreatedAt: savedPasskey.CreatedAt,
+		})
+	}
+}
+
+// Starts a passwordless login
+// No email needed, the browser lets the user pick one of their passkeys for this site
+func (cfg *apiConfig) beginPasskeyLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Anyone can start one, so limit how many sessions each client can save
+		retryAfter := cfg.passkeyLoginRetryAfter(r)
+		if retryAfter > 0 {
+			sendTooManyRequestsResponse(w, "Too many login attempts, try again later", retryAfter, fmt.Errorf("passkey login throttled from %v", clientIP(r)))
+			return
+		}
+
+		// Require user verification (PIN or biometric), otherwise a tap on a stolen security key would skip the user's 2FA
+		assertion, session, err := cfg.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		sessionID, err := cfg.savePasskeySession(r.Context(), CEREMONY_LOGIN, uuid.NullUUID{}, session)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusOK, PasskeyCeremonyResponse{
+			SessionID: sessionID,
+			Options:   assertion,
+		})
+	}
+}
+
+// Verifies the authenticator's signature, then logs in the passkey's owner
+// Returns the same LoginResponse as POST /api/login
+// A passkey with user verification is already multi-factor (device + biometric/PIN), so TOTP isn't required here
+func (cfg *apiConfig) finishPasskeyLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := passkeyFinishRequest{}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		session, err := cfg.consumePasskeySession(r.Context(), req.SessionID, CEREMONY_LOGIN)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid or expired session", http.StatusBadRequest, err)
+			return
+		}
+
+		sessionData, err := passkeySessionData(session)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		parsedAssertion, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid credential", http.StatusBadRequest, err)
+			return
+		}
+
+		// The assertion's user handle is the Chirpy user ID set during registration
+		findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			return cfg.getPasskeyUser(r.Context(), userID)
+		}
+
+		webAuthnUser, credential, err := cfg.webAuthn.ValidatePasskeyLogin(findUser, sessionData, parsedAssertion)
+		if err != nil {
+			recordLoginMetric(LOGIN_METHOD_PASSKEY, LOGIN_FAILURE)
+			cfg.ipThrottle.Fail(ipThrottleKey(r), time.Now())
+			sendErrorJSONResponse(w, "Invalid credential", http.StatusUnauthorized, err)
+			return
+		}
+
+		// Also checked here in case the session was saved without requiring it
+		if !credential.Flags.UserVerified {
+			recordLoginMetric(LOGIN_METHOD_PASSKEY, LOGIN_FAILURE)
+			cfg.ipThrottle.Fail(ipThrottleKey(r), time.Now())
+			sendErrorJSONResponse(w, "User verification required", http.StatusUnauthorized, fmt.Errorf("passkey %x login without user verification", credential.ID))
+			return
+		}
+
+		// A signature counter that didn't increase may mean the authenticator was cloned
+		if credential.Authenticator.CloneWarning {
+			recordLoginMetric(LOGIN_METHOD_PASSKEY, LOGIN_FAILURE)
+			sendErrorJSONResponse(w, "Invalid credential", http.StatusUnauthorized, fmt.Errorf("passkey %x sign count did not increase, possible cloned authenticator", credential.ID))
+			return
+		}
+
+		err = cfg.db.UpdateWebAuthnCredentialUsage(r.Context(), database.UpdateWebAuthnCredentialUsageParams{
+			ID:          credential.ID,
+			SignCount:   int64(credential.Authenticator.SignCount),
+			BackupState: credential.Flags.BackupState,
+			LastUsedAt:  sql.NullTime{Time: time.Now(), Valid: true},
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		recordLoginMetric(LOGIN_METHOD_PASSKEY, LOGIN_SUCCESS)
+		loginResponse, err := cfg.createLoginResponse(r.Context(), webAuthnUser.(passkeyUser).user)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		SendJSONResponse(w, http.StatusOK, loginResponse)
+	}
+}
+
+// Returns the user along with their saved passkeys
+func (cfg *apiConfig) getPasskeyUser(ctx context.Context, userID uuid.UUID) (passkeyUser, error) {
+	user, err := cfg.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	savedCredentials, err := cfg.db.GetWebAuthnCredentialsByUserID(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	credentials := []webauthn.Credential{}
+	for _, c := range savedCredentials {
+		transports := []protocol.AuthenticatorTransport{}
+		for _, transport := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.Aaguid,
+				SignCount: uint32(c.SignCount),
+			},
+		})
+	}
+
+	return passkeyUser{
+		user:        user,
+		credentials: credentials,
+	}, nil
+}
+
+// Saves the ceremony's challenge, etc. until the `finish` request, returns the session ID
+func (cfg *apiConfig) savePasskeySession(ctx context.Context, ceremony string, userID uuid.NullUUID, session *webauthn.SessionData) (uuid.UUID, error) {
+	expiresAt := session.Expires
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(PASSKEY_SESSION_DURATION)
+	}
+
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Clear out abandoned sessions
+	err = cfg.db.DeleteExpiredWebAuthnSessions(ctx, time.Now())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	sessionID := uuid.New()
+	err = cfg.db.CreateWebAuthnSession(ctx, database.CreateWebAuthnSessionParams{
+		ID:          sessionID,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: sessionData,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return sessionID, nil
+}
+
+// Returns the saved session, deleting it so the challenge can't be used again
+func (cfg *apiConfig) consumePasskeySession(ctx context.Context, sessionID uuid.UUID, ceremony string) (database.WebauthnSession, error) {
+	session, err := cfg.db.ConsumeWebAuthnSession(ctx, database.ConsumeWebAuthnSessionParams{
+		ID:       sessionID,
+		Ceremony: ceremony,
+	})
+	if err != nil {
+		return database.WebauthnSession{}, err
+	}
+
+	if session.ExpiresAt.Before(time.Now()) {
+		return database.WebauthnSession{}, fmt.Errorf("%v session %v expired at %v", ceremony, session.ID, session.ExpiresAt)
+	}
+
+	return session, nil
+}
+
+func passkeySessionData(session database.WebauthnSession) (webauthn.SessionData, error) {
+	sessionData := webauthn.SessionData{}
+	err := json.Unmarshal(session.SessionData, &sessionData)
+	return sessionData, err
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// Runs the registration and login ceremonies against the WebAuthn library directly, no database needed
+func TestPasskeyCeremoniesWithSoftwareAuthenticator(t *testing.T) {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "Chirpy",
+		RPOrigins:     []string{"http://localhost:8080"},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	authenticator, err := newSoftwareAuthenticator("localhost", "http://localhost:8080")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	user := passkeyUser{
+		user: database.User{
+			ID:    uuid.New(),
+			Email: "passkey_user@email.com",
+		},
+	}
+
+	// Registration
+	creation, registrationSession, err := webAuthn.BeginRegistration(user)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	attestation, err := authenticator.create(creation.Response)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	parsedAttestation, err := protocol.ParseCredentialCreationResponseBytes(attestation)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	credential, err := webAuthn.CreateCredential(user, *registrationSession, parsedAttestation)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	user.credentials = append(user.credentials, *credential)
+
+	// Login, twice to check the sign counter
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if !bytes.Equal(userHandle, user.WebAuthnID()) {
+			return nil, fmt.Errorf("unknown user handle %x", userHandle)
+		}
+		return user, nil
+	}
+
+	for i := range 2 {
+		assertionOptions, loginSession, err := webAuthn.BeginDiscoverableLogin()
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		assertion, err := authenticator.get(assertionOptions.Response)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		parsedAssertion, err := protocol.ParseCredentialRequestResponseBytes(assertion)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		_, loggedInCredential, err := webAuthn.ValidatePasskeyLogin(findUser, *loginSession, parsedAssertion)
+		if err != nil {
+			t.Error(formatTestError(fmt.Sprintf("login %v", i), err, nil))
+			t.FailNow()
+		}
+
+		assertEquals(loggedInCredential.Authenticator.CloneWarning, false, i, t)
+		assertEquals(loggedInCredential.Authenticator.SignCount, authenticator.signCount, i, t)
+		user.credentials[0].Authenticator.SignCount = loggedInCredential.Authenticator.SignCount
+	}
+
+	// Signature from a different key is rejected
+	otherAuthenticator, err := newSoftwareAuthenticator("localhost", "http://localhost:8080")
+	if err != nil {
+		t.Error(err)
+	}
+	otherAuthenticator.credentialID = authenticator.credentialID
+	otherAuthenticator.userHandle = authenticator.userHandle
+	otherAuthenticator.signCount = authenticator.signCount
+
+	assertionOptions, loginSession, err := webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	assertion, err := otherAuthenticator.get(assertionOptions.Response)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	parsedAssertion, err := protocol.ParseCredentialRequestResponseBytes(assertion)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	_, _, err = webAuthn.ValidatePasskeyLogin(findUser, *loginSession, parsedAssertion)
+	if err == nil {
+		t.Error(formatTestError("Login with wrong key", err, "error"))
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	authenticator, err := newSoftwareAuthenticator(cfg.webAuthn.Config.RPID, cfg.webAuthn.Config.RPOrigins[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Register
+	request := httptest.NewRequest("POST", "/api/passkeys/register/begin", nil)
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w := httptest.NewRecorder()
+	cfg.beginPasskeyRegistrationHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "register begin", t)
+
+	registrationCeremony := struct {
+		SessionID uuid.UUID                   `json:"session_id"`
+		Options   protocol.CredentialCreation `json:"options"`
+	}{}
+	err = json.NewDecoder(w.Result().Body).Decode(&registrationCeremony)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	attestation, err := authenticator.create(registrationCeremony.Options.Response)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	finishBody, _ := json.Marshal(passkeyFinishRequest{
+		SessionID:  registrationCeremony.SessionID,
+		Name:       "Test authenticator",
+		Credential: attestation,
+	})
+	request = httptest.NewRequest("POST", "/api/passkeys/register/finish", bytes.NewReader(finishBody))
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w = httptest.NewRecorder()
+	cfg.finishPasskeyRegistrationHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusCreated, "register finish", t)
+
+	// Log in
+	request = httptest.NewRequest("POST", "/api/passkeys/login/begin", nil)
+	w = httptest.NewRecorder()
+	cfg.beginPasskeyLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login begin", t)
+
+	loginCeremony := struct {
+		SessionID uuid.UUID                    `json:"session_id"`
+		Options   protocol.CredentialAssertion `json:"options"`
+	}{}
+	err = json.NewDecoder(w.Result().Body).Decode(&loginCeremony)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	assertEquals(loginCeremony.Options.Response.UserVerification, protocol.VerificationRequired, "login user verification", t)
+
+	assertion, err := authenticator.get(loginCeremony.Options.Response)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	finishBody, _ = json.Marshal(passkeyFinishRequest{
+		SessionID:  loginCeremony.SessionID,
+		Credential: assertion,
+	})
+	request = httptest.NewRequest("POST", "/api/passkeys/login/finish", bytes.NewReader(finishBody))
+	w = httptest.NewRecorder()
+	cfg.finishPasskeyLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login finish", t)
+
+	loginResp := LoginResponse{}
+	err = json.NewDecoder(w.Result().Body).Decode(&loginResp)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assertEquals(loginResp.ID, users[0].ID, loginResp, t)
+	assertEquals(loginResp.Email, users[0].Email, loginResp, t)
+	if loginResp.Token == "" || loginResp.RefreshToken == "" {
+		t.Error(formatTestError("passkey login", loginResp, "JWT and refresh token"))
+	}
+
+	// Sessions are single-use
+	request = httptest.NewRequest("POST", "/api/passkeys/login/finish", bytes.NewReader(finishBody))
+	w = httptest.NewRecorder()
+	cfg.finishPasskeyLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusBadRequest, "replayed login finish", t)
+
+	// Without user verification, the passkey is only one factor
+	authenticator.skipUserVerification = true
+
+	request = httptest.NewRequest("POST", "/api/passkeys/login/begin", nil)
+	w = httptest.NewRecorder()
+	cfg.beginPasskeyLoginHandler()(w, request)
+	err = json.NewDecoder(w.Result().Body).Decode(&loginCeremony)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	assertion, err = authenticator.get(loginCeremony.Options.Response)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	finishBody, _ = json.Marshal(passkeyFinishRequest{
+		SessionID:  loginCeremony.SessionID,
+		Credential: assertion,
+	})
+	request = httptest.NewRequest("POST", "/api/passkeys/login/finish", bytes.NewReader(finishBody))
+	w = httptest.NewRecorder()
+	cfg.finishPasskeyLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "login finish without user verification", t)
+}
+
+func TestPasskeyLoginBegin(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	ctx := context.Background()
+
+	// Abandoned session
+	expiredSessionID := uuid.New()
+	err := cfg.db.CreateWebAuthnSession(ctx, database.CreateWebAuthnSessionParams{
+		ID:          expiredSessionID,
+		CreatedAt:   time.Now().Add(-2 * PASSKEY_SESSION_DURATION),
+		ExpiresAt:   time.Now().Add(-PASSKEY_SESSION_DURATION),
+		Ceremony:    CEREMONY_LOGIN,
+		SessionData: []byte("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each client IP can start a burst of logins, then has to wait
+	for i := range passkeyLoginLimit.Burst + 1 {
+		expected := http.StatusOK
+		if i == passkeyLoginLimit.Burst {
+			expected = http.StatusTooManyRequests
+		}
+
+		request := httptest.NewRequest("POST", "/api/passkeys/login/begin", nil)
+		w := httptest.NewRecorder()
+		cfg.beginPasskeyLoginHandler()(w, request)
+		assertEquals(w.Result().StatusCode, expected, fmt.Sprintf("login begin %v", i+1), t)
+	}
+
+	// Another client isn't limited
+	request := httptest.NewRequest("POST", "/api/passkeys/login/begin", nil)
+	request.RemoteAddr = "192.0.2.2:1234"
+	w := httptest.NewRecorder()
+	cfg.beginPasskeyLoginHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login begin from another IP", t)
+
+	// Expired sessions were deleted when new ones were saved
+	_, err = cfg.db.ConsumeWebAuthnSession(ctx, database.ConsumeWebAuthnSessionParams{ID: expiredSessionID, Ceremony: CEREMONY_LOGIN})
+	assertEquals(err, sql.ErrNoRows, "expired session", t)
+}
+
+// Minimal software passkey authenticator for tests
+// Creates a single P-256 credential with "none" attestation, and signs assertions with it
+type softwareAuthenticator struct {
+	rpID         string
+	origin       string
+	privateKey   *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+
+	// Signs assertions with only user presence, like a security key that's tapped without a PIN
+	skipUserVerification bool
+}
+
+func newSoftwareAuthenticator(rpID, origin string) (*softwareAuthenticator, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := make([]byte, 32)
+	_, err = rand.Read(credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &softwareAuthenticator{
+		rpID:         rpID,
+		origin:       origin,
+		privateKey:   privateKey,
+		credentialID: credentialID,
+	}, nil
+}
+
+// Returns the PublicKeyCredential JSON that navigator.credentials.create() would return
+func (a *softwareAuthenticator) create(options protocol.PublicKeyCredentialCreationOptions) ([]byte, error) {
+	// The user ID is sent as base64url
+	userHandle, err := base64.RawURLEncoding.DecodeString(fmt.Sprint(options.User.ID))
+	if err != nil {
+		return nil, err
+	}
+	a.userHandle = userHandle
+
+	clientDataJSON, err := a.clientData(protocol.CreateCeremony, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	// COSE_Key for an ES256 public key: https://www.rfc-editor.org/rfc/rfc9053#section-7.1.1
+	publicKey, err := a.privateKey.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	uncompressed := publicKey.Bytes()
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,                   // kty: EC2
+		3:  -7,                  // alg: ES256
+		-1: 1,                   // crv: P-256
+		-2: uncompressed[1:33],  // x
+		-3: uncompressed[33:65], // y
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Attested credential data: AAGUID | credential ID length | credential ID | public key
+	attestedCredentialData := make([]byte, 16)
+	attestedCredentialData = binary.BigEndian.AppendUint16(attestedCredentialData, uint16(len(a.credentialID)))
+	attestedCredentialData = append(attestedCredentialData, a.credentialID...)
+	attestedCredentialData = append(attestedCredentialData, coseKey...)
+
+	authData := a.authenticatorData(protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData)
+	authData = append(authData, attestedCredentialData...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Returns the PublicKeyCredential JSON that navigator.credentials.get() would return
+func (a *softwareAuthenticator) get(options protocol.PublicKeyCredentialRequestOptions) ([]byte, error) {
+	a.signCount++
+
+	clientDataJSON, err := a.clientData(protocol.AssertCeremony, options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified
+	if a.skipUserVerification {
+		flags = protocol.FlagUserPresent
+	}
+	authData := a.authenticatorData(flags)
+
+	// Signature over authenticatorData | SHA-256(clientDataJSON)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.privateKey, signedData[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+}
+
+func (a *softwareAuthenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge.String(),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+}
+
+// RP ID hash | flags | sign count
+func (a *softwareAuthenticator) authenticatorData(flags protocol.AuthenticatorFlags) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, byte(flags))
+	authData = binary.BigEndian.AppendUint32(authData, a.signCount)
+
+	return authData
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Long-lived tokens for bots and scripts, accepted anywhere app access tokens with the same scopes are
+// The token is only returned when it's created, only its hash is saved
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // null if the token never expires
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+// Creates a personal access token for the logged-in user
+func (cfg *apiConfig) createPersonalAccessTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}{}
+
+		// Personal access tokens can't create more tokens
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		// Validate required fields
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "name", Code: FIELD_REQUIRED, Message: "Name required"})
+			return
+		}
+
+		if len(req.Scopes) == 0 {
+			sendValidationErrorResponse(w, FieldError{Field: "scopes", Code: FIELD_REQUIRED, Message: "At least one scope required"})
+			return
+		}
+		scopes := []string{}
+		for _, scope := range req.Scopes {
+			if _, ok := tokenScopes[scope]; !ok {
+				sendValidationErrorResponse(w, FieldError{Field: "scopes", Code: FIELD_UNKNOWN, Message: fmt.Sprintf("Unknown scope %q", scope)})
+				return
+			}
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		sort.Strings(scopes)
+
+		expiresAt := sql.NullTime{}
+		if req.ExpiresAt != nil {
+			if req.ExpiresAt.Before(time.Now()) {
+				sendValidationErrorResponse(w, FieldError{Field: "expires_at", Code: FIELD_INVALID, Message: "Expiration must be in the future"})
+				return
+			}
+			// Same instant in UTC, whatever offset the client sent
+			expiresAt = sql.NullTime{Time: req.ExpiresAt.UTC(), Valid: true}
+		}
+
+		// Save hashed token
+		token, err := auth.MakeOpaqueToken(auth.PERSONAL_ACCESS_TOKEN_PREFIX)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		savedToken, err := cfg.db.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+			ID:        uuid.New(),
+			CreatedAt: time.Now(),
+			UserID:    userID,
+			Name:      req.Name,
+			TokenHash: auth.HashToken(token),
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		response := toPersonalAccessToken(savedToken)
+		response.Token = token
+
+		SendJSONResponse(w, http.StatusCreated, response)
+	}
+}
+
+// Lists the logged-in user's personal access tokens, without the tokens themselves
+func (cfg *apiConfig) getPersonalAccessTokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		savedTokens, err := cfg.db.GetPersonalAccessTokens(r.Context(), userID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		tokens := []PersonalAccessToken{}
+		for _, savedToken := range savedTokens {
+			tokens = append(tokens, toPersonalAccessToken(savedToken))
+		}
+
+		SendJSONResponse(w, http.StatusOK, tokens)
+	}
+}
+
+// Revokes one of the logged-in user's personal access tokens
+func (cfg *apiConfig) revokePersonalAccessTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "Token not found", http.StatusNotFound, err)
+			return
+		}
+
+		rowsDeleted, err := cfg.db.DeletePersonalAccessToken(r.Context(), database.DeletePersonalAccessTokenParams{
+			ID:     tokenID,
+			UserID: userID,
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+		if rowsDeleted == 0 {
+			sendErrorJSONResponse(w, "Token not found", http.StatusNotFound, nil)
+			return
+		}
+
+		sendResponse(w, http.StatusNoContent, fmt.Sprintf("user %v revoked personal access token %v", userID, tokenID))
+	}
+}
+
+func toPersonalAccessToken(savedToken database.PersonalAccessToken) PersonalAccessToken {
+	token := PersonalAccessToken{
+		ID:        savedToken.ID,
+		Name:      savedToken.Name,
+		Scopes:    savedToken.Scopes,
+		CreatedAt: savedToken.CreatedAt,
+	}
+
+	if savedToken.ExpiresAt.Valid {
+		token.ExpiresAt = &savedToken.ExpiresAt.Time
+	}
+	if savedToken.LastUsedAt.Valid {
+		token.LastUsedAt = &savedToken.LastUsedAt.Time
+	}
+
+	return token
+}
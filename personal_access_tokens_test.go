@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Invalid requests
+	cases := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "Missing name",
+			body: `{"scopes": ["chirps:write"]}`,
+		},
+		{
+			name: "Unknown scope",
+			body: `{"name": "Bot", "scopes": ["admin"]}`,
+		},
+		{
+			name: "Already expired",
+			body: `{"name": "Bot", "scopes": ["chirps:write"], "expires_at": "2001-01-01T00:00:00Z"}`,
+		},
+	}
+
+	for _, c := range cases {
+		w := sendBearerRequest(cfg.createPersonalAccessTokenHandler(), "POST", "/api/tokens", loggedInUser.Token, c.body)
+		assertEquals(w.Result().StatusCode, http.StatusUnprocessableEntity, c.name, t)
+	}
+
+	// Create token
+	expiresAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	w := sendBearerRequest(cfg.createPersonalAccessTokenHandler(), "POST", "/api/tokens", loggedInUser.Token, `{"name": "Deploy bot", "scopes": ["chirps:write"], "expires_at": "`+expiresAt+`"}`)
+	assertEquals(w.Result().StatusCode, http.StatusCreated, "create token", t)
+
+	createdToken := PersonalAccessToken{}
+	json.NewDecoder(w.Result().Body).Decode(&createdToken)
+	if createdToken.Token == "" || createdToken.ExpiresAt == nil {
+		t.Error(formatTestError("create token", createdToken, "token and expires_at"))
+	}
+
+	// Token works where its scope allows, and nowhere else
+	_, err = postChirp(cfg, createdToken.Token, "Posted by a bot")
+	if err != nil {
+		t.Error(formatTestError("post chirp with personal access token", err, nil))
+	}
+
+	w = sendBearerRequest(cfg.createPersonalAccessTokenHandler(), "POST", "/api/tokens", createdToken.Token, `{"name": "Another bot", "scopes": ["chirps:write"]}`)
+	assertEquals(w.Result().StatusCode, http.StatusForbidden, "create token with personal access token", t)
+
+	w = sendBearerRequest(cfg.updateUserHandler(), "PUT", "/api/users", createdToken.Token, `{"email": "stolen@email.com", "password": "stolen-password"}`)
+	assertEquals(w.Result().StatusCode, http.StatusUnauthorized, "update user with personal access token", t)
+
+	// Listed without the token, with last use
+	w = sendBearerRequest(cfg.getPersonalAccessTokensHandler(), "GET", "/api/tokens", loggedInUser.Token, "")
+	listedTokens := []PersonalAccessToken{}
+	json.NewDecoder(w.Result().Body).Decode(&listedTokens)
+
+	if len(listedTokens) != 1 {
+		t.Error(formatTestError("list tokens", listedTokens, "1 token"))
+		t.FailNow()
+	}
+	assertEquals(listedTokens[0].Token, "", "listed token hidden", t)
+	if listedTokens[0].LastUsedAt == nil {
+		t.Error(formatTestError("last used", listedTokens[0].LastUsedAt, "time of chirp post"))
+	}
+
+	// Revoke
+	for _, expectedStatus := range []int{http.StatusNoContent, http.StatusNotFound} {
+		request := httptest.NewRequest("DELETE", "/api/tokens/"+createdToken.ID.String(), nil)
+		request.SetPathValue("tokenID", createdToken.ID.String())
+		request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+		w = httptest.NewRecorder()
+		cfg.revokePersonalAccessTokenHandler()(w, request)
+		assertEquals(w.Result().StatusCode, expectedStatus, "revoke token", t)
+	}
+
+	_, err = postChirp(cfg, createdToken.Token, "Posted by a revoked bot")
+	if err == nil {
+		t.Error(formatTestError("post chirp with revoked token", err, "error"))
+	}
+}
+
+// Expiration times sent with a UTC offset expire at that instant, not the same wall-clock time in UTC
+func TestPersonalAccessTokenExpiresAtOffset(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	user, password := newTestUser(t, cfg)
+	loggedInUser, err := loginUser(cfg, user.Email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		offset int // Hours
+	}{
+		{name: "Ahead of UTC", offset: 5},
+		{name: "Behind UTC", offset: -5},
+	}
+
+	for _, c := range cases {
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second).In(time.FixedZone("", c.offset*60*60))
+		w := sendBearerRequest(cfg.createPersonalAccessTokenHandler(), "POST", "/api/tokens", loggedInUser.Token, `{"name": "Bot", "scopes": ["chirps:write"], "expires_at": "`+expiresAt.Format(time.RFC3339)+`"}`)
+		assertEquals(w.Result().StatusCode, http.StatusCreated, c.name, t)
+
+		createdToken := PersonalAccessToken{}
+		json.NewDecoder(w.Result().Body).Decode(&createdToken)
+		if createdToken.ExpiresAt == nil || !createdToken.ExpiresAt.Equal(expiresAt) {
+			t.Error(formatTestError(c.name, createdToken.ExpiresAt, expiresAt))
+		}
+
+		// Still valid for the hour
+		_, err = postChirp(cfg, createdToken.Token, "Posted by a bot")
+		if err != nil {
+			t.Error(formatTestError(c.name+", post chirp", err, nil))
+		}
+	}
+}
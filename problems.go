@@ -0,0 +1,84 @@
+package main
+
+import "net/http"
+
+// Error responses are RFC 9457 (formerly 7807) problem details: https://www.rfc-editor.org/rfc/rfc9457
+const PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+// Problem types, stable identifiers clients can switch on
+// Relative URIs, resolved against the API's address
+const (
+	PROBLEM_BAD_REQUEST       = "/problems/bad-request"
+	PROBLEM_INVALID_JSON      = "/problems/invalid-json"      // Body isn't JSON, or a field has the wrong JSON type
+	PROBLEM_VALIDATION_FAILED = "/problems/validation-failed" // Well-formed, but fields are missing or invalid, see Problem.Errors
+	PROBLEM_UNAUTHORIZED      = "/problems/unauthorized"      // Missing, invalid or expired credentials
+	PROBLEM_FORBIDDEN         = "/problems/forbidden"         // Authenticated, but not allowed
+	PROBLEM_NOT_FOUND         = "/problems/not-found"
+	PROBLEM_CONFLICT          = "/problems/conflict" // Conflicts with the resource's current state, ex: already exists
+	PROBLEM_PAYLOAD_TOO_LARGE = "/problems/payload-too-large"
+	PROBLEM_RATE_LIMITED      = "/problems/rate-limited" // See the Retry-After header
+	PROBLEM_INTERNAL_ERROR    = "/problems/internal-error"
+	PROBLEM_UPSTREAM_ERROR    = "/problems/upstream-error" // A service Chirpy depends on failed, ex: a login provider
+	PROBLEM_OTHER             = "about:blank"              // No further meaning than the status code
+)
+
+var problemTitles = map[string]string{
+	PROBLEM_BAD_REQUEST:       "Bad request",
+	PROBLEM_INVALID_JSON:      "Request body is not valid JSON",
+	PROBLEM_VALIDATION_FAILED: "Request failed validation",
+	PROBLEM_UNAUTHORIZED:      "Authentication required",
+	PROBLEM_FORBIDDEN:         "Not allowed",
+	PROBLEM_NOT_FOUND:         "Not found",
+	PROBLEM_CONFLICT:          "Conflict",
+	PROBLEM_PAYLOAD_TOO_LARGE: "Request body too large",
+	PROBLEM_RATE_LIMITED:      "Too many requests",
+	PROBLEM_INTERNAL_ERROR:    "Internal error",
+	PROBLEM_UPSTREAM_ERROR:    "Upstream service error",
+}
+
+// Type used when a handler only gives a status code
+var statusProblemTypes = map[int]string{
+	http.StatusBadRequest:            PROBLEM_BAD_REQUEST,
+	http.StatusUnauthorized:          PROBLEM_UNAUTHORIZED,
+	http.StatusForbidden:             PROBLEM_FORBIDDEN,
+	http.StatusNotFound:              PROBLEM_NOT_FOUND,
+	http.StatusConflict:              PROBLEM_CONFLICT,
+	http.StatusRequestEntityTooLarge: PROBLEM_PAYLOAD_TOO_LARGE,
+	http.StatusUnprocessableEntity:   PROBLEM_VALIDATION_FAILED,
+	http.StatusTooManyRequests:       PROBLEM_RATE_LIMITED,
+	http.StatusInternalServerError:   PROBLEM_INTERNAL_ERROR,
+	http.StatusBadGateway:            PROBLEM_UPSTREAM_ERROR,
+}
+
+// Field error codes
+const (
+	FIELD_REQUIRED = "required"
+	FIELD_INVALID  = "invalid"
+	FIELD_TOO_LONG = "too_long"
+	FIELD_UNKNOWN  = "unknown_value" // Not one of the allowed values
+)
+
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// One invalid request field, ex: {"field": "body", "code": "too_long", "message": "Chirp is too long"}
+// Password rules use their own codes, see auth.PasswordViolation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func problemTypeForStatus(statusCode int) string {
+	problemType, ok := statusProblemTypes[statusCode]
+	if !ok {
+		return PROBLEM_OTHER
+	}
+	return problemType
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONBody(t *testing.T) {
+	cases := []struct {
+		name               string
+		body               string
+		expectedOK         bool
+		expectedStatusCode int
+		expectedType       string
+		expectedField      string
+	}{
+		{
+			name:       "Valid",
+			body:       `{"body": "Hello", "count": 1}`,
+			expectedOK: true,
+		},
+		{
+			name:               "Malformed",
+			body:               `{"body": "Hello"`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedType:       PROBLEM_INVALID_JSON,
+		},
+		{
+			name:               "Not an object",
+			body:               `["Hello"]`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedType:       PROBLEM_INVALID_JSON,
+		},
+		{
+			name:               "Wrong field type",
+			body:               `{"body": "Hello", "count": "one"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedType:       PROBLEM_INVALID_JSON,
+			expectedField:      "count",
+		},
+		{
+			name:               "Too large",
+			body:               `{"body": "` + strings.Repeat("a", MAX_JSON_BODY_BYTES) + `"}`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+			expectedType:       PROBLEM_PAYLOAD_TOO_LARGE,
+		},
+	}
+
+	for _, c := range cases {
+		req := struct {
+			Body  string `json:"body"`
+			Count int    `json:"count"`
+		}{}
+
+		request := httptest.NewRequest("POST", "/api/things", strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		ok := decodeJSONBody(w, request, &req)
+
+		assertEquals(ok, c.expectedOK, c.name, t)
+		if ok {
+			continue
+		}
+
+		assertEquals(w.Result().StatusCode, c.expectedStatusCode, c.name, t)
+		assertEquals(w.Result().Header.Get("Content-Type"), PROBLEM_CONTENT_TYPE, c.name, t)
+
+		problem := Problem{}
+		err := json.NewDecoder(w.Result().Body).Decode(&problem)
+		if err != nil {
+			t.Error(formatTestError(c.name, err, "problem body"))
+			continue
+		}
+
+		assertEquals(problem.Type, c.expectedType, c.name, t)
+		assertEquals(problem.Status, c.expectedStatusCode, c.name, t)
+		if c.expectedField != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != c.expectedField) {
+			t.Error(formatTestError(c.name, problem.Errors, c.expectedField))
+		}
+	}
+}
+
+func TestErrorResponsesAreProblems(t *testing.T) {
+	cases := []struct {
+		name          string
+		send          func(w http.ResponseWriter)
+		expectedType  string
+		expectedTitle string
+		expectedCode  int
+	}{
+		{
+			name:          "Not found",
+			send:          func(w http.ResponseWriter) { sendErrorJSONResponse(w, "Chirp not found", http.StatusNotFound, nil) },
+			expectedType:  PROBLEM_NOT_FOUND,
+			expectedTitle: "Not found",
+			expectedCode:  http.StatusNotFound,
+		},
+		{
+			name:          "Status without a problem type",
+			send:          func(w http.ResponseWriter) { sendErrorJSONResponse(w, "Nope", http.StatusTeapot, nil) },
+			expectedType:  PROBLEM_OTHER,
+			expectedTitle: http.StatusText(http.StatusTeapot),
+			expectedCode:  http.StatusTeapot,
+		},
+		{
+			name:          "Error without a message",
+			send:          func(w http.ResponseWriter) { sendResponse(w, http.StatusForbidden, "") },
+			expectedType:  PROBLEM_FORBIDDEN,
+			expectedTitle: "Not allowed",
+			expectedCode:  http.StatusForbidden,
+		},
+		{
+			name: "Validation",
+			send: func(w http.ResponseWriter) {
+				sendValidationErrorResponse(w, FieldError{Field: "email", Code: FIELD_REQUIRED, Message: "Email required"})
+			},
+			expectedType:  PROBLEM_VALIDATION_FAILED,
+			expectedTitle: "Request failed validation",
+			expectedCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.send(w)
+
+		assertEquals(w.Result().StatusCode, c.expectedCode, c.name, t)
+		assertEquals(w.Result().Header.Get("Content-Type"), PROBLEM_CONTENT_TYPE, c.name, t)
+
+		problem := Problem{}
+		json.NewDecoder(w.Result().Body).Decode(&problem)
+		assertEquals(problem.Type, c.expectedType, c.name, t)
+		assertEquals(problem.Title, c.expectedTitle, c.name, t)
+		assertEquals(problem.Status, c.expectedCode, c.name, t)
+	}
+
+	// No body for successes
+	w := httptest.NewRecorder()
+	sendResponse(w, http.StatusNoContent, "")
+	assertEquals(w.Body.Len(), 0, "No content", t)
+}
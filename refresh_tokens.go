@@ -21,15 +21,15 @@ func (cfg *apiConfig) handlerRefresh() http.HandlerFunc {
 		}
 
 		// Check if it exists, is not revoked, or is not expired
-		refreshTokenInfo, err := cfg.db.GetRefreshTokenInfo(r.Context(), refreshToken)
+		refreshTokenInfo, err := cfg.refreshTokens.GetRefreshTokenInfo(r.Context(), refreshToken)
 
 		// 1. Refresh Token doesn't exist
 		if err == sql.ErrNoRows {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("refresh token %v doesn't exist: %v", refreshToken, err))
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("refresh token doesn't exist: %v", err))
 			return
 		}
 		if err != nil {
-			sendErrorJSONResponse(w, "", http.StatusUnauthorized, err)
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
@@ -78,13 +78,13 @@ func (cfg *apiConfig) handlerRevoke() http.HandlerFunc {
 		}
 
 		// Check if refresh token exists
-		existingRefreshTokenInfo, err := cfg.db.GetRefreshTokenInfo(r.Context(), refreshToken)
+		existingRefreshTokenInfo, err := cfg.refreshTokens.GetRefreshTokenInfo(r.Context(), refreshToken)
 		if err == sql.ErrNoRows {
-			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("refresh token %v doesn't exist: %v", refreshToken, err))
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("refresh token doesn't exist: %v", err))
 			return
 		}
 		if err != nil {
-			sendResponse(w, http.StatusUnauthorized, err.Error())
+			sendResponse(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
@@ -95,7 +95,7 @@ func (cfg *apiConfig) handlerRevoke() http.HandlerFunc {
 			Valid: true,
 		}
 
-		err = cfg.db.SetRefreshTokenRevokeAtTime(r.Context(), database.SetRefreshTokenRevokeAtTimeParams{
+		err = cfg.refreshTokens.SetRefreshTokenRevokeAtTime(r.Context(), database.SetRefreshTokenRevokeAtTimeParams{
 			Token:     existingRefreshTokenInfo.Token,
 			RevokedAt: *revokeTime,
 			UpdatedAt: time.Now(),
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshAndRevoke(t *testing.T) {
+	t.Parallel()
+
+	cfg := newMemoryApiConfig()
+
+	user, password := newTestUser(t, cfg)
+	loggedInUser := newTestTokens(t, cfg, user.Email, password)
+
+	cases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		token    string
+		expected int
+	}{
+		{
+			name:     "Refresh",
+			handler:  cfg.handlerRefresh(),
+			token:    loggedInUser.RefreshToken,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Refresh with unknown token",
+			handler:  cfg.handlerRefresh(),
+			token:    "not-a-refresh-token",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "Revoke",
+			handler:  cfg.handlerRevoke(),
+			token:    loggedInUser.RefreshToken,
+			expected: http.StatusNoContent,
+		},
+		{
+			name:     "Refresh with revoked token",
+			handler:  cfg.handlerRefresh(),
+			token:    loggedInUser.RefreshToken,
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest("POST", "/api/refresh", nil)
+		request.Header.Set("Authorization", "Bearer "+c.token)
+		w := httptest.NewRecorder()
+
+		c.handler(w, request)
+
+		assertEquals(w.Result().StatusCode, c.expected, c.name, t)
+	}
+}
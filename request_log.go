@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Identifies the request in logs and error responses
+// Callers (ex: a load balancer) may send their own, otherwise one is generated
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// Request IDs accepted from callers, anything else is replaced so it can't forge log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Per-request details for the access log, filled in while the request is handled
+type requestInfo struct {
+	id     string
+	userID uuid.UUID
+}
+
+type requestInfoKey struct{}
+
+// Assigns the request ID and logs every request once it's done:
+// method, path, route pattern, status, duration, and the user if one authenticated
+func middlewareRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		info := &requestInfo{id: requestID}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK, ctx: r.Context()}
+		next.ServeHTTP(recorder, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", r.Pattern,
+			"status", recorder.status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote_ip", clientIP(r),
+		}
+		if info.userID != uuid.Nil {
+			attrs = append(attrs, "user_id", info.userID)
+		}
+
+		requestLogger(r.Context()).Info("request", attrs...)
+	})
+}
+
+// Logger with the request's ID, trace ID and span ID, if any
+func requestLogger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		logger = logger.With("request_id", info.id)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		logger = logger.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+	}
+
+	return logger
+}
+
+// Adds the authenticated user to the access log
+func setRequestUserID(ctx context.Context, userID uuid.UUID) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.userID = userID
+	}
+}
+
+// Logs a message recorded with a response, at a level matching the status code
+// Response helpers only have the ResponseWriter, so the request's context comes from it, see responseContext
+func logWithResponse(w http.ResponseWriter, statusCode int, msg string) {
+	level := slog.LevelInfo
+	if statusCode >= http.StatusInternalServerError {
+		level = slog.LevelError
+	} else if statusCode >= http.StatusBadRequest {
+		level = slog.LevelWarn
+	}
+
+	ctx := responseContext(w)
+	requestLogger(ctx).Log(ctx, level, msg, "status", statusCode)
+}
+
+// Returns the context of the request the response is for, from middlewareRequestLog's recorder
+// Other middleware may wrap the recorder, so it's found through their Unwrap methods
+func responseContext(w http.ResponseWriter) context.Context {
+	for {
+		if recorder, ok := w.(*statusRecorder); ok && recorder.ctx != nil {
+			return recorder.ctx
+		}
+
+		wrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return context.Background()
+		}
+		w = wrapper.Unwrap()
+	}
+}
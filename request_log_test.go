@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LamontBanks/Chirpy/internal/logging"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRequestLog(t *testing.T) {
+	logs := bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&logs, slog.LevelInfo)))
+	defer slog.SetDefault(defaultLogger)
+
+	userID := uuid.New()
+	refreshToken := strings.Repeat("0f", 64)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		setRequestUserID(r.Context(), userID)
+		sendErrorJSONResponse(w, "Thing not found", http.StatusNotFound, errors.New("refresh token "+refreshToken+" doesn't exist"))
+	})
+	handler := middlewareRequestLog(mux)
+
+	cases := []struct {
+		name              string
+		requestID         string
+		expectedRequestID string
+	}{
+		{
+			name:              "Caller's request ID",
+			requestID:         "lb-1234.abc",
+			expectedRequestID: "lb-1234.abc",
+		},
+		{
+			name:      "Generated request ID",
+			requestID: "",
+		},
+		{
+			name:      "Unsafe request ID replaced",
+			requestID: "abc\n{\"forged\": true}",
+		},
+	}
+
+	for _, c := range cases {
+		logs.Reset()
+
+		request := httptest.NewRequest(http.MethodPost, "/api/things/123", nil)
+		request.Header.Set(REQUEST_ID_HEADER, c.requestID)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		// Header and error body share the request ID
+		requestID := recorder.Header().Get(REQUEST_ID_HEADER)
+		if c.expectedRequestID != "" && requestID != c.expectedRequestID {
+			t.Error(formatTestError(c.name, requestID, c.expectedRequestID))
+		}
+		if c.expectedRequestID == "" && uuid.Validate(requestID) != nil {
+			t.Error(formatTestError(c.name, requestID, "generated UUID"))
+		}
+
+		problem := Problem{}
+		json.NewDecoder(recorder.Body).Decode(&problem)
+		assertEquals(problem.RequestID, requestID, c.name+", error body request ID", t)
+
+		// Error log, then the access log
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatal(formatTestError(c.name, logs.String(), "2 log lines"))
+		}
+		if strings.Contains(logs.String(), refreshToken) {
+			t.Error(formatTestError(c.name, logs.String(), "refresh token redacted"))
+		}
+
+		errorLog := map[string]any{}
+		json.Unmarshal([]byte(lines[0]), &errorLog)
+		assertEquals(errorLog["level"], "WARN", c.name+", error log level", t)
+		assertEquals(errorLog["request_id"], requestID, c.name+", error log request ID", t)
+
+		accessLog := map[string]any{}
+		json.Unmarshal([]byte(lines[1]), &accessLog)
+		assertEquals(accessLog["request_id"], requestID, c.name+", access log request ID", t)
+		assertEquals(accessLog["route"], "POST /api/things/{thingID}", c.name+", access log route", t)
+		assertEquals(accessLog["status"], float64(http.StatusNotFound), c.name+", access log status", t)
+		assertEquals(accessLog["user_id"], userID.String(), c.name+", access log user", t)
+	}
+}
+
+func TestRequestLogTraceIDs(t *testing.T) {
+	logs := bytes.Buffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&logs, slog.LevelInfo)))
+	defer slog.SetDefault(defaultLogger)
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	spanID := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		spanID = trace.SpanContextFromContext(r.Context()).SpanID().String()
+		sendErrorJSONResponse(w, "Thing not found", http.StatusNotFound, errors.New("thing doesn't exist"))
+	})
+	// Metrics wraps the request log's recorder, so the response helpers have to unwrap it
+	handler := middlewareTracing(middlewareRequestLog(middlewareMetrics(mux)))
+
+	callerTraceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodDelete, "/api/things/123", nil)
+	request.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	// Error log, then the access log
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal(formatTestError("Log lines", logs.String(), "2 log lines"))
+	}
+
+	for i, name := range []string{"Error log", "Access log"} {
+		line := map[string]any{}
+		json.Unmarshal([]byte(lines[i]), &line)
+		assertEquals(line["trace_id"], callerTraceID, name+" trace ID", t)
+		assertEquals(line["span_id"], spanID, name+" span ID", t)
+	}
+}
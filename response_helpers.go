@@ -2,28 +2,135 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
 )
 
+// Largest JSON request body accepted by decodeJSONBody
+const MAX_JSON_BODY_BYTES = 1 << 20
+
+// Problem response with the type matching the status code, see problems.go
+// `msg` is the problem's detail, shown to the user
 func sendErrorJSONResponse(w http.ResponseWriter, msg string, statusCode int, errorToLog error) {
+	sendProblemResponse(w, Problem{
+		Type:   problemTypeForStatus(statusCode),
+		Status: statusCode,
+		Detail: msg,
+	}, errorToLog)
+}
+
+// Writes the problem as `application/problem+json`, filling in its title and the request ID,
+// so users can quote it when reporting a problem
+func sendProblemResponse(w http.ResponseWriter, problem Problem, errorToLog error) {
 	if errorToLog != nil {
-		log.Printf("%v", errorToLog)
+		logWithResponse(w, problem.Status, errorToLog.Error())
+	}
+
+	if problem.Title == "" {
+		problem.Title = problemTitles[problem.Type]
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	problem.RequestID = w.Header().Get(REQUEST_ID_HEADER)
+
+	data, err := json.Marshal(problem)
+	if err != nil {
+		logWithResponse(w, http.StatusInternalServerError, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	w.WriteHeader(problem.Status)
+	w.Write(data)
+}
+
+// 422 response listing every invalid field
+func sendValidationErrorResponse(w http.ResponseWriter, fieldErrors ...FieldError) {
+	detail := fieldErrors[0].Message
+	if len(fieldErrors) > 1 {
+		detail = fmt.Sprintf("%v fields are invalid", len(fieldErrors))
+	}
+
+	sendProblemResponse(w, Problem{
+		Type:   PROBLEM_VALIDATION_FAILED,
+		Status: http.StatusUnprocessableEntity,
+		Detail: detail,
+		Errors: fieldErrors,
+	}, nil)
+}
+
+// 422 response listing each password rule that failed, or 500 if the password couldn't be checked
+func sendPasswordPolicyErrorResponse(w http.ResponseWriter, err error) {
+	policyErr := &auth.PasswordPolicyError{}
+	if !errors.As(err, &policyErr) {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	fieldErrors := []FieldError{}
+	for _, violation := range policyErr.Violations {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   "password",
+			Code:    violation.Code,
+			Message: violation.Message,
+		})
 	}
 
-	errorResp := struct {
-		Error string `json:"error"`
-	}{
-		Error: msg,
+	sendProblemResponse(w, Problem{
+		Type:   PROBLEM_VALIDATION_FAILED,
+		Status: http.StatusUnprocessableEntity,
+		Detail: "Password does not meet requirements",
+		Errors: fieldErrors,
+	}, nil)
+}
+
+// Decodes the JSON request body into v
+// Otherwise sends a 400 (malformed JSON, or a field of the wrong type) or 413 (body too large) and returns false
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_JSON_BODY_BYTES)
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	maxBytesErr := &http.MaxBytesError{}
+	typeErr := &json.UnmarshalTypeError{}
+	switch {
+	case errors.As(err, &maxBytesErr):
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_PAYLOAD_TOO_LARGE,
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("Request body must be %v bytes or smaller", maxBytesErr.Limit),
+		}, nil)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		msg := fmt.Sprintf("%v has the wrong type, got a JSON %v", typeErr.Field, typeErr.Value)
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_INVALID_JSON,
+			Status: http.StatusBadRequest,
+			Detail: msg,
+			Errors: []FieldError{{Field: typeErr.Field, Code: FIELD_INVALID, Message: msg}},
+		}, err)
+	default:
+		sendProblemResponse(w, Problem{
+			Type:   PROBLEM_INVALID_JSON,
+			Status: http.StatusBadRequest,
+			Detail: "Request body must be a JSON object",
+		}, err)
 	}
 
-	SendJSONResponse(w, statusCode, errorResp)
+	return false
 }
 
 func SendJSONResponse(w http.ResponseWriter, statusCode int, jsonStruct any) {
 	data, err := json.Marshal(jsonStruct)
 	if err != nil {
-		log.Printf("%v", err)
+		logWithResponse(w, http.StatusInternalServerError, err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -33,11 +140,20 @@ func SendJSONResponse(w http.ResponseWriter, statusCode int, jsonStruct any) {
 	w.Write(data)
 }
 
+// Response without a body, or a problem with only the status code's title for errors
 func sendResponse(w http.ResponseWriter, statusCode int, msgToLog string) {
+	if statusCode >= http.StatusBadRequest {
+		var errorToLog error
+		if msgToLog != "" {
+			errorToLog = errors.New(msgToLog)
+		}
+		sendProblemResponse(w, Problem{Type: problemTypeForStatus(statusCode), Status: statusCode}, errorToLog)
+		return
+	}
+
 	if msgToLog != "" {
-		log.Printf("%v", msgToLog)
+		logWithResponse(w, statusCode, msgToLog)
 	}
 
-	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(statusCode)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// User roles, in increasing order of privilege
+// Stored in the `users.role` column
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// Returns true if the role is one of the known roles
+func (r Role) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Returns true if the role has the same or more privileges than `minRole`
+func (r Role) HasAtLeast(minRole Role) bool {
+	if !r.IsValid() || !minRole.IsValid() {
+		return false
+	}
+	return roleRanks[r] >= roleRanks[minRole]
+}
+
+// Only allows the request through if the bearer JWT belongs to a user with at least `minRole`
+// The role is read from the database on each request, so promotions/demotions take effect immediately
+func (cfg *apiConfig) middlewareRequireRole(minRole Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+
+		userID, err := auth.ValidateToken(token, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+		setRequestUserID(r.Context(), userID)
+
+		role, err := cfg.users.GetUserRole(r.Context(), userID)
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("invalid user %v", userID))
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		if !Role(role).HasAtLeast(minRole) {
+			sendErrorJSONResponse(w, "Forbidden", http.StatusForbidden, fmt.Errorf("user %v with role %v attempted to access %v %v (requires %v)", userID, role, r.Method, r.URL.Path, minRole))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Changes the role of the user in the path
+// Admin-only, see the /admin/ routes in main()
+func (cfg *apiConfig) setUserRoleHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Role Role `json:"role"`
+		}{}
+
+		userID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			sendErrorJSONResponse(w, "User not found", http.StatusNotFound, err)
+			return
+		}
+
+		// Decode request, validate role
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		if !req.Role.IsValid() {
+			sendValidationErrorResponse(w, FieldError{Field: "role", Code: FIELD_UNKNOWN, Message: fmt.Sprintf("Invalid role, must be one of: %v, %v, %v", RoleUser, RoleModerator, RoleAdmin)})
+			return
+		}
+
+		// Update role
+		updatedUser, err := cfg.users.SetUserRole(r.Context(), database.SetUserRoleParams{
+			ID:        userID,
+			Role:      string(req.Role),
+			UpdatedAt: time.Now(),
+		})
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "User not found", http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusOK, User{
+			ID:          updatedUser.ID,
+			Email:       updatedUser.Email,
+			CreatedAt:   updatedUser.CreatedAt,
+			UpdatedAt:   updatedUser.UpdatedAt,
+			IsChirpyRed: updatedUser.IsChirpyRed,
+			Role:        updatedUser.Role,
+		})
+	}
+}
+
+// Promotes the user with the given email to admin
+// Only allowed while there are no admins - after that, roles are managed through PUT /admin/users/{userID}/role
+func (cfg *apiConfig) bootstrapAdmin(ctx context.Context, email string) error {
+	numAdmins, err := cfg.users.CountUsersWithRole(ctx, string(RoleAdmin))
+	if err != nil {
+		return err
+	}
+	if numAdmins > 0 {
+		return fmt.Errorf("an admin already exists, use PUT /admin/users/{userID}/role or `chirpy user promote` instead")
+	}
+
+	user, err := cfg.users.GetUserByEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user with email %v", email)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.users.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:        user.ID,
+		Role:      string(RoleAdmin),
+		UpdatedAt: time.Now(),
+	})
+
+	return err
+}
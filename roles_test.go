@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+)
+
+func TestRoleHasAtLeast(t *testing.T) {
+	cases := []struct {
+		role     Role
+		minRole  Role
+		expected bool
+	}{
+		{role: RoleUser, minRole: RoleUser, expected: true},
+		{role: RoleUser, minRole: RoleModerator, expected: false},
+		{role: RoleUser, minRole: RoleAdmin, expected: false},
+		{role: RoleModerator, minRole: RoleUser, expected: true},
+		{role: RoleModerator, minRole: RoleModerator, expected: true},
+		{role: RoleModerator, minRole: RoleAdmin, expected: false},
+		{role: RoleAdmin, minRole: RoleModerator, expected: true},
+		{role: RoleAdmin, minRole: RoleAdmin, expected: true},
+		{role: Role("superuser"), minRole: RoleUser, expected: false},
+		{role: RoleAdmin, minRole: Role(""), expected: false},
+	}
+
+	for _, c := range cases {
+		actual := c.role.HasAtLeast(c.minRole)
+		assertEquals(actual, c.expected, c, t)
+	}
+}
+
+func TestMiddlewareRequireRole(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	users, passwords, err := createTestUsers(cfg, 3)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Assign each user a different role
+	roles := []Role{RoleUser, RoleModerator, RoleAdmin}
+	tokens := []string{}
+	for i := range users {
+		_, err := cfg.users.SetUserRole(context.Background(), database.SetUserRoleParams{
+			ID:        users[i].ID,
+			Role:      string(roles[i]),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		loggedInUser, err := loginUser(cfg, users[i].Email, passwords[i])
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		tokens = append(tokens, loggedInUser.Token)
+	}
+
+	cases := []struct {
+		name             string
+		token            string
+		minRole          Role
+		expectedRespCode int
+	}{
+		{
+			name:             "Missing token",
+			token:            "",
+			minRole:          RoleModerator,
+			expectedRespCode: http.StatusUnauthorized,
+		},
+		{
+			name:             "User denied moderator route",
+			token:            tokens[0],
+			minRole:          RoleModerator,
+			expectedRespCode: http.StatusForbidden,
+		},
+		{
+			name:             "Moderator allowed moderator route",
+			token:            tokens[1],
+			minRole:          RoleModerator,
+			expectedRespCode: http.StatusOK,
+		},
+		{
+			name:             "Moderator denied admin route",
+			token:            tokens[1],
+			minRole:          RoleAdmin,
+			expectedRespCode: http.StatusForbidden,
+		},
+		{
+			name:             "Admin allowed admin route",
+			token:            tokens[2],
+			minRole:          RoleAdmin,
+			expectedRespCode: http.StatusOK,
+		},
+	}
+
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", "/admin/metrics", nil)
+		if c.token != "" {
+			request.Header.Add("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+
+		cfg.middlewareRequireRole(c.minRole, okHandler).ServeHTTP(w, request)
+
+		assertEquals(w.Result().StatusCode, c.expectedRespCode, c.name, t)
+	}
+}
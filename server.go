@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Slow or stalled clients are disconnected rather than holding connections open
+const (
+	SERVER_READ_HEADER_TIMEOUT = 5 * time.Second
+	SERVER_READ_TIMEOUT        = 15 * time.Second
+	SERVER_WRITE_TIMEOUT       = 30 * time.Second
+	SERVER_IDLE_TIMEOUT        = 2 * time.Minute
+
+	// In-flight requests get this long to finish once shutdown starts
+	SERVER_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+	// Largest request body for any endpoint
+	MAX_REQUEST_BODY_BYTES = 1 << 20
+)
+
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: SERVER_READ_HEADER_TIMEOUT,
+		ReadTimeout:       SERVER_READ_TIMEOUT,
+		WriteTimeout:      SERVER_WRITE_TIMEOUT,
+		IdleTimeout:       SERVER_IDLE_TIMEOUT,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// Serves until the context is canceled (ex: SIGTERM), then stops accepting connections
+// and waits up to SERVER_SHUTDOWN_TIMEOUT for in-flight requests to finish
+// Requests are still served during drainDelay, giving load balancers time to notice GET /api/readyz failing
+func serveUntilDone(ctx context.Context, server *http.Server, listener net.Listener, drainDelay time.Duration) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	if drainDelay > 0 {
+		slog.Info("Shutting down, draining", "delay", drainDelay)
+		time.Sleep(drainDelay)
+	}
+
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", SERVER_SHUTDOWN_TIMEOUT)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVER_SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		return fmt.Errorf("requests still running after %v: %w", SERVER_SHUTDOWN_TIMEOUT, err)
+	}
+
+	// Serve returns ErrServerClosed as soon as Shutdown is called
+	err = <-serverErr
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Turns a panicking handler into a 500, instead of the connection being dropped
+func middlewareRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Deliberately aborted response, see http.ErrAbortHandler
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestLogger(r.Context()).Error("Handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			if !recorder.wroteHeader {
+				sendErrorJSONResponse(recorder, "Something went wrong", http.StatusInternalServerError, nil)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// Rejects request bodies over MAX_REQUEST_BODY_BYTES, reading past the limit fails with http.MaxBytesError
+func middlewareMaxBodyBytes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > MAX_REQUEST_BODY_BYTES {
+			sendErrorJSONResponse(w, fmt.Sprintf("Request body must be %v bytes or smaller", MAX_REQUEST_BODY_BYTES), http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY_BYTES)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeUntilDoneDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serveUntilDone(ctx, newServer(listener.Addr().String(), mux), listener, 0)
+	}()
+
+	// Shut down while the request is in flight
+	responseBody := make(chan string, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			responseBody <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		responseBody <- string(body)
+	}()
+
+	<-started
+	cancel()
+
+	assertEquals(<-responseBody, "done", "In-flight request", t)
+	assertEquals(<-serveErr, nil, "Serve error", t)
+
+	// No new connections
+	_, err = http.Get("http://" + listener.Addr().String() + "/slow")
+	if err == nil {
+		t.Error(formatTestError("Request after shutdown", err, "connection refused"))
+	}
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	handler := middlewareRecover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/things", nil))
+
+	assertEquals(w.Result().StatusCode, http.StatusInternalServerError, "Panic", t)
+	assertEquals(w.Result().Header.Get("Content-Type"), PROBLEM_CONTENT_TYPE, "Panic", t)
+}
+
+func TestMiddlewareMaxBodyBytes(t *testing.T) {
+	handler := middlewareMaxBodyBytes(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendErrorJSONResponse(w, "Too large", http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cases := []struct {
+		name               string
+		body               io.Reader
+		expectedStatusCode int
+	}{
+		{
+			name:               "Within the limit",
+			body:               strings.NewReader(strings.Repeat("a", MAX_REQUEST_BODY_BYTES)),
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "Content-Length over the limit",
+			body:               strings.NewReader(strings.Repeat("a", MAX_REQUEST_BODY_BYTES+1)),
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			// No Content-Length, only caught while reading
+			name:               "Streamed over the limit",
+			body:               io.MultiReader(strings.NewReader(strings.Repeat("a", MAX_REQUEST_BODY_BYTES)), strings.NewReader("a")),
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/api/things", c.body))
+
+		assertEquals(w.Result().StatusCode, c.expectedStatusCode, c.name, t)
+		if c.expectedStatusCode != http.StatusNoContent {
+			problem := Problem{}
+			json.NewDecoder(w.Result().Body).Decode(&problem)
+			assertEquals(problem.Type, PROBLEM_PAYLOAD_TOO_LARGE, c.name, t)
+		}
+	}
+}
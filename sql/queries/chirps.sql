@@ -25,4 +25,10 @@ ORDER BY created_at ASC;
 
 -- name: GetChirpByID :one
 SELECT * FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, updated_at = $3
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,76 @@
+-- name: CreateInboundWebhook :one
+INSERT INTO inbound_webhooks (id, received_at, updated_at, source, headers, body, status)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    'received'
+)
+RETURNING *;
+
+-- name: GetInboundWebhook :one
+SELECT * FROM inbound_webhooks
+WHERE id = $1;
+
+-- Newest first
+-- name: GetInboundWebhooksByStatus :many
+SELECT * FROM inbound_webhooks
+WHERE status = $1
+ORDER BY received_at DESC
+LIMIT $2;
+
+-- Marks the delivery as processing the event until the lease expires, unless another delivery of the event is processing or processed it
+-- 0 rows if the event was already handled
+-- name: ClaimInboundWebhook :execrows
+UPDATE inbound_webhooks
+SET event_id = sqlc.arg(event_id),
+    event = sqlc.arg(event),
+    status = 'processing',
+    processing_until = sqlc.arg(processing_until),
+    attempts = attempts + 1,
+    error = NULL,
+    updated_at = sqlc.arg(updated_at)
+WHERE inbound_webhooks.id = sqlc.arg(id)
+    AND inbound_webhooks.status IN ('received', 'failed')
+    AND NOT EXISTS (
+    SELECT 1 FROM inbound_webhooks AS other
+    WHERE other.source = inbound_webhooks.source
+        AND other.event_id = sqlc.arg(event_id)
+        AND other.status IN ('processing', 'processed')
+        AND other.id <> sqlc.arg(id)
+);
+
+-- name: SetInboundWebhookStatus :exec
+UPDATE inbound_webhooks
+SET status = $2, error = $3, updated_at = $4, processing_until = NULL
+WHERE id = $1;
+
+-- Fails deliveries whose processing lease expired (ex: the server crashed), so their events can be claimed again
+-- name: ExpireInboundWebhookLeases :exec
+UPDATE inbound_webhooks
+SET status = 'failed',
+    error = 'processing lease expired',
+    processing_until = NULL,
+    updated_at = sqlc.arg(now)
+WHERE status = 'processing' AND processing_until <= sqlc.arg(now);
+
+-- Deliveries last updated before the cutoff, except any still processing
+-- Their events are forgotten too, so a retry of one of them would be processed again
+-- name: DeleteInboundWebhooksBefore :execrows
+DELETE FROM inbound_webhooks
+WHERE status <> 'processing' AND updated_at < sqlc.arg(before);
+
+-- Marks the delivery processed, in the same transaction as the event's changes
+-- 0 rows if its lease expired, then the transaction must be rolled back since the event may be claimed again
+-- name: FinishInboundWebhookProcessing :execrows
+UPDATE inbound_webhooks
+SET status = 'processed',
+    error = NULL,
+    processing_until = NULL,
+    updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id)
+    AND status = 'processing'
+    AND processing_until > sqlc.arg(now);
@@ -0,0 +1,119 @@
+-- name: CreateOAuthApp :one
+INSERT INTO oauth_apps (id, created_at, updated_at, owner_id, name, client_secret_hash, redirect_uris)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING *;
+
+-- name: GetOAuthApp :one
+SELECT * FROM oauth_apps
+WHERE id = $1;
+
+-- Adds the newly granted scopes to any the user already granted the app
+-- name: UpsertOAuthAuthorization :one
+INSERT INTO oauth_authorizations (id, created_at, updated_at, user_id, app_id, scopes)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (user_id, app_id) DO UPDATE
+SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_authorizations.scopes || EXCLUDED.scopes) ORDER BY 1),
+    updated_at = EXCLUDED.updated_at
+RETURNING *;
+
+-- name: GetOAuthAuthorization :one
+SELECT * FROM oauth_authorizations
+WHERE user_id = $1 AND app_id = $2;
+
+-- name: GetOAuthAuthorizationByID :one
+SELECT * FROM oauth_authorizations
+WHERE id = $1;
+
+-- name: GetAuthorizedOAuthApps :many
+SELECT oauth_apps.id, oauth_apps.name, oauth_authorizations.scopes, oauth_authorizations.created_at AS authorized_at
+FROM oauth_authorizations
+JOIN oauth_apps ON oauth_apps.id = oauth_authorizations.app_id
+WHERE oauth_authorizations.user_id = $1
+ORDER BY oauth_authorizations.created_at ASC;
+
+-- name: DeleteOAuthAuthorization :execrows
+DELETE FROM oauth_authorizations
+WHERE user_id = $1 AND app_id = $2;
+
+-- Revokes the authorization, with its codes and tokens
+-- name: DeleteOAuthAuthorizationByID :exec
+DELETE FROM oauth_authorizations
+WHERE id = $1;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, created_at, expires_at, authorization_id, redirect_uri, scopes, code_challenge)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- Locks the code until the transaction ends, so it's only exchanged once
+-- name: GetOAuthCodeForUpdate :one
+SELECT * FROM oauth_codes
+WHERE code_hash = $1
+FOR UPDATE;
+
+-- Codes are single-use, used ones are kept until they expire to detect reuse
+-- name: UseOAuthCode :exec
+UPDATE oauth_codes
+SET used_at = $2
+WHERE code_hash = $1;
+
+-- name: DeleteExpiredOAuthCodes :exec
+DELETE FROM oauth_codes
+WHERE expires_at < $1;
+
+-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, expires_at, authorization_id, kind, scopes)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- Locks the refresh token until the transaction ends, so it's only used once
+-- name: GetOAuthRefreshTokenForUpdate :one
+SELECT * FROM oauth_tokens
+WHERE token_hash = $1 AND kind = 'refresh'
+FOR UPDATE;
+
+-- Refresh tokens are rotated, each one is deleted as it's used
+-- name: DeleteOAuthToken :exec
+DELETE FROM oauth_tokens
+WHERE token_hash = $1;
+
+-- name: GetOAuthAccessToken :one
+SELECT oauth_tokens.expires_at, oauth_tokens.scopes, oauth_authorizations.user_id, oauth_authorizations.app_id
+FROM oauth_tokens
+JOIN oauth_authorizations ON oauth_authorizations.id = oauth_tokens.authorization_id
+WHERE oauth_tokens.token_hash = $1 AND oauth_tokens.kind = 'access';
+
+-- name: DeleteExpiredOAuthTokens :exec
+DELETE FROM oauth_tokens
+WHERE expires_at < $1;
+
+-- name: DeleteAllOAuthTokens :execrows
+DELETE FROM oauth_tokens;
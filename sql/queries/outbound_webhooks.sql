@@ -0,0 +1,74 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, url, secret, event_types)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+ORDER BY created_at ASC;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1;
+
+-- Queues the event for every endpoint subscribed to its type
+-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), sqlc.arg(now), sqlc.arg(now), webhook_endpoints.id, sqlc.arg(event_id), sqlc.arg(event_type), sqlc.arg(payload), 'pending', sqlc.arg(now)
+FROM webhook_endpoints
+WHERE sqlc.arg(event_type)::TEXT = ANY(webhook_endpoints.event_types);
+
+-- Takes due deliveries off the queue until `lease_until`, so they aren't sent twice by concurrent workers
+-- If the worker stops before recording the result, the delivery is retried after the lease
+-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg(lease_until), updated_at = sqlc.arg(now)
+FROM webhook_endpoints
+WHERE webhook_endpoints.id = webhook_deliveries.endpoint_id
+    AND webhook_deliveries.id IN (
+        SELECT due.id FROM webhook_deliveries AS due
+        WHERE due.status = 'pending' AND due.next_attempt_at <= sqlc.arg(now)
+        ORDER BY due.next_attempt_at
+        LIMIT sqlc.arg(max_deliveries)
+        FOR UPDATE SKIP LOCKED
+    )
+RETURNING webhook_deliveries.*, webhook_endpoints.url, webhook_endpoints.secret;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts (id, attempted_at, delivery_id, status_code, error, duration_ms)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: SetWebhookDeliveryResult :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = $3, next_attempt_at = $4, updated_at = $5
+WHERE id = $1;
+
+-- Sends a dead-lettered delivery again, as if it were new
+-- name: RequeueWebhookDelivery :execrows
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = sqlc.arg(now), updated_at = sqlc.arg(now)
+WHERE id = sqlc.arg(id) AND status = 'dead';
+
+-- Newest first
+-- name: GetWebhookDeliveryAttempts :many
+SELECT webhook_delivery_attempts.*, webhook_deliveries.event_id, webhook_deliveries.event_type,
+    webhook_deliveries.status AS delivery_status
+FROM webhook_delivery_attempts
+JOIN webhook_deliveries ON webhook_deliveries.id = webhook_delivery_attempts.delivery_id
+WHERE webhook_deliveries.endpoint_id = $1
+ORDER BY webhook_delivery_attempts.attempted_at DESC
+LIMIT $2;
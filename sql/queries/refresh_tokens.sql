@@ -17,3 +17,8 @@ WHERE token = $1;
 UPDATE refresh_tokens
 SET revoked_at = $2, updated_at = $3
 WHERE token = $1;
+
+-- name: RevokeAllRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = $1, updated_at = $1
+WHERE revoked_at IS NULL;
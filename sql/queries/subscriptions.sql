@@ -0,0 +1,87 @@
+-- Starts a new period, replacing any earlier subscription
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    updated_at = EXCLUDED.updated_at
+RETURNING *;
+
+-- name: UpdateSubscription :one
+UPDATE subscriptions
+SET status = sqlc.arg(status),
+    current_period_start = sqlc.arg(current_period_start),
+    current_period_end = sqlc.arg(current_period_end),
+    updated_at = sqlc.arg(updated_at)
+WHERE user_id = sqlc.arg(user_id)
+RETURNING *;
+
+-- name: GetSubscriptionByUserID :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- Locks the row until the transaction ends, so concurrent events for the user apply one at a time
+-- name: GetSubscriptionByUserIDForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: CreateSubscriptionEvent :exec
+INSERT INTO subscription_events (id, created_at, subscription_id, event, polka_event_id, status, current_period_end)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: GetSubscriptionEvents :many
+SELECT * FROM subscription_events
+WHERE subscription_id = $1
+ORDER BY created_at ASC;
+
+-- Subscriptions whose period ended without a renewal
+-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired', updated_at = sqlc.arg(now)
+WHERE status <> 'expired' AND current_period_end <= sqlc.arg(now)
+RETURNING *;
+
+-- Sets is_chirpy_red from the user's subscription
+-- name: RefreshUserChirpyRed :one
+UPDATE users
+SET is_chirpy_red = EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+        AND subscriptions.status <> 'expired'
+        AND subscriptions.current_period_end > sqlc.arg(now)
+)
+WHERE users.id = sqlc.arg(user_id)
+RETURNING users.id, users.is_chirpy_red;
+
+-- Turns off is_chirpy_red for every user without a current subscription
+-- name: RevokeLapsedChirpyRed :many
+UPDATE users
+SET is_chirpy_red = false
+WHERE is_chirpy_red AND NOT EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+        AND subscriptions.status <> 'expired'
+        AND subscriptions.current_period_end > sqlc.arg(now)
+)
+RETURNING users.id;
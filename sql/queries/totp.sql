@@ -0,0 +1,40 @@
+-- name: SetUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2, totp_enabled = false, updated_at = $3
+WHERE id = $1;
+
+-- Replaces the secret without resetting enrollment, ex: to encrypt a secret saved before encryption
+-- name: ReplaceUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $2
+WHERE id = $1;
+
+-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled = true, totp_last_step = $2, updated_at = $3
+WHERE id = $1;
+
+-- Only succeeds (1 row affected) if the step is newer than the last accepted one
+-- name: SetUserTOTPLastStep :execrows
+UPDATE users
+SET totp_last_step = $2
+WHERE id = $1 AND totp_last_step < $2;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO totp_recovery_codes (id, created_at, user_id, code_hash)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM totp_recovery_codes
+WHERE user_id = $1;
+
+-- Only succeeds (1 row affected) if the code exists and hasn't been used
+-- name: UseRecoveryCode :execrows
+UPDATE totp_recovery_codes
+SET used_at = $3
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
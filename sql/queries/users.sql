@@ -32,8 +32,29 @@ WHERE id = $1;
 SELECT * FROM users
 WHERE email = $1;
 
--- name: UpgradeUserToChirpyRed :one
+-- name: GetUserRole :one
+SELECT role FROM users
+WHERE id = $1;
+
+-- name: SetUserRole :one
 UPDATE users
-SET is_chirpy_red = true
+SET role = $2, updated_at = $3
 WHERE id = $1
-RETURNING id, is_chirpy_red;
+RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users
+WHERE role = $1;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = sqlc.arg(new_hashed_password)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hashed_password);
+
+-- name: DeleteUserByEmail :execrows
+DELETE FROM users
+WHERE email = $1;
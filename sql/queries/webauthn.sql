@@ -0,0 +1,48 @@
+-- name: CreateWebAuthnCredential :one
+INSERT INTO webauthn_credentials (id, created_at, updated_at, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    $11,
+    $12
+)
+RETURNING *;
+
+-- name: GetWebAuthnCredentialsByUserID :many
+SELECT * FROM webauthn_credentials
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: UpdateWebAuthnCredentialUsage :exec
+UPDATE webauthn_credentials
+SET sign_count = $2, backup_state = $3, last_used_at = $4, updated_at = $4
+WHERE id = $1;
+
+-- name: CreateWebAuthnSession :exec
+INSERT INTO webauthn_sessions (id, created_at, expires_at, user_id, ceremony, session_data)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- Sessions are single-use, so they're deleted as they're read
+-- name: ConsumeWebAuthnSession :one
+DELETE FROM webauthn_sessions
+WHERE id = $1 AND ceremony = $2
+RETURNING *;
+
+-- name: DeleteExpiredWebAuthnSessions :exec
+DELETE FROM webauthn_sessions
+WHERE expires_at < $1;
@@ -0,0 +1,11 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN  role    TEXT    NOT NULL
+                            DEFAULT 'user'
+                            CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- +goose Down
+ALTER TABLE     users
+DROP COLUMN     role;
@@ -0,0 +1,29 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN  totp_secret     TEXT,   -- NULL until the user starts 2FA enrollment
+ADD COLUMN  totp_enabled    boolean NOT NULL
+                                    DEFAULT false,
+ADD COLUMN  totp_last_step  bigint  NOT NULL
+                                    DEFAULT 0; -- Last accepted TOTP time step, prevents reusing a code
+
+CREATE TABLE totp_recovery_codes (
+    id          uuid        PRIMARY KEY,
+    created_at  timestamp   NOT NULL
+                            DEFAULT CURRENT_TIMESTAMP,
+    user_id     uuid        NOT NULL
+                            REFERENCES users
+                            -- DELETE this row if the user_id is deleted in `users`
+                            ON DELETE CASCADE,
+    code_hash   TEXT        NOT NULL,
+    used_at     timestamp   -- NULL if the code has not been used
+);
+
+-- +goose Down
+DROP TABLE totp_recovery_codes;
+
+ALTER TABLE     users
+DROP COLUMN     totp_secret,
+DROP COLUMN     totp_enabled,
+DROP COLUMN     totp_last_step;
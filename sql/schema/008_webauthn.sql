@@ -0,0 +1,40 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+CREATE TABLE webauthn_credentials (
+    id                  bytea       PRIMARY KEY, -- Credential ID chosen by the authenticator
+    created_at          timestamp   NOT NULL
+                                    DEFAULT CURRENT_TIMESTAMP,
+    updated_at          timestamp   NOT NULL
+                                    DEFAULT CURRENT_TIMESTAMP,
+    user_id             uuid        NOT NULL
+                                    REFERENCES users
+                                    -- DELETE this row if the user_id is deleted in `users`
+                                    ON DELETE CASCADE,
+    name                TEXT        NOT NULL,
+    public_key          bytea       NOT NULL, -- COSE-encoded
+    attestation_type    TEXT        NOT NULL,
+    transports          TEXT[]      NOT NULL,
+    aaguid              bytea       NOT NULL,
+    sign_count          bigint      NOT NULL,
+    backup_eligible     boolean     NOT NULL,
+    backup_state        boolean     NOT NULL,
+    last_used_at        timestamp   -- NULL if the passkey has never been used to log in
+);
+
+-- Pending registration/login ceremonies, each row is used once
+CREATE TABLE webauthn_sessions (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    expires_at      timestamp   NOT NULL,
+    user_id         uuid        REFERENCES users -- NULL for passkey logins, the user isn't known until the assertion
+                                ON DELETE CASCADE,
+    ceremony        TEXT        NOT NULL
+                                CHECK (ceremony IN ('registration', 'login')),
+    session_data    jsonb       NOT NULL
+);
+
+-- +goose Down
+DROP TABLE webauthn_sessions;
+DROP TABLE webauthn_credentials;
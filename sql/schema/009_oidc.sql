@@ -0,0 +1,34 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Accounts at external OpenID Connect providers, linked to Chirpy users
+CREATE TABLE user_identities (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    updated_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    user_id         uuid        NOT NULL
+                                REFERENCES users
+                                -- DELETE this row if the user_id is deleted in `users`
+                                ON DELETE CASCADE,
+    provider        TEXT        NOT NULL, -- Name from OIDC_PROVIDERS, ex: "google"
+    subject         TEXT        NOT NULL, -- ID token `sub` claim, the provider's stable user ID
+    email           TEXT        NOT NULL, -- Email the provider reported when the identity was linked
+    UNIQUE (provider, subject)
+);
+
+-- Pending authorization code flows, each row is used once
+CREATE TABLE oidc_login_states (
+    state           TEXT        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    expires_at      timestamp   NOT NULL,
+    provider        TEXT        NOT NULL,
+    nonce           TEXT        NOT NULL,
+    code_verifier   TEXT        NOT NULL -- PKCE
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE user_identities;
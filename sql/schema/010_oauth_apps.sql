@@ -0,0 +1,69 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Third-party apps, `id` is the OAuth client_id
+CREATE TABLE oauth_apps (
+    id                  uuid        PRIMARY KEY,
+    created_at          timestamp   NOT NULL
+                                    DEFAULT CURRENT_TIMESTAMP,
+    updated_at          timestamp   NOT NULL
+                                    DEFAULT CURRENT_TIMESTAMP,
+    owner_id            uuid        NOT NULL
+                                    REFERENCES users
+                                    ON DELETE CASCADE,
+    name                TEXT        NOT NULL,
+    client_secret_hash  TEXT,       -- NULL for public clients (mobile/browser apps), which rely on PKCE alone
+    redirect_uris       TEXT[]      NOT NULL
+);
+
+-- Apps a user has consented to, and the scopes they've granted
+CREATE TABLE oauth_authorizations (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    updated_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    user_id         uuid        NOT NULL
+                                REFERENCES users
+                                ON DELETE CASCADE,
+    app_id          uuid        NOT NULL
+                                REFERENCES oauth_apps
+                                ON DELETE CASCADE,
+    scopes          TEXT[]      NOT NULL,
+    UNIQUE (user_id, app_id)
+);
+
+-- Authorization codes, each row is used once
+CREATE TABLE oauth_codes (
+    code_hash       TEXT        PRIMARY KEY, -- SHA-256, the code itself isn't stored
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    expires_at      timestamp   NOT NULL,
+    authorization_id uuid       NOT NULL
+                                REFERENCES oauth_authorizations
+                                ON DELETE CASCADE,
+    redirect_uri    TEXT        NOT NULL,
+    scopes          TEXT[]      NOT NULL,
+    code_challenge  TEXT        NOT NULL -- PKCE, S256 only
+);
+
+-- Access and refresh tokens issued to apps
+-- Revoking an app deletes its authorization, and every token with it
+CREATE TABLE oauth_tokens (
+    token_hash      TEXT        PRIMARY KEY, -- SHA-256, the token itself isn't stored
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    expires_at      timestamp   NOT NULL,
+    authorization_id uuid       NOT NULL
+                                REFERENCES oauth_authorizations
+                                ON DELETE CASCADE,
+    kind            TEXT        NOT NULL
+                                CHECK (kind IN ('access', 'refresh')),
+    scopes          TEXT[]      NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oauth_tokens;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_authorizations;
+DROP TABLE oauth_apps;
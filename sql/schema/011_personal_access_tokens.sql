@@ -0,0 +1,22 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Long-lived tokens for bots and scripts, sent as `Authorization: Bearer <token>`
+CREATE TABLE personal_access_tokens (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    user_id         uuid        NOT NULL
+                                REFERENCES users
+                                -- DELETE this row if the user_id is deleted in `users`
+                                ON DELETE CASCADE,
+    name            TEXT        NOT NULL, -- ex: "Deploy bot"
+    token_hash      TEXT        NOT NULL
+                                UNIQUE, -- SHA-256, the token itself is only shown once
+    scopes          TEXT[]      NOT NULL,
+    expires_at      timestamp,  -- NULL if the token never expires
+    last_used_at    timestamp   -- NULL if the token has never been used
+);
+
+-- +goose Down
+DROP TABLE personal_access_tokens;
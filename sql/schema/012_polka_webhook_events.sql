@@ -0,0 +1,13 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- IDs of Polka webhook events already handled, so replayed deliveries are ignored
+CREATE TABLE polka_webhook_events (
+    event_id        TEXT        PRIMARY KEY, -- "id" from the signed webhook body
+    event           TEXT        NOT NULL, -- ex: "user.upgraded"
+    received_at     timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE polka_webhook_events;
@@ -0,0 +1,45 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Paid plans billed through Polka, one per user
+-- `users.is_chirpy_red` is kept in sync: true while the subscription isn't expired and its period hasn't ended
+CREATE TABLE subscriptions (
+    id                      uuid        PRIMARY KEY,
+    created_at              timestamp   NOT NULL
+                                        DEFAULT CURRENT_TIMESTAMP,
+    updated_at              timestamp   NOT NULL
+                                        DEFAULT CURRENT_TIMESTAMP,
+    user_id                 uuid        NOT NULL
+                                        UNIQUE
+                                        REFERENCES users
+                                        ON DELETE CASCADE,
+    plan                    TEXT        NOT NULL, -- ex: "chirpy_red"
+    status                  TEXT        NOT NULL
+                                        CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_start    timestamp   NOT NULL,
+    current_period_end      timestamp   NOT NULL
+);
+
+-- Every change to a subscription, newest last
+CREATE TABLE subscription_events (
+    id                  uuid        PRIMARY KEY,
+    created_at          timestamp   NOT NULL
+                                    DEFAULT CURRENT_TIMESTAMP,
+    subscription_id     uuid        NOT NULL
+                                    REFERENCES subscriptions
+                                    ON DELETE CASCADE,
+    event               TEXT        NOT NULL, -- Polka event, or "subscription.expired" from the expiry job
+    polka_event_id      TEXT,       -- NULL for changes Chirpy made itself
+    status              TEXT        NOT NULL,
+    current_period_end  timestamp   NOT NULL
+);
+
+-- Users upgraded before subscriptions were tracked get a period to be renewed by Polka
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_start, current_period_end)
+SELECT gen_random_uuid(), id, 'chirpy_red', 'active', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + interval '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
@@ -0,0 +1,50 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Every webhook delivery received, saved before it's processed so failed events can be inspected and replayed
+-- Retries of an event are separate deliveries with the same event_id
+CREATE TABLE inbound_webhooks (
+    id              uuid        PRIMARY KEY,
+    received_at     timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    updated_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    source          TEXT        NOT NULL, -- ex: "polka"
+    headers         jsonb       NOT NULL, -- Without credentials, ex: Authorization
+    body            bytea       NOT NULL, -- Exactly as received, signatures are over these bytes
+    event_id        TEXT,       -- NULL until the body is verified and decoded
+    event           TEXT,       -- ex: "user.upgraded"
+    status          TEXT        NOT NULL
+                                CHECK (status IN ('received', 'rejected', 'ignored', 'duplicate', 'processing', 'processed', 'failed')),
+    error           TEXT,       -- Why the delivery was rejected or failed
+    attempts        INTEGER     NOT NULL
+                                DEFAULT 0
+);
+
+-- Only one delivery per event can be processing or processed, so events aren't handled twice
+CREATE UNIQUE INDEX inbound_webhooks_event_id_idx ON inbound_webhooks (source, event_id)
+WHERE status IN ('processing', 'processed');
+
+CREATE INDEX inbound_webhooks_status_idx ON inbound_webhooks (status, received_at);
+
+-- Keep replay protection for events handled before deliveries were logged
+INSERT INTO inbound_webhooks (id, received_at, updated_at, source, headers, body, event_id, event, status, attempts)
+SELECT gen_random_uuid(), received_at, received_at, 'polka', '{}', '', event_id, event, 'processed', 1
+FROM polka_webhook_events;
+
+DROP TABLE polka_webhook_events;
+
+-- +goose Down
+CREATE TABLE polka_webhook_events (
+    event_id        TEXT        PRIMARY KEY,
+    event           TEXT        NOT NULL,
+    received_at     timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO polka_webhook_events (event_id, event, received_at)
+SELECT event_id, event, received_at
+FROM inbound_webhooks
+WHERE source = 'polka' AND status = 'processed';
+
+DROP TABLE inbound_webhooks;
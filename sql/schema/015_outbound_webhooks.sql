@@ -0,0 +1,56 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Services notified when things happen in Chirpy
+CREATE TABLE webhook_endpoints (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    updated_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    url             TEXT        NOT NULL,
+    secret          TEXT        NOT NULL, -- Signs deliveries, so it's kept as-is rather than hashed
+    event_types     TEXT[]      NOT NULL -- ex: {"chirp.created", "user.created"}
+);
+
+-- Queue of events to send, one row per event and endpoint
+CREATE TABLE webhook_deliveries (
+    id              uuid        PRIMARY KEY,
+    created_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    updated_at      timestamp   NOT NULL
+                                DEFAULT CURRENT_TIMESTAMP,
+    endpoint_id     uuid        NOT NULL
+                                REFERENCES webhook_endpoints
+                                ON DELETE CASCADE,
+    event_id        uuid        NOT NULL, -- The same for every endpoint sent the event
+    event_type      TEXT        NOT NULL,
+    payload         bytea       NOT NULL, -- Exactly as sent and signed
+    status          TEXT        NOT NULL
+                                CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts        INTEGER     NOT NULL
+                                DEFAULT 0,
+    next_attempt_at timestamp   NOT NULL -- Also pushed back while an attempt is in progress
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at)
+WHERE status = 'pending';
+
+-- Every attempt to send a delivery
+CREATE TABLE webhook_delivery_attempts (
+    id              uuid        PRIMARY KEY,
+    attempted_at    timestamp   NOT NULL,
+    delivery_id     uuid        NOT NULL
+                                REFERENCES webhook_deliveries
+                                ON DELETE CASCADE,
+    status_code     INTEGER,    -- NULL if there was no response, ex: timeout
+    error           TEXT,       -- NULL if the endpoint responded with 2xx
+    duration_ms     INTEGER     NOT NULL
+);
+
+CREATE INDEX webhook_delivery_attempts_delivery_idx ON webhook_delivery_attempts (delivery_id, attempted_at);
+
+-- +goose Down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
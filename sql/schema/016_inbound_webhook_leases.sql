@@ -0,0 +1,15 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- A delivery is only processing until its lease expires, then it's failed so the event can be retried or replayed
+-- Otherwise a crash mid-processing would leave the event claimed forever
+ALTER TABLE inbound_webhooks
+ADD COLUMN processing_until timestamp; -- NULL unless processing
+
+UPDATE inbound_webhooks
+SET processing_until = updated_at
+WHERE status = 'processing';
+
+-- +goose Down
+ALTER TABLE inbound_webhooks
+DROP COLUMN processing_until;
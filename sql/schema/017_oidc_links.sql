@@ -0,0 +1,11 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Set when a logged-in user links a provider to their account, see POST /api/oidc/{provider}/link
+-- NULL for logins, which only link to an existing user without a password
+ALTER TABLE oidc_login_states
+ADD COLUMN user_id uuid REFERENCES users ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE oidc_login_states
+DROP COLUMN user_id;
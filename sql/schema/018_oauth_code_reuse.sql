@@ -0,0 +1,13 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- Codes are kept after they're exchanged, until they expire, so a reused code can be detected and its authorization revoked
+ALTER TABLE oauth_codes
+ADD COLUMN used_at timestamp; -- NULL until exchanged
+
+-- +goose Down
+DELETE FROM oauth_codes
+WHERE used_at IS NOT NULL;
+
+ALTER TABLE oauth_codes
+DROP COLUMN used_at;
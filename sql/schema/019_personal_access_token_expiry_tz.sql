@@ -0,0 +1,11 @@
+-- Goose for database migrations: https://github.com/pressly/goose
+
+-- +goose Up
+-- expires_at is chosen by the client, with any UTC offset, and `timestamp` dropped the offset
+-- Existing values were read back as UTC, so they're kept as UTC
+ALTER TABLE personal_access_tokens
+ALTER COLUMN expires_at TYPE timestamptz USING expires_at AT TIME ZONE 'UTC';
+
+-- +goose Down
+ALTER TABLE personal_access_tokens
+ALTER COLUMN expires_at TYPE timestamp USING expires_at AT TIME ZONE 'UTC';
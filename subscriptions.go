@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Subscription statuses
+// Chirpy Red lasts until the period ends for every status except SUBSCRIPTION_EXPIRED
+const (
+	SUBSCRIPTION_ACTIVE   = "active"
+	SUBSCRIPTION_PAST_DUE = "past_due" // Payment failed, Polka is retrying
+	SUBSCRIPTION_CANCELED = "canceled" // Won't renew
+	SUBSCRIPTION_EXPIRED  = "expired"
+)
+
+const PLAN_CHIRPY_RED = "chirpy_red"
+
+// Used when Polka doesn't send the period
+const DEFAULT_SUBSCRIPTION_PERIOD = 30 * 24 * time.Hour
+
+// How often lapsed subscriptions are expired
+const SUBSCRIPTION_EXPIRY_INTERVAL = time.Minute
+
+// Recorded by the expiry job, not sent by Polka
+const SUBSCRIPTION_EXPIRED_EVENT = "subscription.expired"
+
+var errSubscriptionNotFound = errors.New("subscription not found")
+
+type Subscription struct {
+	Plan               string              `json:"plan"`
+	Status             string              `json:"status"`
+	CurrentPeriodStart time.Time           `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time           `json:"current_period_end"`
+	History            []SubscriptionEvent `json:"history"`
+}
+
+type SubscriptionEvent struct {
+	Event            string    `json:"event"`
+	Status           string    `json:"status"`
+	CurrentPeriodEnd time.Time `json:"current_period_end"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Changes the user's subscription for a Polka event, returns the updated subscription
+// Runs in handlePolkaEvent's transaction, through q
+type polkaEventHandler func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error)
+
+var polkaEventHandlers = map[string]polkaEventHandler{
+	// Starts a new period, including for users who were downgraded or expired
+	"user.upgraded": func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error) {
+		_, err := q.GetUserByID(ctx, req.Data.UserID)
+		if err != nil {
+			return database.Subscription{}, err
+		}
+
+		plan := req.Data.Plan
+		if plan == "" {
+			plan = PLAN_CHIRPY_RED
+		}
+		periodStart, periodEnd := req.period(now)
+
+		return q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			ID:                 uuid.New(),
+			CreatedAt:          now,
+			UpdatedAt:          now,
+			UserID:             req.Data.UserID,
+			Plan:               plan,
+			Status:             SUBSCRIPTION_ACTIVE,
+			CurrentPeriodStart: periodStart,
+			CurrentPeriodEnd:   periodEnd,
+		})
+	},
+
+	// Paid for another period, which follows the current one (or starts now if it lapsed) unless Polka says otherwise
+	"subscription.renewed": func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error) {
+		return updateSubscription(ctx, q, req.Data.UserID, func(s database.Subscription) (string, time.Time, time.Time) {
+			periodStart, periodEnd := req.period(latest(s.CurrentPeriodEnd, now))
+			return SUBSCRIPTION_ACTIVE, periodStart, periodEnd
+		}, now)
+	},
+
+	// Keeps Chirpy Red until the period ends, a renewal makes it active again
+	"payment.failed": func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error) {
+		return updateSubscription(ctx, q, req.Data.UserID, func(s database.Subscription) (string, time.Time, time.Time) {
+			return SUBSCRIPTION_PAST_DUE, s.CurrentPeriodStart, s.CurrentPeriodEnd
+		}, now)
+	},
+
+	// Keeps Chirpy Red until the already paid period ends
+	"subscription.canceled": func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error) {
+		return updateSubscription(ctx, q, req.Data.UserID, func(s database.Subscription) (string, time.Time, time.Time) {
+			return SUBSCRIPTION_CANCELED, s.CurrentPeriodStart, s.CurrentPeriodEnd
+		}, now)
+	},
+
+	// Ends Chirpy Red immediately
+	"user.downgraded": func(ctx context.Context, q *database.Queries, req PolkaWebhookRequest, now time.Time) (database.Subscription, error) {
+		return updateSubscription(ctx, q, req.Data.UserID, func(s database.Subscription) (string, time.Time, time.Time) {
+			return SUBSCRIPTION_EXPIRED, s.CurrentPeriodStart, now
+		}, now)
+	},
+}
+
+// Applies the Polka event to the user's subscription, records it in the history, updates is_chirpy_red, and marks the delivery processed
+// All or nothing, so a failed event can be retried without applying it twice (ex: renewing for 2 periods)
+// Returns errWebhookLeaseExpired without applying the event if the delivery's lease expired, since the event may be claimed again
+func (cfg *apiConfig) handlePolkaEvent(ctx context.Context, deliveryID uuid.UUID, req PolkaWebhookRequest, now time.Time) (database.RefreshUserChirpyRedRow, error) {
+	handler := polkaEventHandlers[req.Event]
+
+	tx, err := cfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return database.RefreshUserChirpyRedRow{}, err
+	}
+	defer tx.Rollback()
+	q := cfg.db.WithTx(tx)
+
+	subscription, err := handler(ctx, q, req, now)
+	if err != nil {
+		return database.RefreshUserChirpyRedRow{}, err
+	}
+
+	err = q.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+		ID:               uuid.New(),
+		CreatedAt:        now,
+		SubscriptionID:   subscription.ID,
+		Event:            req.Event,
+		PolkaEventID:     sql.NullString{String: req.ID, Valid: true},
+		Status:           subscription.Status,
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+	})
+	if err != nil {
+		return database.RefreshUserChirpyRedRow{}, err
+	}
+
+	user, err := q.RefreshUserChirpyRed(ctx, database.RefreshUserChirpyRedParams{
+		Now:    now,
+		UserID: subscription.UserID,
+	})
+	if err != nil {
+		return database.RefreshUserChirpyRedRow{}, err
+	}
+
+	rowsUpdated, err := q.FinishInboundWebhookProcessing(ctx, database.FinishInboundWebhookProcessingParams{
+		ID:  deliveryID,
+		Now: time.Now(),
+	})
+	if err != nil {
+		return database.RefreshUserChirpyRedRow{}, err
+	}
+	if rowsUpdated == 0 {
+		return database.RefreshUserChirpyRedRow{}, errWebhookLeaseExpired
+	}
+
+	return user, tx.Commit()
+}
+
+// Sets the status and period of the user's existing subscription, returns errSubscriptionNotFound if they've never subscribed
+// The row is locked until q's transaction ends, so change() sees the latest period
+func updateSubscription(ctx context.Context, q *database.Queries, userID uuid.UUID, change func(database.Subscription) (status string, periodStart, periodEnd time.Time), now time.Time) (database.Subscription, error) {
+	subscription, err := q.GetSubscriptionByUserIDForUpdate(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Subscription{}, errSubscriptionNotFound
+	}
+	if err != nil {
+		return database.Subscription{}, err
+	}
+
+	status, periodStart, periodEnd := change(subscription)
+
+	return q.UpdateSubscription(ctx, database.UpdateSubscriptionParams{
+		Status:             status,
+		CurrentPeriodStart: periodStart,
+		CurrentPeriodEnd:   periodEnd,
+		UpdatedAt:          now,
+		UserID:             userID,
+	})
+}
+
+// Expires subscriptions whose period has ended, and turns off Chirpy Red for their users
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context, now time.Time) error {
+	expiredSubscriptions, err := cfg.db.ExpireLapsedSubscriptions(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range expiredSubscriptions {
+		err = cfg.db.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+			ID:               uuid.New(),
+			CreatedAt:        now,
+			SubscriptionID:   subscription.ID,
+			Event:            SUBSCRIPTION_EXPIRED_EVENT,
+			Status:           subscription.Status,
+			CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Also catches users missed by an earlier run that failed partway
+	downgradedUserIDs, err := cfg.db.RevokeLapsedChirpyRed(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(downgradedUserIDs) > 0 {
+		slog.Info("Chirpy Red expired", "users", len(downgradedUserIDs))
+	}
+
+	return nil
+}
+
+// Runs expireLapsedSubscriptions every interval until the context is canceled
+// A run in progress isn't canceled with it, it finishes first
+func (cfg *apiConfig) runSubscriptionExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.expireLapsedSubscriptions(context.WithoutCancel(ctx), time.Now())
+		if err != nil {
+			slog.Error("Error expiring subscriptions", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns the logged-in user's subscription and its history
+func (cfg *apiConfig) getSubscriptionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := cfg.authenticateBearer(r, "")
+		if err != nil {
+			sendBearerAuthErrorResponse(w, err)
+			return
+		}
+
+		savedSubscription, err := cfg.db.GetSubscriptionByUserID(r.Context(), userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			sendErrorJSONResponse(w, "No subscription", http.StatusNotFound, nil)
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		savedEvents, err := cfg.db.GetSubscriptionEvents(r.Context(), savedSubscription.ID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		subscription := Subscription{
+			Plan:               savedSubscription.Plan,
+			Status:             savedSubscription.Status,
+			CurrentPeriodStart: savedSubscription.CurrentPeriodStart,
+			CurrentPeriodEnd:   savedSubscription.CurrentPeriodEnd,
+			History:            []SubscriptionEvent{},
+		}
+		for _, savedEvent := range savedEvents {
+			subscription.History = append(subscription.History, SubscriptionEvent{
+				Event:            savedEvent.Event,
+				Status:           savedEvent.Status,
+				CurrentPeriodEnd: savedEvent.CurrentPeriodEnd,
+				CreatedAt:        savedEvent.CreatedAt,
+			})
+		}
+
+		SendJSONResponse(w, http.StatusOK, subscription)
+	}
+}
+
+// Returns the billing period sent by Polka, or DEFAULT_SUBSCRIPTION_PERIOD from `defaultStart`
+func (req PolkaWebhookRequest) period(defaultStart time.Time) (time.Time, time.Time) {
+	start := defaultStart
+	if req.Data.PeriodStart != nil {
+		start = *req.Data.PeriodStart
+	}
+
+	end := start.Add(DEFAULT_SUBSCRIPTION_PERIOD)
+	if req.Data.PeriodEnd != nil {
+		end = *req.Data.PeriodEnd
+	}
+
+	return start, end
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
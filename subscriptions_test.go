@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+func TestPolkaWebhookRequestPeriod(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	defaultStart := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name          string
+		periodStart   *time.Time
+		periodEnd     *time.Time
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{
+			name:          "Sent by Polka",
+			periodStart:   &start,
+			periodEnd:     &end,
+			expectedStart: start,
+			expectedEnd:   end,
+		},
+		{
+			name:          "Not sent",
+			expectedStart: defaultStart,
+			expectedEnd:   defaultStart.Add(DEFAULT_SUBSCRIPTION_PERIOD),
+		},
+		{
+			name:          "Only start sent",
+			periodStart:   &start,
+			expectedStart: start,
+			expectedEnd:   start.Add(DEFAULT_SUBSCRIPTION_PERIOD),
+		},
+	}
+
+	for _, c := range cases {
+		req := PolkaWebhookRequest{}
+		req.Data.PeriodStart = c.periodStart
+		req.Data.PeriodEnd = c.periodEnd
+
+		actualStart, actualEnd := req.period(defaultStart)
+		assertEquals(actualStart, c.expectedStart, c.name+" start", t)
+		assertEquals(actualEnd, c.expectedEnd, c.name+" end", t)
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+
+	users, passwords, err := createTestUsers(cfg, 2)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	userID := users[0].ID
+
+	// Events for users who never subscribed
+	for _, event := range []string{"subscription.renewed", "payment.failed", "subscription.canceled", "user.downgraded"} {
+		w := sendPolkaEvent(cfg, event, users[1].ID)
+		assertEquals(w.Result().StatusCode, http.StatusNotFound, event+" without subscription", t)
+	}
+
+	// Each event, and the status and Chirpy Red after it
+	cases := []struct {
+		event          string
+		expectedStatus string
+		expectedRed    bool
+	}{
+		{event: "user.upgraded", expectedStatus: SUBSCRIPTION_ACTIVE, expectedRed: true},
+		{event: "payment.failed", expectedStatus: SUBSCRIPTION_PAST_DUE, expectedRed: true},
+		{event: "subscription.renewed", expectedStatus: SUBSCRIPTION_ACTIVE, expectedRed: true},
+		{event: "subscription.canceled", expectedStatus: SUBSCRIPTION_CANCELED, expectedRed: true},
+		{event: "user.downgraded", expectedStatus: SUBSCRIPTION_EXPIRED, expectedRed: false},
+		{event: "user.upgraded", expectedStatus: SUBSCRIPTION_ACTIVE, expectedRed: true},
+	}
+
+	for _, c := range cases {
+		w := sendPolkaEvent(cfg, c.event, userID)
+		assertEquals(w.Result().StatusCode, http.StatusNoContent, c.event, t)
+
+		subscription, err := cfg.db.GetSubscriptionByUserID(context.Background(), userID)
+		if err != nil {
+			t.Error(formatTestError(c.event, err, nil))
+			continue
+		}
+		assertEquals(subscription.Status, c.expectedStatus, c.event+" status", t)
+		assertEquals(isChirpyRed(cfg, userID, t), c.expectedRed, c.event+" Chirpy Red", t)
+	}
+
+	// Nothing lapsed yet
+	err = cfg.expireLapsedSubscriptions(context.Background(), time.Now())
+	if err != nil {
+		t.Error(err)
+	}
+	assertEquals(isChirpyRed(cfg, userID, t), true, "before period ends", t)
+
+	// Canceled subscriptions expire once their period ends
+	sendPolkaEvent(cfg, "subscription.canceled", userID)
+	err = cfg.expireLapsedSubscriptions(context.Background(), time.Now().Add(DEFAULT_SUBSCRIPTION_PERIOD+time.Hour))
+	if err != nil {
+		t.Error(err)
+	}
+	assertEquals(isChirpyRed(cfg, userID, t), false, "after period ends", t)
+
+	// History, oldest first
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	request := httptest.NewRequest("GET", "/api/subscription", nil)
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w := httptest.NewRecorder()
+	cfg.getSubscriptionHandler()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "get subscription", t)
+
+	subscription := Subscription{}
+	json.NewDecoder(w.Result().Body).Decode(&subscription)
+	assertEquals(subscription.Status, SUBSCRIPTION_EXPIRED, "get subscription status", t)
+
+	expectedHistory := []string{}
+	for _, c := range cases {
+		expectedHistory = append(expectedHistory, c.event)
+	}
+	expectedHistory = append(expectedHistory, "subscription.canceled", SUBSCRIPTION_EXPIRED_EVENT)
+
+	actualHistory := []string{}
+	for _, event := range subscription.History {
+		actualHistory = append(actualHistory, event.Event)
+	}
+	assertEquals(fmt.Sprint(actualHistory), fmt.Sprint(expectedHistory), "subscription history", t)
+}
+
+// Sends a signed Polka event with a new event ID
+// Renewals for the same user at once each add a period, none are lost
+func TestConcurrentSubscriptionRenewals(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"secret"}
+	user, _ := newTestUser(t, cfg)
+
+	w := sendPolkaEvent(cfg, "user.upgraded", user.ID)
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "user.upgraded", t)
+	subscription, err := cfg.db.GetSubscriptionByUserID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewals := 5
+	statusCodes := make(chan int, renewals)
+	for range renewals {
+		go func() {
+			statusCodes <- sendPolkaEvent(cfg, "subscription.renewed", user.ID).Result().StatusCode
+		}()
+	}
+	for range renewals {
+		assertEquals(<-statusCodes, http.StatusNoContent, "subscription.renewed", t)
+	}
+
+	renewed, err := cfg.db.GetSubscriptionByUserID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEnd := subscription.CurrentPeriodEnd.Add(time.Duration(renewals) * DEFAULT_SUBSCRIPTION_PERIOD)
+	assertEquals(renewed.CurrentPeriodEnd.Equal(expectedEnd), true, fmt.Sprintf("period end %v after %v renewals", renewed.CurrentPeriodEnd, renewals), t)
+}
+
+func sendPolkaEvent(cfg *apiConfig, event string, userID uuid.UUID) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"id": %q, "event": %q, "data": {"user_id": %q}}`, uuid.NewString(), event, userID)
+	return sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), cfg.polkaWebhookSecrets...))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/LamontBanks/Chirpy/internal/config"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Postgres config for tests that need the real database, ex: webhooks, passkeys, OAuth
+// Each test gets its own freshly migrated schema, dropped when the test ends, so tests can run with t.Parallel()
+// and never touch the data in DB_URL's default schema
+// Skipped when DB_URL isn't set or Postgres can't be reached
+func newTestApiConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	ctx := context.Background()
+
+	conf, _, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DBURL == "" {
+		t.Skip("DB_URL not set, skipping Postgres test")
+	}
+
+	admin, err := sql.Open("postgres", conf.DBURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.PingContext(ctx); err != nil {
+		admin.Close()
+		t.Skipf("Postgres unavailable, skipping: %v", err)
+	}
+
+	schema := "chirpy_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+pq.QuoteIdentifier(schema)); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	// Registered before the config's own cleanup, so it runs after the pool is closed
+	t.Cleanup(func() {
+		defer admin.Close()
+		if _, err := admin.ExecContext(context.Background(), "DROP SCHEMA "+pq.QuoteIdentifier(schema)+" CASCADE"); err != nil {
+			t.Errorf("dropping test schema %v: %v", schema, err)
+		}
+	})
+
+	conf.DBURL, err = withSearchPath(conf.DBURL, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Platform = "dev"
+	if conf.TOTPEncryptionKey == "" {
+		conf.TOTPEncryptionKey = base64.StdEncoding.EncodeToString([]byte("test-totp-encryption-key-32bytes"))
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := newApiConfig(conf)
+	t.Cleanup(func() { cfg.sqlDB.Close() })
+
+	provider, err := cfg.migrationProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+// Every connection in the pool uses the schema, including goose's version table
+// Works with both URL (postgres://...) and key=value connection strings
+func withSearchPath(dbURL, schema string) (string, error) {
+	if !strings.HasPrefix(dbURL, "postgres://") && !strings.HasPrefix(dbURL, "postgresql://") {
+		return dbURL + " search_path=" + schema, nil
+	}
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Factories, for Postgres or in-memory configs
+// Each fails the test on error, and uses unique emails so they can be called any number of times per config
+
+// New user with the "user" role, also returns their password
+func newTestUser(t *testing.T, cfg *apiConfig) (User, string) {
+	t.Helper()
+
+	email := fmt.Sprintf("user_%v@example.com", uuid.NewString())
+	password := "abc123-" + uuid.NewString()
+	user, _, err := createTestUser(cfg, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user, password
+}
+
+// Logs the user in, the response has their JWT and refresh token
+func newTestTokens(t *testing.T, cfg *apiConfig, email, password string) LoginResponse {
+	t.Helper()
+
+	loggedInUser, err := loginUser(cfg, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedInUser.Token == "" || loggedInUser.RefreshToken == "" {
+		t.Fatalf("login for %v didn't return tokens", email)
+	}
+	return loggedInUser
+}
+
+// Chirp posted by the user, through POST /api/chirps
+func newTestChirp(t *testing.T, cfg *apiConfig, userToken, body string) Chirp {
+	t.Helper()
+
+	chirp, err := postChirp(cfg, userToken, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
+
+func TestWithSearchPath(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "postgres://chirpy:pw@localhost:5432/chirpy?sslmode=disable",
+			expected: "postgres://chirpy:pw@localhost:5432/chirpy?search_path=chirpy_test_1&sslmode=disable",
+		},
+		{
+			input:    "host=localhost dbname=chirpy sslmode=disable",
+			expected: "host=localhost dbname=chirpy sslmode=disable search_path=chirpy_test_1",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := withSearchPath(c.input, "chirpy_test_1")
+		if err != nil {
+			t.Error(err)
+		}
+		assertEquals(actual, c.expected, c.input, t)
+	}
+}
+
+func TestTestSchemasAreIsolated(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	otherCfg := newTestApiConfig(t)
+
+	user, password := newTestUser(t, cfg)
+	tokens := newTestTokens(t, cfg, user.Email, password)
+	chirp := newTestChirp(t, cfg, tokens.Token, "Only in one schema")
+
+	// Migrated, and the other schema doesn't see the new rows
+	_, err := cfg.chirps.GetChirpByID(context.Background(), chirp.ID)
+	assertEquals(err, nil, "Chirp in its own schema", t)
+
+	_, err = otherCfg.users.GetUserByEmail(context.Background(), user.Email)
+	assertEquals(err, sql.ErrNoRows, "User in another schema", t)
+}
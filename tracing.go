@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry tracing: a span per request, with a child span per database query
+// OTEL_TRACES_EXPORTER picks where spans are sent:
+//   - "otlp": an OTLP/HTTP collector, configured with the standard OTEL_EXPORTER_OTLP_* variables (default http://localhost:4318)
+//   - "stdout": printed, for development
+//   - "none" or unset: not recorded, but incoming trace context is still passed along
+const (
+	TRACES_EXPORTER_OTLP   = "otlp"
+	TRACES_EXPORTER_STDOUT = "stdout"
+	TRACES_EXPORTER_NONE   = "none"
+
+	TRACING_SERVICE_NAME = "chirpy"
+)
+
+var tracer = otel.Tracer("github.com/LamontBanks/Chirpy")
+
+// Sets the global tracer provider and W3C trace context propagation
+// Returns a function that flushes remaining spans, to call before exiting
+func initTracing(ctx context.Context, exporterName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case TRACES_EXPORTER_OTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case TRACES_EXPORTER_STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case TRACES_EXPORTER_NONE, "":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, expected %v, %v, or %v", exporterName, TRACES_EXPORTER_OTLP, TRACES_EXPORTER_STDOUT, TRACES_EXPORTER_NONE)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(TRACING_SERVICE_NAME))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Starts a span for every request, continuing the caller's trace from the `traceparent` header
+// The span is named after the mux pattern, ex: "GET /api/chirps/{chirpID}", once the request is routed
+func middlewareTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		// The mux sets the pattern on this request, not the original
+		r = r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if r.Pattern != "" {
+			span.SetName(spanName(r.Method, r.Pattern))
+			span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// Patterns without a method, ex: "/app/", are prefixed with the request's
+func spanName(method, pattern string) string {
+	if strings.Contains(pattern, " ") {
+		return pattern
+	}
+	return method + " " + pattern
+}
+
+// Starts a child span for the sqlc query, returns a function that ends it with the query's error
+func startQuerySpan(ctx context.Context, query string) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, queryName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			attribute.String("db.query.text", query),
+		),
+	)
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Accepts every statement without a database
+type fakeDB struct{}
+
+func (fakeDB) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func (fakeDB) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, nil
+}
+
+func (fakeDB) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func (fakeDB) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return &sql.Row{}
+}
+
+func TestTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	db := instrumentedDB{db: fakeDB{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		db.ExecContext(r.Context(), "-- name: DeleteThing :exec\nDELETE FROM things WHERE id = $1", r.PathValue("thingID"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := middlewareTracing(middlewareMetrics(mux))
+
+	// Continues the caller's trace
+	callerTraceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodDelete, "/api/things/123", nil)
+	request.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatal(formatTestError("Spans ended", len(ended), 2))
+	}
+
+	// The query ends first
+	querySpan, requestSpan := ended[0], ended[1]
+
+	cases := []struct {
+		name     string
+		actual   any
+		expected any
+	}{
+		{name: "Request span name", actual: requestSpan.Name(), expected: "DELETE /api/things/{thingID}"},
+		{name: "Request span kind", actual: requestSpan.SpanKind(), expected: trace.SpanKindServer},
+		{name: "Request span trace ID", actual: requestSpan.SpanContext().TraceID().String(), expected: callerTraceID},
+		{name: "Request span parent", actual: requestSpan.Parent().SpanID().String(), expected: "00f067aa0ba902b7"},
+		{name: "Query span name", actual: querySpan.Name(), expected: "DeleteThing"},
+		{name: "Query span kind", actual: querySpan.SpanKind(), expected: trace.SpanKindClient},
+		{name: "Query span parent", actual: querySpan.Parent().SpanID(), expected: requestSpan.SpanContext().SpanID()},
+	}
+
+	for _, c := range cases {
+		if c.actual != c.expected {
+			t.Error(formatTestError(c.name, c.actual, c.expected))
+		}
+	}
+}
+
+func TestSpanName(t *testing.T) {
+	cases := []struct {
+		method   string
+		pattern  string
+		expected string
+	}{
+		{method: "GET", pattern: "GET /api/chirps/{chirpID}", expected: "GET /api/chirps/{chirpID}"},
+		{method: "GET", pattern: "/app/", expected: "GET /app/"},
+	}
+
+	for _, c := range cases {
+		actual := spanName(c.method, c.pattern)
+		if actual != c.expected {
+			t.Error(formatTestError(c.method+" "+c.pattern, actual, c.expected))
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPConfirmationResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Starts two-factor enrollment: generates a new TOTP secret for the user in the auth token
+// 2FA isn't enabled until the user proves their authenticator app works at POST /api/2fa/confirm
+func (cfg *apiConfig) enrollTOTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Read userID from the auth token
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+
+		userID, err := auth.ValidateToken(token, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+		setRequestUserID(r.Context(), userID)
+
+		user, err := cfg.users.GetUserByID(r.Context(), userID)
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("invalid user %v", userID))
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		if user.TotpEnabled {
+			sendErrorJSONResponse(w, "Two-factor authentication already enabled", http.StatusConflict, nil)
+			return
+		}
+
+		// Save pending secret, encrypted
+		secret, err := auth.MakeTOTPSecret()
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		encryptedSecret, err := auth.EncryptTOTPSecret(secret, user.ID, cfg.totpEncryptionKey)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		err = cfg.db.SetUserTOTPSecret(r.Context(), database.SetUserTOTPSecretParams{
+			ID:         user.ID,
+			TotpSecret: sql.NullString{String: encryptedSecret, Valid: true},
+			UpdatedAt:  time.Now(),
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusOK, TOTPEnrollmentResponse{
+			Secret:     secret,
+			OTPAuthURI: auth.TOTPAuthURI(secret, user.Email),
+		})
+	}
+}
+
+// Finishes two-factor enrollment once the user submits a valid code from their authenticator app
+// Returns one-time recovery codes - these are only shown once, only their hashes are saved
+func (cfg *apiConfig) confirmTOTPHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Code string `json:"code"`
+		}{}
+
+		// Read userID from the auth token
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+
+		userID, err := auth.ValidateToken(token, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
+			return
+		}
+		setRequestUserID(r.Context(), userID)
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		if req.Code == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "code", Code: FIELD_REQUIRED, Message: "Code required"})
+			return
+		}
+
+		user, err := cfg.users.GetUserByID(r.Context(), userID)
+		if err == sql.ErrNoRows {
+			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, fmt.Errorf("invalid user %v", userID))
+			return
+		}
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		if user.TotpEnabled {
+			sendErrorJSONResponse(w, "Two-factor authentication already enabled", http.StatusConflict, nil)
+			return
+		}
+		if !user.TotpSecret.Valid {
+			sendErrorJSONResponse(w, "Two-factor enrollment not started, see POST /api/2fa/enroll", http.StatusConflict, nil)
+			return
+		}
+
+		// Check the code against the pending secret
+		secret, err := cfg.userTOTPSecret(r.Context(), user)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		step, err := auth.ValidateTOTP(secret, req.Code, time.Now())
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid code", http.StatusUnauthorized, err)
+			return
+		}
+
+		// Replace any old recovery codes
+		recoveryCodes, err := auth.MakeRecoveryCodes()
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		err = cfg.db.DeleteRecoveryCodes(r.Context(), user.ID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, code := range recoveryCodes {
+			err = cfg.db.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+				ID:        uuid.New(),
+				CreatedAt: time.Now(),
+				UserID:    user.ID,
+				CodeHash:  auth.HashToken(code),
+			})
+			if err != nil {
+				sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		// Enable 2FA
+		err = cfg.db.EnableUserTOTP(r.Context(), database.EnableUserTOTPParams{
+			ID:           user.ID,
+			TotpLastStep: step,
+			UpdatedAt:    time.Now(),
+		})
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Response
+		SendJSONResponse(w, http.StatusOK, TOTPConfirmationResponse{
+			RecoveryCodes: recoveryCodes,
+		})
+	}
+}
+
+// Decrypts the user's saved TOTP secret
+// Secrets saved before encryption are encrypted now, so they stop being stored in plaintext
+func (cfg *apiConfig) userTOTPSecret(ctx context.Context, user database.User) (string, error) {
+	secret, legacy, err := auth.DecryptTOTPSecret(user.TotpSecret.String, user.ID, cfg.totpEncryptionKey)
+	if err != nil || !legacy {
+		return secret, err
+	}
+
+	encryptedSecret, err := auth.EncryptTOTPSecret(secret, user.ID, cfg.totpEncryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = cfg.db.ReplaceUserTOTPSecret(ctx, database.ReplaceUserTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: encryptedSecret, Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// Responds with a challenge token for POST /api/login/2fa, instead of logging the user in
+func (cfg *apiConfig) sendMFAChallengeResponse(w http.ResponseWriter, userID uuid.UUID) {
+	challengeDuration, err := time.ParseDuration(auth.MFA_CHALLENGE_TOKEN_DURATION)
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	challengeToken, err := auth.MakeMFAChallengeJWT(userID, cfg.jwtSecret, challengeDuration)
+	if err != nil {
+		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		return
+	}
+
+	SendJSONResponse(w, http.StatusAccepted, MFAChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: challengeToken,
+	})
+}
+
+// Second step of a two-factor login
+// Exchanges the challenge token from POST /api/login, plus a TOTP or recovery code, for the JWT/refresh token
+func (cfg *apiConfig) handlerLoginTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+			RecoveryCode   string `json:"recovery_code"`
+		}{}
+
+		if !decodeJSONBody(w, r, &req) {
+			return
+		}
+
+		// Validate required fields
+		if req.ChallengeToken == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "challenge_token", Code: FIELD_REQUIRED, Message: "Challenge token required"})
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			sendValidationErrorResponse(w, FieldError{Field: "code", Code: FIELD_REQUIRED, Message: "Code or recovery code required"})
+			return
+		}
+
+		userID, err := auth.ValidateMFAChallengeToken(req.ChallengeToken, cfg.jwtSecret)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid challenge token", http.StatusUnauthorized, err)
+			return
+		}
+
+		user, err := cfg.users.GetUserByID(r.Context(), userID)
+		if err != nil {
+			sendErrorJSONResponse(w, "Invalid challenge token", http.StatusUnauthorized, err)
+			return
+		}
+		if !user.TotpEnabled || !user.TotpSecret.Valid {
+			sendErrorJSONResponse(w, "Invalid challenge token", http.StatusUnauthorized, fmt.Errorf("user %v does not have 2FA enabled", user.ID))
+			return
+		}
+
+		// Only 10^6 possible codes, so guesses are throttled like passwords
+		accountKey := accountThrottleKey(user.Email)
+		retryAfter, releaseAttempt := cfg.reserveLoginAttempt(r, accountKey)
+		if retryAfter > 0 {
+			recordLoginMetric(LOGIN_METHOD_TOTP, LOGIN_THROTTLED)
+			sendTooManyRequestsResponse(w, "Too many failed login attempts, try again later", retryAfter, fmt.Errorf("2FA login throttled for %v from %v", accountKey, clientIP(r)))
+			return
+		}
+
+		if req.Code != "" {
+			// TOTP code, each time step can only be used once
+			secret, err := cfg.userTOTPSecret(r.Context(), user)
+			if err != nil {
+				sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+				return
+			}
+
+			step, err := auth.ValidateTOTP(secret, req.Code, time.Now())
+			if err != nil {
+				recordLoginMetric(LOGIN_METHOD_TOTP, LOGIN_FAILURE)
+				sendErrorJSONResponse(w, "Invalid code", http.StatusUnauthorized, err)
+				return
+			}
+
+			rowsUpdated, err := cfg.db.SetUserTOTPLastStep(r.Context(), database.SetUserTOTPLastStepParams{
+				ID:           user.ID,
+				TotpLastStep: step,
+			})
+			if err != nil {
+				sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+				return
+			}
+			if rowsUpdated == 0 {
+				sendErrorJSONResponse(w, "Invalid code", http.StatusUnauthorized, fmt.Errorf("user %v reused TOTP step %v", user.ID, step))
+				return
+			}
+		} else {
+			// Recovery code, marked as used
+			rowsUpdated, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+				UserID:   user.ID,
+				CodeHash: auth.HashToken(strings.ToLower(strings.TrimSpace(req.RecoveryCode))),
+				UsedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+			})
+			if err != nil {
+				sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+				return
+			}
+			if rowsUpdated == 0 {
+				recordLoginMetric(LOGIN_METHOD_TOTP, LOGIN_FAILURE)
+				sendErrorJSONResponse(w, "Invalid recovery code", http.StatusUnauthorized, fmt.Errorf("user %v submitted invalid or used recovery code", user.ID))
+				return
+			}
+		}
+
+		releaseAttempt(true)
+		cfg.recordLoginSuccess(accountKey)
+		recordLoginMetric(LOGIN_METHOD_TOTP, LOGIN_SUCCESS)
+
+		// Response
+		loginResponse, err := cfg.createLoginResponse(r.Context(), user)
+		if err != nil {
+			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+			return
+		}
+
+		SendJSONResponse(w, http.StatusOK, loginResponse)
+	}
+}
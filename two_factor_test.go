@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+
+	users, passwords, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	loggedInUser, err := loginUser(cfg, users[0].Email, passwords[0])
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// Enroll
+	request := httptest.NewRequest("POST", "/api/2fa/enroll", nil)
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w := httptest.NewRecorder()
+	cfg.enrollTOTPHandler()(w, request)
+
+	assertEquals(w.Result().StatusCode, http.StatusOK, "enroll", t)
+
+	enrollment := TOTPEnrollmentResponse{}
+	err = json.NewDecoder(w.Result().Body).Decode(&enrollment)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") {
+		t.Error(formatTestError("otpauth URI", enrollment.OTPAuthURI, "otpauth://totp/..."))
+	}
+
+	// Saved encrypted
+	savedUser, err := cfg.users.GetUserByID(context.Background(), users[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(savedUser.TotpSecret.String, auth.TOTP_ENCRYPTED_PREFIX) || strings.Contains(savedUser.TotpSecret.String, enrollment.Secret) {
+		t.Error(formatTestError("saved TOTP secret", savedUser.TotpSecret.String, "encrypted secret"))
+	}
+
+	// Confirm with the previous step's code, leaving the current step for login
+	confirmCode, err := auth.TOTPCode(enrollment.Secret, auth.TOTPStep(time.Now())-1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	request = httptest.NewRequest("POST", "/api/2fa/confirm", strings.NewReader(fmt.Sprintf(`{"code": "%v"}`, confirmCode)))
+	request.Header.Add("Authorization", "Bearer "+loggedInUser.Token)
+	w = httptest.NewRecorder()
+	cfg.confirmTOTPHandler()(w, request)
+
+	assertEquals(w.Result().StatusCode, http.StatusOK, "confirm", t)
+
+	confirmation := TOTPConfirmationResponse{}
+	err = json.NewDecoder(w.Result().Body).Decode(&confirmation)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	assertEquals(len(confirmation.RecoveryCodes), auth.RECOVERY_CODE_COUNT, confirmation, t)
+
+	// Password login now returns a challenge instead of tokens
+	challenge := loginWithPasswordForChallenge(cfg, users[0].Email, passwords[0], t)
+
+	currentCode, err := auth.TOTPCode(enrollment.Secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Error(err)
+	}
+
+	cases := []struct {
+		name             string
+		body             string
+		expectedRespCode int
+	}{
+		{
+			name:             "Wrong code",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "code": "000000"}`, challenge.ChallengeToken),
+			expectedRespCode: http.StatusUnauthorized,
+		},
+		{
+			name:             "Access token instead of challenge token",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "code": "%v"}`, loggedInUser.Token, currentCode),
+			expectedRespCode: http.StatusUnauthorized,
+		},
+		{
+			name:             "Valid code",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "code": "%v"}`, challenge.ChallengeToken, currentCode),
+			expectedRespCode: http.StatusOK,
+		},
+		{
+			name:             "Reused code",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "code": "%v"}`, challenge.ChallengeToken, currentCode),
+			expectedRespCode: http.StatusUnauthorized,
+		},
+		{
+			name:             "Recovery code",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "recovery_code": "%v"}`, challenge.ChallengeToken, confirmation.RecoveryCodes[0]),
+			expectedRespCode: http.StatusOK,
+		},
+		{
+			name:             "Reused recovery code",
+			body:             fmt.Sprintf(`{"challenge_token": "%v", "recovery_code": "%v"}`, challenge.ChallengeToken, confirmation.RecoveryCodes[0]),
+			expectedRespCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest("POST", "/api/login/2fa", strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		cfg.handlerLoginTOTP()(w, request)
+
+		assertEquals(w.Result().StatusCode, c.expectedRespCode, c.name, t)
+
+		if w.Result().StatusCode == http.StatusOK {
+			loginResp := LoginResponse{}
+			err = json.NewDecoder(w.Result().Body).Decode(&loginResp)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if loginResp.Token == "" || loginResp.RefreshToken == "" {
+				t.Error(formatTestError(c.name, loginResp, "JWT and refresh token"))
+			}
+		}
+	}
+}
+
+// Secrets saved before they were encrypted still work, and are encrypted when used
+func TestTwoFactorLoginWithPlaintextSecret(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	ctx := context.Background()
+	user, password := newTestUser(t, cfg)
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetUserTOTPSecret(ctx, database.SetUserTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		UpdatedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.EnableUserTOTP(ctx, database.EnableUserTOTPParams{ID: user.ID, UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := loginWithPasswordForChallenge(cfg, user.Email, password, t)
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("POST", "/api/login/2fa", strings.NewReader(fmt.Sprintf(`{"challenge_token": "%v", "code": "%v"}`, challenge.ChallengeToken, code)))
+	w := httptest.NewRecorder()
+	cfg.handlerLoginTOTP()(w, request)
+	assertEquals(w.Result().StatusCode, http.StatusOK, "login with plaintext secret", t)
+
+	savedUser, err := cfg.users.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEquals(strings.HasPrefix(savedUser.TotpSecret.String, auth.TOTP_ENCRYPTED_PREFIX), true, "secret encrypted after login", t)
+}
+
+// Logs in a user with 2FA enabled, returns the challenge to complete at POST /api/login/2fa
+func loginWithPasswordForChallenge(cfg *apiConfig, email, password string, t *testing.T) MFAChallengeResponse {
+	loginUserBody := fmt.Sprintf(`{"email": "%v","password": "%v"}`, email, password)
+	request := httptest.NewRequest("POST", "/api/login", strings.NewReader(loginUserBody))
+	w := httptest.NewRecorder()
+	cfg.handlerLogin()(w, request)
+
+	assertEquals(w.Result().StatusCode, http.StatusAccepted, "login with 2FA enabled", t)
+
+	challenge := MFAChallengeResponse{}
+	err := json.NewDecoder(w.Result().Body).Decode(&challenge)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !challenge.MFARequired || challenge.ChallengeToken == "" {
+		t.Error(formatTestError("login with 2FA enabled", challenge, "challenge token"))
+	}
+
+	return challenge
+}
@@ -1,22 +1,29 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/LamontBanks/Chirpy/internal/auth"
 	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/LamontBanks/Chirpy/internal/repository"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// Postgres error code, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const PG_UNIQUE_VIOLATION = "23505"
+
 type User struct {
 	ID          uuid.UUID `json:"id"`
 	Email       string    `json:"email"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Role        string    `json:"role"`
 }
 
 // Wrap functions in a closure to get access to the database
@@ -27,61 +34,89 @@ func (cfg *apiConfig) createUserHandler() http.HandlerFunc {
 			Email    string `json:"email"`
 		}{}
 
-		// Decode request
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&req)
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
 
 		// Check for required elements
 		if req.Email == "" {
-			sendErrorJSONResponse(w, "Email required", http.StatusBadRequest, nil)
+			sendValidationErrorResponse(w, FieldError{Field: "email", Code: FIELD_REQUIRED, Message: "Email required"})
 			return
 		}
 
 		if req.Password == "" {
-			sendErrorJSONResponse(w, "Password required", http.StatusBadRequest, nil)
+			sendValidationErrorResponse(w, FieldError{Field: "password", Code: FIELD_REQUIRED, Message: "Password required"})
 			return
 		}
 
-		// Save password
-		hashedPassword, err := auth.HashPassword(req.Password)
-		if err != nil {
-			sendErrorJSONResponse(w, "Invalid Password", http.StatusBadRequest, err)
+		user, err := cfg.createUser(r.Context(), req.Email, req.Password)
+		if errors.As(err, new(*auth.PasswordPolicyError)) {
+			sendPasswordPolicyErrorResponse(w, err)
+			return
+		}
+		if isEmailTaken(err) {
+			sendErrorJSONResponse(w, "An account with this email already exists", http.StatusConflict, err)
 			return
 		}
-
-		// Create user
-		dbUser, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
-			ID:             uuid.New(),
-			Email:          req.Email,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
-			HashedPassword: hashedPassword,
-		})
-
 		if err != nil {
 			msg := fmt.Sprintf("Unable to create user with email %v", req.Email)
-			sendErrorJSONResponse(w, msg, 500, err)
+			sendErrorJSONResponse(w, msg, http.StatusInternalServerError, err)
 			return
 		}
 
-		// Map from database.User to custom User type
-		user := User{
-			ID:          dbUser.ID,
-			Email:       dbUser.Email,
-			CreatedAt:   dbUser.CreatedAt,
-			UpdatedAt:   dbUser.UpdatedAt,
-			IsChirpyRed: dbUser.IsChirpyRed,
-		}
-
 		// Success Response
 		SendJSONResponse(w, http.StatusCreated, user)
 	}
 }
 
+// Checks the password against the policy, then saves the new user
+// Shared by POST /api/users and `chirpy user create`
+func (cfg *apiConfig) createUser(ctx context.Context, email, password string) (User, error) {
+	err := cfg.passwordPolicy.Validate(password, email)
+	if err != nil {
+		return User{}, err
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	dbUser, err := cfg.users.CreateUser(ctx, database.CreateUserParams{
+		ID:             uuid.New(),
+		Email:          email,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	// Map from database.User to custom User type
+	user := User{
+		ID:          dbUser.ID,
+		Email:       dbUser.Email,
+		CreatedAt:   dbUser.CreatedAt,
+		UpdatedAt:   dbUser.UpdatedAt,
+		IsChirpyRed: dbUser.IsChirpyRed,
+		Role:        dbUser.Role,
+	}
+	cfg.publishWebhookEvent(ctx, WEBHOOK_EVENT_USER_CREATED, user)
+
+	return user, nil
+}
+
+// Another user already has the email
+// repository.ErrEmailTaken in memory, a unique violation on users.email in Postgres
+func isEmailTaken(err error) bool {
+	pqErr := &pq.Error{}
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == PG_UNIQUE_VIOLATION && pqErr.Constraint == "users_email_key"
+	}
+	return errors.Is(err, repository.ErrEmailTaken)
+}
+
 // Updates the user's email and password, based on the provided authentication token
 func (cfg *apiConfig) updateUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -102,37 +137,45 @@ func (cfg *apiConfig) updateUserHandler() http.HandlerFunc {
 			sendErrorJSONResponse(w, "Invalid User", http.StatusUnauthorized, err)
 			return
 		}
+		setRequestUserID(r.Context(), userID)
 
 		// Decode request, check new email and password values
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&req)
-		if err != nil {
-			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+		if !decodeJSONBody(w, r, &req) {
 			return
 		}
 
 		if req.Email == "" {
-			sendErrorJSONResponse(w, "Email required", http.StatusBadRequest, fmt.Errorf("empty email: %v", req.Email))
+			sendValidationErrorResponse(w, FieldError{Field: "email", Code: FIELD_REQUIRED, Message: "Email required"})
 			return
 		}
 		if req.Password == "" {
-			sendErrorJSONResponse(w, "Password required", http.StatusBadRequest, fmt.Errorf("empty password: %v", req.Password))
+			sendValidationErrorResponse(w, FieldError{Field: "password", Code: FIELD_REQUIRED, Message: "Password required"})
+			return
+		}
+
+		err = cfg.passwordPolicy.Validate(req.Password, req.Email)
+		if err != nil {
+			sendPasswordPolicyErrorResponse(w, err)
 			return
 		}
 
-		new_hashed_password, err := auth.HashPassword(req.Password)
+		new_hashed_password, err := cfg.passwordHasher.Hash(req.Password)
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
 		}
 
 		// Update user info
-		updatedUser, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
+		updatedUser, err := cfg.users.UpdateUser(r.Context(), database.UpdateUserParams{
 			ID:             userID,
 			Email:          req.Email,
 			HashedPassword: new_hashed_password,
 			UpdatedAt:      time.Now(),
 		})
+		if isEmailTaken(err) {
+			sendErrorJSONResponse(w, "An account with this email already exists", http.StatusConflict, err)
+			return
+		}
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
 			return
@@ -145,6 +188,7 @@ func (cfg *apiConfig) updateUserHandler() http.HandlerFunc {
 			CreatedAt:   updatedUser.CreatedAt,
 			UpdatedAt:   updatedUser.UpdatedAt,
 			IsChirpyRed: updatedUser.IsChirpyRed,
+			Role:        updatedUser.Role,
 		})
 
 	}
@@ -155,7 +199,7 @@ func (cfg *apiConfig) getUsersHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		users := []User{}
 
-		usersFromDB, err := cfg.db.GetUsers(r.Context())
+		usersFromDB, err := cfg.users.GetUsers(r.Context())
 		if err != nil {
 			sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, fmt.Errorf("error getting all users: %v", err))
 			return
@@ -168,6 +212,7 @@ func (cfg *apiConfig) getUsersHandler() http.HandlerFunc {
 				CreatedAt:   user.CreatedAt,
 				UpdatedAt:   user.UpdatedAt,
 				IsChirpyRed: user.IsChirpyRed,
+				Role:        user.Role,
 			})
 		}
 
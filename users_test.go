@@ -9,12 +9,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/LamontBanks/Chirpy/internal/auth"
 	"github.com/google/uuid"
 )
 
 func TestUserCreation(t *testing.T) {
-	setup()
-	defer tearDown()
+	t.Parallel()
 
 	cases := []struct {
 		name     string
@@ -29,11 +29,11 @@ func TestUserCreation(t *testing.T) {
 		{
 			name:     "New User 2",
 			email:    "fakeuser2@email.com",
-			password: "abc123",
+			password: "abc123xyz789",
 		},
 	}
 
-	cfg := initApiConfig()
+	cfg := newMemoryApiConfig()
 	for _, c := range cases {
 		input := fmt.Sprintf(`{"email": "%v", "password": "%v"}`, c.email, c.password)
 
@@ -53,6 +53,122 @@ func TestUserCreation(t *testing.T) {
 	}
 }
 
+func TestUserCreationPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name              string
+		email             string
+		password          string
+		expectedViolation string
+	}{
+		{
+			name:              "Too short",
+			email:             "fakeuser@email.com",
+			password:          "abc123",
+			expectedViolation: auth.PASSWORD_TOO_SHORT,
+		},
+		{
+			name:              "Over the bcrypt limit",
+			email:             "fakeuser@email.com",
+			password:          strings.Repeat("abc123", 13),
+			expectedViolation: auth.PASSWORD_TOO_LONG,
+		},
+		{
+			name:              "Common password",
+			email:             "fakeuser@email.com",
+			password:          "password123",
+			expectedViolation: auth.PASSWORD_TOO_COMMON,
+		},
+		{
+			name:              "Contains email",
+			email:             "fakeuser@email.com",
+			password:          "fakeuser-password",
+			expectedViolation: auth.PASSWORD_SIMILAR_TO_EMAIL,
+		},
+	}
+
+	cfg := newMemoryApiConfig()
+	for _, c := range cases {
+		request := httptest.NewRequest("POST", "/api/users", strings.NewReader(fmt.Sprintf(`{"email": "%v", "password": "%v"}`, c.email, c.password)))
+		w := httptest.NewRecorder()
+		cfg.createUserHandler()(w, request)
+
+		assertEquals(w.Result().StatusCode, http.StatusUnprocessableEntity, c.name, t)
+
+		problem := Problem{}
+		err := json.NewDecoder(w.Result().Body).Decode(&problem)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assertEquals(problem.Type, PROBLEM_VALIDATION_FAILED, c.name, t)
+		if len(problem.Errors) == 0 || problem.Errors[0].Field != "password" || problem.Errors[0].Code != c.expectedViolation {
+			t.Error(formatTestError(c.name, problem.Errors, c.expectedViolation))
+		}
+	}
+}
+
+func TestUserEmailConflict(t *testing.T) {
+	t.Parallel()
+
+	testUserEmailConflict(newMemoryApiConfig(), t)
+}
+
+// Same, for the unique constraint on users.email
+func TestUserEmailConflictPostgres(t *testing.T) {
+	t.Parallel()
+
+	testUserEmailConflict(newTestApiConfig(t), t)
+}
+
+// Creating a user, or changing a user's email, to another user's email is a 409
+func testUserEmailConflict(cfg *apiConfig, t *testing.T) {
+	t.Helper()
+
+	user, password := newTestUser(t, cfg)
+	otherUser, otherPassword := newTestUser(t, cfg)
+	otherTokens := newTestTokens(t, cfg, otherUser.Email, otherPassword)
+
+	cases := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		token   string
+	}{
+		{
+			name:    "Create with a taken email",
+			method:  "POST",
+			handler: cfg.createUserHandler(),
+		},
+		{
+			name:    "Update to a taken email",
+			method:  "PUT",
+			handler: cfg.updateUserHandler(),
+			token:   otherTokens.Token,
+		},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest(c.method, "/api/users", strings.NewReader(fmt.Sprintf(`{"email": "%v", "password": "%v"}`, user.Email, password+"-new")))
+		if c.token != "" {
+			request.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		c.handler(w, request)
+
+		assertEquals(w.Result().StatusCode, http.StatusConflict, c.name, t)
+
+		problem := Problem{}
+		err := json.NewDecoder(w.Result().Body).Decode(&problem)
+		if err != nil {
+			t.Error(formatTestError(c.name, err, "problem response"))
+			continue
+		}
+		assertEquals(problem.Type, PROBLEM_CONFLICT, c.name, t)
+	}
+}
+
 func deleteAllUsersAndPosts(cfg *apiConfig) error {
 	if cfg.platform != "dev" {
 		return fmt.Errorf("cannot call /api/reset in non-dev environment")
@@ -101,7 +217,7 @@ func createTestUsers(cfg *apiConfig, numUsers int) ([]User, []string, error) {
 
 	for i := range numUsers {
 		email := fmt.Sprintf("testuser_%v@gmail.com", i)
-		pw := fmt.Sprintf("abc00%v", i)
+		pw := fmt.Sprintf("abc00%v-password", i)
 
 		user, _, err := createTestUser(cfg, email, pw)
 		if err != nil {
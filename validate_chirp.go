@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"net/http"
 	"strings"
 )
@@ -16,27 +15,22 @@ func validateChirpHandler(w http.ResponseWriter, r *http.Request) {
 		Body string `json:"body"`
 	}{}
 
-	// Decode request
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&req)
-
-	if err != nil {
-		sendErrorJSONResponse(w, "Something went wrong", http.StatusInternalServerError, err)
+	if !decodeJSONBody(w, r, &req) {
 		return
 	}
 
 	if len(req.Body) == 0 {
-		sendErrorJSONResponse(w, "Something went wrong", http.StatusBadRequest, nil)
+		sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_REQUIRED, Message: "Chirp cannot be empty"})
 		return
 	}
 
-	// "Chirps" must be 140 characters or fewer
-	if len(req.Body) <= 140 {
+	// "Chirps" must be 140 characters or fewer, longer chirps are checked against the user's plan when posted
+	if len(req.Body) <= planEntitlements[PLAN_FREE].MaxChirpLength {
 		resp.Body = censoredBannedWords(req.Body)
 		SendJSONResponse(w, http.StatusOK, resp)
 		return
 	} else {
-		sendErrorJSONResponse(w, "Chirp is too long", http.StatusBadRequest, nil)
+		sendValidationErrorResponse(w, FieldError{Field: "body", Code: FIELD_TOO_LONG, Message: "Chirp is too long"})
 		return
 	}
 }
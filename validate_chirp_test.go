@@ -74,8 +74,8 @@ func TestValidateChirpHandler(t *testing.T) {
 		{
 			name:               "Input too long",
 			inputBody:          `{"body":"lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum."}`,
-			expectedBody:       `{"error":"Chirp is too long"}`,
-			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `{"type":"/problems/validation-failed","title":"Request failed validation","status":422,"detail":"Chirp is too long","errors":[{"field":"body","code":"too_long","message":"Chirp is too long"}]}`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
 		},
 	}
 
@@ -1,62 +1,140 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
-	"github.com/LamontBanks/Chirpy/internal/auth"
+	"github.com/LamontBanks/Chirpy/internal/database"
+	"github.com/LamontBanks/Chirpy/internal/webhook"
 	"github.com/google/uuid"
 )
 
+// Header with the timestamp and HMAC of the raw body, see internal/webhook
+const POLKA_SIGNATURE_HEADER = "Polka-Signature"
+
+// Polka's webhook payloads are small, anything bigger isn't from Polka
+const MAX_WEBHOOK_BODY_BYTES = 1 << 20
+
 type PolkaWebhookRequest struct {
-	Event string `json:"event"` // "user.upgraded"
+	ID    string `json:"id"`    // Unique per event, the same for retries of that event
+	Event string `json:"event"` // ex: "user.upgraded", see polkaEventHandlers
 	Data  struct {
-		UserID uuid.UUID `json:"user_id"`
+		UserID      uuid.UUID  `json:"user_id"`
+		Plan        string     `json:"plan"`         // Optional, defaults to "chirpy_red"
+		PeriodStart *time.Time `json:"period_start"` // Optional, see PolkaWebhookRequest.period
+		PeriodEnd   *time.Time `json:"period_end"`
 	} `json:"data"`
 }
 
-func (cfg *apiConfig) handlerUserUpgraded() http.HandlerFunc {
+// Keeps Chirpy Red subscriptions in sync with Polka, see subscriptions.go
+// Every delivery with a valid signature is logged before it's processed, see inbound_webhooks.go
+func (cfg *apiConfig) polkaWebhookHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check API Key
-		polkaAPIKey, err := auth.GetAPIKey(r.Header)
-		if err != nil {
-			sendResponse(w, http.StatusUnauthorized, err.Error())
-			return
-		}
-		if polkaAPIKey != cfg.polkaAPIKey {
-			sendResponse(w, http.StatusUnauthorized, "Invalid Polda API Key")
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_BODY_BYTES))
+		maxBytesErr := &http.MaxBytesError{}
+		if errors.As(err, &maxBytesErr) {
+			sendResponse(w, http.StatusRequestEntityTooLarge, err.Error())
 			return
 		}
-
-		// Decode request to validate body parameters
-		req := PolkaWebhookRequest{}
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&req)
 		if err != nil {
 			sendResponse(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		// Validate the request fields
-		if req.Event != "user.upgraded" {
-			sendResponse(w, http.StatusNoContent, fmt.Sprintf("Chirpy Red upgrade handler ignoring event: %v", req.Event))
-			return
-		}
-
-		if req.Data.UserID == uuid.Nil {
-			sendResponse(w, http.StatusNotFound, fmt.Sprintf("Chirpy Red upgrade request missing data.user_id: %v", req))
+		// Verify the signature over the exact bytes sent, before decoding or saving them
+		// Unverified deliveries aren't logged, otherwise anyone could fill the log
+		err = webhook.Verify(r.Header.Get(POLKA_SIGNATURE_HEADER), body, cfg.polkaWebhookSecrets, webhook.DEFAULT_TOLERANCE, time.Now())
+		if err != nil {
+			inboundWebhooksTotal.WithLabelValues(WEBHOOK_REJECTED).Inc()
+			sendResponse(w, http.StatusUnauthorized, fmt.Sprintf("Polka webhook from %v rejected, invalid signature: %v", clientIP(r), err))
 			return
 		}
 
-		// Upgrade the user to "Chirpy Red"
-		user, err := cfg.db.UpgradeUserToChirpyRed(r.Context(), req.Data.UserID)
+		// Polka retries if the delivery can't be logged
+		delivery, err := cfg.logInboundWebhook(r.Context(), WEBHOOK_SOURCE_POLKA, r.Header, body)
 		if err != nil {
-			sendResponse(w, http.StatusNotFound, fmt.Sprintf("Chirpy Red upgrade failed for user %v: %v", req.Data.UserID, err))
+			sendResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error logging Polka webhook: %v", err))
 			return
 		}
 
-		// Response
-		sendResponse(w, http.StatusNoContent, fmt.Sprintf("Chirpy Red status for user %v changed to %v", user.ID, user.IsChirpyRed))
+		statusCode, msg := cfg.processPolkaWebhook(r.Context(), delivery.ID, body)
+		sendResponse(w, statusCode, msg)
+	}
+}
+
+// Handles a logged Polka delivery whose signature was verified, and records the outcome in the log
+// Returns the status code for Polka (which retries on errors) and a message to log
+func (cfg *apiConfig) processPolkaWebhook(ctx context.Context, deliveryID uuid.UUID, body []byte) (int, string) {
+	// Finishes even if Polka disconnects, so the delivery isn't left processing
+	ctx = context.WithoutCancel(ctx)
+
+	// Decode request to validate body parameters
+	req := PolkaWebhookRequest{}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_REJECTED, http.StatusBadRequest, err)
+	}
+
+	// Validate the request fields
+	if req.ID == "" {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_REJECTED, http.StatusBadRequest, errors.New("missing id"))
 	}
+
+	if _, ok := polkaEventHandlers[req.Event]; !ok {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_IGNORED, http.StatusNoContent, fmt.Errorf("unhandled event %v", req.Event))
+	}
+
+	if req.Data.UserID == uuid.Nil {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_REJECTED, http.StatusNotFound, errors.New("missing data.user_id"))
+	}
+
+	// Ignore events another delivery already handled, or is handling
+	// Deliveries that stopped while processing (ex: the server crashed) don't count once their lease expires
+	err = cfg.db.ExpireInboundWebhookLeases(ctx, time.Now())
+	if err != nil {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_FAILED, http.StatusInternalServerError, err)
+	}
+
+	rowsClaimed, err := cfg.db.ClaimInboundWebhook(ctx, database.ClaimInboundWebhookParams{
+		EventID:         sql.NullString{String: req.ID, Valid: true},
+		Event:           sql.NullString{String: req.Event, Valid: true},
+		ProcessingUntil: sql.NullTime{Time: time.Now().Add(WEBHOOK_PROCESSING_LEASE), Valid: true},
+		UpdatedAt:       time.Now(),
+		ID:              deliveryID,
+	})
+	if err != nil {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_FAILED, http.StatusInternalServerError, err)
+	}
+	if rowsClaimed == 0 {
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_DUPLICATE, http.StatusNoContent, fmt.Errorf("event %v already handled", req.ID))
+	}
+
+	// Update the subscription, and mark the delivery processed
+	// Canceled before the lease expires, so a retry or replay can't apply the event while this is still running
+	processCtx, cancel := context.WithTimeout(ctx, WEBHOOK_PROCESSING_TIMEOUT)
+	defer cancel()
+
+	user, err := cfg.handlePolkaEvent(processCtx, deliveryID, req, time.Now())
+	if errors.Is(err, errWebhookLeaseExpired) {
+		// Nothing was applied, the delivery is failed by ExpireInboundWebhookLeases
+		inboundWebhooksTotal.WithLabelValues(WEBHOOK_FAILED).Inc()
+		return http.StatusInternalServerError, fmt.Sprintf("Webhook %v %v: %v", deliveryID, WEBHOOK_FAILED, err)
+	}
+	if err != nil {
+		// Failed deliveries don't block Polka's retries or admin replays of the event
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errSubscriptionNotFound) {
+			statusCode = http.StatusNotFound
+		}
+		return cfg.finishInboundWebhook(ctx, deliveryID, WEBHOOK_FAILED, statusCode, fmt.Errorf("%v event for user %v: %v", req.Event, req.Data.UserID, err))
+	}
+
+	inboundWebhooksTotal.WithLabelValues(WEBHOOK_PROCESSED).Inc()
+	return http.StatusNoContent, fmt.Sprintf("Chirpy Red status for user %v changed to %v after %v", user.ID, user.IsChirpyRed, req.Event)
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LamontBanks/Chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+func TestPolkaWebhookSignatures(t *testing.T) {
+	t.Parallel()
+
+	cfg := newTestApiConfig(t)
+	cfg.polkaWebhookSecrets = []string{"new-secret", "old-secret"}
+
+	users, _, err := createTestUsers(cfg, 3)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	upgradeBody := func(eventID string, userID uuid.UUID) string {
+		return fmt.Sprintf(`{"id": %q, "event": "user.upgraded", "data": {"user_id": %q}}`, eventID, userID)
+	}
+
+	// Rejected, user isn't upgraded
+	rejectedBody := upgradeBody(uuid.NewString(), users[0].ID)
+	cases := []struct {
+		name      string
+		signature string
+	}{
+		{
+			name:      "Missing signature",
+			signature: "",
+		},
+		{
+			name:      "Unknown secret",
+			signature: webhook.Sign([]byte(rejectedBody), time.Now(), "retired-secret"),
+		},
+		{
+			name:      "Stale timestamp",
+			signature: webhook.Sign([]byte(rejectedBody), time.Now().Add(-time.Hour), "new-secret"),
+		},
+		{
+			name:      "Signed a different body",
+			signature: webhook.Sign([]byte(upgradeBody(uuid.NewString(), users[1].ID)), time.Now(), "new-secret"),
+		},
+	}
+
+	for _, c := range cases {
+		w := sendPolkaWebhook(cfg, rejectedBody, c.signature)
+		assertEquals(w.Result().StatusCode, http.StatusUnauthorized, c.name, t)
+	}
+	assertEquals(isChirpyRed(cfg, users[0].ID, t), false, "rejected webhooks don't upgrade", t)
+
+	// Signed with either active secret
+	for i, secret := range cfg.polkaWebhookSecrets {
+		body := upgradeBody(uuid.NewString(), users[i].ID)
+		w := sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), secret))
+		assertEquals(w.Result().StatusCode, http.StatusNoContent, "signed with "+secret, t)
+		assertEquals(isChirpyRed(cfg, users[i].ID, t), true, "upgraded with "+secret, t)
+	}
+
+	// Unknown user, the event can be retried
+	retriedEventID := uuid.NewString()
+	body := upgradeBody(retriedEventID, uuid.New())
+	w := sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), "new-secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNotFound, "unknown user", t)
+
+	body = upgradeBody(retriedEventID, users[2].ID)
+	w = sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), "new-secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "retry after failure", t)
+	assertEquals(isChirpyRed(cfg, users[2].ID, t), true, "upgraded on retry", t)
+
+	// Replayed event ID is ignored, even re-signed with a fresh timestamp
+	replayedEventID := uuid.NewString()
+	newUsers, _, err := createTestUsers(cfg, 1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	body = upgradeBody(replayedEventID, users[0].ID)
+	sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), "new-secret"))
+
+	body = upgradeBody(replayedEventID, newUsers[0].ID)
+	w = sendPolkaWebhook(cfg, body, webhook.Sign([]byte(body), time.Now(), "new-secret"))
+	assertEquals(w.Result().StatusCode, http.StatusNoContent, "replayed event", t)
+	assertEquals(isChirpyRed(cfg, newUsers[0].ID, t), false, "replayed event doesn't upgrade", t)
+}
+
+func sendPolkaWebhook(cfg *apiConfig, body, signature string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+	if signature != "" {
+		request.Header.Set(POLKA_SIGNATURE_HEADER, signature)
+	}
+	w := httptest.NewRecorder()
+	cfg.polkaWebhookHandler()(w, request)
+	return w
+}
+
+func isChirpyRed(cfg *apiConfig, userID uuid.UUID, t *testing.T) bool {
+	t.Helper()
+
+	user, err := cfg.users.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	return user.IsChirpyRed
+}